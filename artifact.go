@@ -0,0 +1,71 @@
+package imgutil
+
+import (
+	"fmt"
+	"io"
+)
+
+// Artifact types of common artifacts attached to images.
+const (
+	ArtifactTypeSPDX      = "application/spdx+json"
+	ArtifactTypeCycloneDX = "application/vnd.cyclonedx+json"
+	ArtifactTypeInToto    = "application/vnd.in-toto+json"
+)
+
+// Artifact describes content attached to an image, such as an SBOM, an attestation or build provenance.
+type Artifact struct {
+	// ArtifactType identifies the kind of artifact, e.g. ArtifactTypeSPDX.
+	ArtifactType string
+	// MediaType is the media type of the artifact content. Defaults to ArtifactType.
+	MediaType string
+	// Annotations are stored with the artifact and returned when listing artifacts.
+	Annotations map[string]string
+	// Digest identifies the stored artifact. It is set by the Image.
+	Digest string
+}
+
+// ArtifactImage is an Image that artifacts can be attached to.
+type ArtifactImage interface {
+	Image
+	// AttachArtifact stores content as an artifact referring to the image, returning the stored artifact.
+	AttachArtifact(artifact Artifact, content []byte) (Artifact, error)
+	// Artifacts lists the artifacts referring to the image.
+	Artifacts() ([]Artifact, error)
+	// ArtifactContent returns a reader of the content of a stored artifact.
+	ArtifactContent(artifact Artifact) (io.ReadCloser, error)
+}
+
+// AttachArtifact attaches content to img as an artifact, if img supports artifacts.
+func AttachArtifact(img Image, artifact Artifact, content []byte) (Artifact, error) {
+	artifactImage, err := asArtifactImage(img)
+	if err != nil {
+		return Artifact{}, err
+	}
+	return artifactImage.AttachArtifact(artifact, content)
+}
+
+// Artifacts lists the artifacts attached to img, if img supports artifacts.
+func Artifacts(img Image) ([]Artifact, error) {
+	artifactImage, err := asArtifactImage(img)
+	if err != nil {
+		return nil, err
+	}
+	return artifactImage.Artifacts()
+}
+
+// ArtifactContent returns a reader of the content of an artifact attached to img, if img supports artifacts.
+func ArtifactContent(img Image, artifact Artifact) (io.ReadCloser, error) {
+	artifactImage, err := asArtifactImage(img)
+	if err != nil {
+		return nil, err
+	}
+	return artifactImage.ArtifactContent(artifact)
+}
+
+func asArtifactImage(img Image) (ArtifactImage, error) {
+	artifactImage, ok := img.(ArtifactImage)
+	if !ok {
+		return nil, fmt.Errorf("image '%s' does not support artifacts", img.Name())
+	}
+	return artifactImage, nil
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/pkg/errors"
+)
+
+// EnvRegistryAuth is the environment variable read by NewEnvKeychain by default.
+const EnvRegistryAuth = "CNB_REGISTRY_AUTH"
+
+// NewKeychain returns a keychain that resolves credentials, in order of precedence, from staticCredentials,
+// from the EnvRegistryAuth environment variable and from the Docker config.json in dockerConfigDir.
+// An empty dockerConfigDir uses the DOCKER_CONFIG environment variable or the default Docker config directory.
+func NewKeychain(dockerConfigDir string, staticCredentials map[string]authn.AuthConfig) (authn.Keychain, error) {
+	envKeychain, err := NewEnvKeychain(EnvRegistryAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	return authn.NewMultiKeychain(
+		NewStaticKeychain(staticCredentials),
+		envKeychain,
+		NewDockerConfigKeychain(dockerConfigDir),
+	), nil
+}
+
+type dockerConfigKeychain struct {
+	dir string
+}
+
+// NewDockerConfigKeychain returns a keychain that resolves credentials from the Docker config.json in dir,
+// running the docker-credential-* helpers configured through `credsStore` and `credHelpers`.
+// An empty dir uses the DOCKER_CONFIG environment variable or the default Docker config directory.
+func NewDockerConfigKeychain(dir string) authn.Keychain {
+	return &dockerConfigKeychain{dir: dir}
+}
+
+func (k *dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	dir := k.dir
+	if dir == "" {
+		dir = os.Getenv("DOCKER_CONFIG")
+	}
+
+	cf, err := config.Load(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading docker config from '%s'", dir)
+	}
+
+	key := target.RegistryStr()
+	if key == name.DefaultRegistry {
+		key = authn.DefaultAuthKey
+	}
+
+	cfg, err := cf.GetAuthConfig(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting credentials for '%s'", target.RegistryStr())
+	}
+
+	auth := authn.AuthConfig{
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		Auth:          cfg.Auth,
+		IdentityToken: cfg.IdentityToken,
+		RegistryToken: cfg.RegistryToken,
+	}
+	if auth == (authn.AuthConfig{}) {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(auth), nil
+}
+
+type staticKeychain struct {
+	credentials map[string]authn.AuthConfig
+}
+
+// NewStaticKeychain returns a keychain that resolves the given credentials by registry host.
+func NewStaticKeychain(credentials map[string]authn.AuthConfig) authn.Keychain {
+	normalized := map[string]authn.AuthConfig{}
+	for registry, cfg := range credentials {
+		normalized[normalizeRegistry(registry)] = cfg
+	}
+	return &staticKeychain{credentials: normalized}
+}
+
+func (k *staticKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	cfg, ok := k.credentials[normalizeRegistry(target.RegistryStr())]
+	if !ok {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(cfg), nil
+}
+
+// NewEnvKeychain returns a keychain that resolves credentials from the JSON object in the environment variable
+// envVar, which maps registry hosts to authorization values. See ParseRegistryAuth for the supported values.
+// An unset variable resolves every registry anonymously.
+func NewEnvKeychain(envVar string) (authn.Keychain, error) {
+	authJSON, ok := os.LookupEnv(envVar)
+	if !ok || authJSON == "" {
+		return NewStaticKeychain(nil), nil
+	}
+
+	credentials, err := ParseRegistryAuth(authJSON)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing environment variable '%s'", envVar)
+	}
+	return NewStaticKeychain(credentials), nil
+}
+
+// ParseRegistryAuth parses a JSON object mapping registry hosts to authorization values, e.g.
+// `{"registry.example.com": "Basic dXNlcjpwYXNz"}`. Values may be a "Basic" or "Bearer" authorization header
+// value, or a base64-encoded JSON object with "username" and "password" fields.
+func ParseRegistryAuth(authJSON string) (map[string]authn.AuthConfig, error) {
+	var values map[string]string
+	if err := json.Unmarshal([]byte(authJSON), &values); err != nil {
+		return nil, errors.Wrap(err, "parsing registry auth")
+	}
+
+	credentials := map[string]authn.AuthConfig{}
+	for registry, value := range values {
+		cfg, err := parseAuthValue(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing auth for registry '%s'", registry)
+		}
+		credentials[registry] = cfg
+	}
+	return credentials, nil
+}
+
+func parseAuthValue(value string) (authn.AuthConfig, error) {
+	switch {
+	case strings.HasPrefix(value, "Basic "):
+		return authn.AuthConfig{Auth: strings.TrimPrefix(value, "Basic ")}, nil
+	case strings.HasPrefix(value, "Bearer "):
+		return authn.AuthConfig{RegistryToken: strings.TrimPrefix(value, "Bearer ")}, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		if decoded, err = base64.URLEncoding.DecodeString(value); err != nil {
+			return authn.AuthConfig{}, errors.New(`value must be a "Basic" or "Bearer" authorization or base64-encoded JSON`)
+		}
+	}
+
+	var cfg authn.AuthConfig
+	if err := json.Unmarshal(decoded, &cfg); err != nil {
+		return authn.AuthConfig{}, errors.Wrap(err, "parsing encoded auth")
+	}
+	return cfg, nil
+}
+
+func normalizeRegistry(registry string) string {
+	r, err := name.NewRegistry(registry, name.WeakValidation)
+	if err != nil {
+		return registry
+	}
+	return r.RegistryStr()
+}
@@ -0,0 +1,163 @@
+package auth_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/auth"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestKeychain(t *testing.T) {
+	spec.Run(t, "Keychain", testKeychain, spec.Sequential(), spec.Report(report.Terminal{}))
+}
+
+func testKeychain(t *testing.T, when spec.G, it spec.S) {
+	var dockerConfigDir string
+
+	it.Before(func() {
+		var err error
+		dockerConfigDir, err = ioutil.TempDir("", "test.docker.config.dir")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.RemoveAll(dockerConfigDir))
+	})
+
+	resolve := func(keychain authn.Keychain, registry string) *authn.AuthConfig {
+		t.Helper()
+		reg, err := name.NewRegistry(registry, name.WeakValidation)
+		h.AssertNil(t, err)
+		authenticator, err := keychain.Resolve(reg)
+		h.AssertNil(t, err)
+		cfg, err := authenticator.Authorization()
+		h.AssertNil(t, err)
+		return cfg
+	}
+
+	writeDockerConfig := func(contents string) {
+		h.AssertNil(t, ioutil.WriteFile(filepath.Join(dockerConfigDir, "config.json"), []byte(contents), 0600))
+	}
+
+	when("#NewDockerConfigKeychain", func() {
+		it("reads auths from config.json", func() {
+			writeDockerConfig(`{"auths": {"registry.example.com": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:pass")) + `"}}}`)
+
+			cfg := resolve(auth.NewDockerConfigKeychain(dockerConfigDir), "registry.example.com")
+			h.AssertEq(t, cfg.Username, "user")
+			h.AssertEq(t, cfg.Password, "pass")
+		})
+
+		it("resolves unknown registries anonymously", func() {
+			writeDockerConfig(`{"auths": {}}`)
+
+			cfg := resolve(auth.NewDockerConfigKeychain(dockerConfigDir), "registry.example.com")
+			h.AssertEq(t, cfg, &authn.AuthConfig{})
+		})
+
+		it("runs credential helpers", func() {
+			if runtime.GOOS == "windows" {
+				t.Skip("credential helper fixture is a shell script")
+			}
+
+			binDir, err := ioutil.TempDir("", "test.credential.helper")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(binDir)
+
+			h.AssertNil(t, ioutil.WriteFile(
+				filepath.Join(binDir, "docker-credential-imgutil-test"),
+				[]byte("#!/bin/sh\nread registry\necho '{\"ServerURL\":\"'$registry'\",\"Username\":\"helper-user\",\"Secret\":\"helper-secret\"}'\n"),
+				0755,
+			))
+			defer os.Setenv("PATH", os.Getenv("PATH"))
+			h.AssertNil(t, os.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH")))
+
+			writeDockerConfig(`{"credHelpers": {"registry.example.com": "imgutil-test"}}`)
+
+			cfg := resolve(auth.NewDockerConfigKeychain(dockerConfigDir), "registry.example.com")
+			h.AssertEq(t, cfg.Username, "helper-user")
+			h.AssertEq(t, cfg.Password, "helper-secret")
+		})
+	})
+
+	when("#ParseRegistryAuth", func() {
+		it("parses basic, bearer and encoded JSON values", func() {
+			registry := h.NewDockerRegistryWithAuth(dockerConfigDir)
+
+			credentials, err := auth.ParseRegistryAuth(`{
+				"basic.example.com": "Basic dXNlcjpwYXNz",
+				"bearer.example.com": "Bearer some-token",
+				"labeled.example.com": "` + registry.EncodedLabeledAuth() + `"
+			}`)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, credentials["basic.example.com"], authn.AuthConfig{Auth: "dXNlcjpwYXNz"})
+			h.AssertEq(t, credentials["bearer.example.com"], authn.AuthConfig{RegistryToken: "some-token"})
+			h.AssertNotEq(t, credentials["labeled.example.com"].Username, "")
+			h.AssertNotEq(t, credentials["labeled.example.com"].Password, "")
+		})
+
+		it("returns an error for invalid values", func() {
+			_, err := auth.ParseRegistryAuth(`{"registry.example.com": "%%%"}`)
+			h.AssertError(t, err, "parsing auth for registry 'registry.example.com'")
+		})
+	})
+
+	when("#NewEnvKeychain", func() {
+		it("reads credentials from the environment variable", func() {
+			defer os.Unsetenv("IMGUTIL_TEST_REGISTRY_AUTH")
+			h.AssertNil(t, os.Setenv("IMGUTIL_TEST_REGISTRY_AUTH", `{"registry.example.com": "Basic dXNlcjpwYXNz"}`))
+
+			keychain, err := auth.NewEnvKeychain("IMGUTIL_TEST_REGISTRY_AUTH")
+			h.AssertNil(t, err)
+
+			cfg := resolve(keychain, "registry.example.com")
+			h.AssertEq(t, cfg.Auth, "dXNlcjpwYXNz")
+		})
+	})
+
+	when("#NewStaticKeychain", func() {
+		it("normalizes Docker Hub registry names", func() {
+			keychain := auth.NewStaticKeychain(map[string]authn.AuthConfig{
+				"docker.io": {Username: "hub-user", Password: "hub-pass"},
+			})
+
+			cfg := resolve(keychain, "index.docker.io")
+			h.AssertEq(t, cfg.Username, "hub-user")
+		})
+	})
+
+	when("#NewKeychain", func() {
+		it("prefers static over environment over Docker config credentials", func() {
+			writeDockerConfig(`{"auths": {
+				"static.example.com": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("config:config")) + `"},
+				"env.example.com": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("config:config")) + `"},
+				"config.example.com": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("config:config")) + `"}
+			}}`)
+			defer os.Unsetenv(auth.EnvRegistryAuth)
+			h.AssertNil(t, os.Setenv(auth.EnvRegistryAuth, `{
+				"static.example.com": "Bearer env",
+				"env.example.com": "Bearer env"
+			}`))
+
+			keychain, err := auth.NewKeychain(dockerConfigDir, map[string]authn.AuthConfig{
+				"static.example.com": {RegistryToken: "static"},
+			})
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, resolve(keychain, "static.example.com").RegistryToken, "static")
+			h.AssertEq(t, resolve(keychain, "env.example.com").RegistryToken, "env")
+			h.AssertEq(t, resolve(keychain, "config.example.com").Username, "config")
+		})
+	})
+}
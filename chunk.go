@@ -0,0 +1,177 @@
+package imgutil
+
+import (
+	"archive/tar"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil/layer"
+)
+
+// a chunk of a size limited layer ends after an entry whose path hashes to 0 modulo chunkBoundaryModulus, once it has
+// half of the size limit, so that chunk boundaries only depend on the entries around them
+const chunkBoundaryModulus = 8
+
+type ChunkOption func(*chunkOptions)
+
+type chunkOptions struct {
+	paths     []string
+	size      int64
+	chunksDir string
+}
+
+// WithChunkPaths puts the entries under each of paths, e.g. "/usr" or "/app", in separate chunks, in the order of
+// paths, above a chunk with the other entries. The paths of Windows layers are relative to their "Files" directory.
+func WithChunkPaths(paths ...string) ChunkOption {
+	return func(opts *chunkOptions) {
+		for _, p := range paths {
+			opts.paths = append(opts.paths, path.Clean("/"+p))
+		}
+	}
+}
+
+// WithChunkSize splits the entries, or the entries under each path of WithChunkPaths, into chunks with at most size
+// bytes of file contents, unless a single file is larger. Chunk boundaries are chosen by the paths of the entries, so
+// that adding, removing or changing a file usually only changes its own chunk.
+func WithChunkSize(size int64) ChunkOption {
+	return func(opts *chunkOptions) {
+		opts.size = size
+	}
+}
+
+// WithChunksDir writes the chunks to dir instead of a new temporary directory.
+func WithChunksDir(dir string) ChunkOption {
+	return func(opts *chunkOptions) {
+		opts.chunksDir = dir
+	}
+}
+
+// AddLayerChunks splits the layer tar at layerPath into several layers as configured by ops, and adds them to img with
+// AddLayerWithDiffID. The split is deterministic: chunks with the same entries have the same diff ids, so that they can
+// be reused with ReuseLayer. Whiteouts are put in the lowest chunk with entries in their directory, so that the
+// chunks stack to the filesystem of the layer. It returns the paths of the chunks, which must exist until img is saved. When it fails, the
+// chunks are removed unless they were written to the directory of WithChunksDir.
+func AddLayerChunks(img Image, layerPath string, ops ...ChunkOption) ([]string, error) {
+	opts := &chunkOptions{}
+	for _, op := range ops {
+		op(opts)
+	}
+
+	osType, err := img.OS()
+	if err != nil {
+		return nil, err
+	}
+
+	chunksDir := opts.chunksDir
+	if chunksDir == "" {
+		if chunksDir, err = ioutil.TempDir("", "imgutil-chunks"); err != nil {
+			return nil, err
+		}
+	}
+	chunks, err := splitLayerTar(layerPath, chunksDir, osType, opts)
+	if err != nil {
+		if opts.chunksDir == "" {
+			os.RemoveAll(chunksDir)
+		}
+		return nil, errors.Wrapf(err, "splitting layer '%s'", layerPath)
+	}
+	chunkPaths, err := addLayerTars(img, chunks)
+	if err != nil && opts.chunksDir == "" {
+		os.RemoveAll(chunksDir)
+	}
+	return chunkPaths, err
+}
+
+// chunkState is the chunk that the next entry of a group of paths is written to.
+type chunkState struct {
+	chunk int
+	cut   bool
+}
+
+func splitLayerTar(layerPath, chunksDir, osType string, opts *chunkOptions) ([]layerTar, error) {
+	f, err := os.Open(layerPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	splitter := newLayerTarSplitter(chunksDir)
+	states := map[int]*chunkState{}
+	// dirChunks holds the earliest chunk with entries in each directory, by path
+	dirChunks := map[string]layerKey{}
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			splitter.close()
+			return nil, err
+		}
+
+		filePath, inFilesystem := layerEntryPath(osType, header.Name)
+		group := 0
+		if inFilesystem {
+			group = pathRuleIndex(opts.paths, filePath)
+		}
+		whiteout := inFilesystem && strings.HasPrefix(path.Base(filePath), layer.WhiteoutPrefix)
+		state, ok := states[group]
+		if !ok {
+			state = &chunkState{}
+			states[group] = state
+		}
+
+		chunked := header.Typeflag != tar.TypeLink && !whiteout && opts.size > 0
+		if chunked {
+			size := splitter.size(layerKey{group: group, chunk: state.chunk})
+			if state.cut || (size > 0 && size+header.Size > opts.size) {
+				state.chunk++
+				state.cut = false
+			}
+		}
+
+		key := layerKey{group: group, chunk: state.chunk}
+		switch {
+		case whiteout:
+			// whiteouts, including opaque ones, only hide lower layers: they go to the earliest chunk with entries in
+			// their directory, so that they do not hide the entries of the layer put in lower chunks
+			if dirKey, ok := dirChunks[path.Dir(filePath)]; ok {
+				key = dirKey
+			}
+		case header.Typeflag == tar.TypeLink:
+			if linkKey, err := splitter.linkLayer(header); err == nil {
+				key = linkKey
+			}
+		}
+
+		if err := splitter.write(key, header, tr); err != nil {
+			splitter.close()
+			return nil, err
+		}
+		if chunked {
+			state.cut = splitter.size(key) >= opts.size/2 && chunkBoundary(filePath)
+		}
+		if inFilesystem {
+			for dir := filePath; dir != "/"; dir = path.Dir(dir) {
+				if dirKey, ok := dirChunks[dir]; !ok || key.before(dirKey) {
+					dirChunks[dir] = key
+				}
+			}
+		}
+	}
+	return splitter.close()
+}
+
+func chunkBoundary(filePath string) bool {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(filePath))
+	return h.Sum32()%chunkBoundaryModulus == 0
+}
@@ -0,0 +1,233 @@
+package imgutil_test
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/fakes"
+	"github.com/buildpacks/imgutil/layer"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestAddLayerChunks(t *testing.T) {
+	spec.Run(t, "AddLayerChunks", testAddLayerChunks, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testAddLayerChunks(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir string
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "chunks")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.RemoveAll(tmpDir))
+	})
+
+	chunksDir := func() string {
+		t.Helper()
+		dir, err := ioutil.TempDir(tmpDir, "chunks")
+		h.AssertNil(t, err)
+		return dir
+	}
+
+	// manyFiles returns 50 files of 100 bytes under dir, with contents that can be changed by suffix.
+	manyFiles := func(dir string, suffix string) []layerEntry {
+		var entries []layerEntry
+		for i := 0; i < 50; i++ {
+			contents := fmt.Sprintf("%-99d", i)[:99] + suffix
+			if suffix == "" {
+				contents += " "
+			}
+			entries = append(entries, file(fmt.Sprintf("%s/file-%02d", dir, i), contents))
+		}
+		return entries
+	}
+
+	it("splits the layer by paths", func() {
+		layerPath := writeLayer(t, tmpDir, "linux",
+			file("/etc/os-release", "some-os"),
+			file("/usr/lib/libssl.so", "libssl"),
+			file("/app/main.js", "app"),
+			file("/usr/bin/some-exe", "some-exe"),
+		)
+		img := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+
+		chunkPaths, err := imgutil.AddLayerChunks(img, layerPath, imgutil.WithChunkPaths("/usr", "/app"), imgutil.WithChunksDir(chunksDir()))
+		h.AssertNil(t, err)
+		h.AssertEq(t, len(chunkPaths), 3)
+
+		layers, err := img.Layers()
+		h.AssertNil(t, err)
+		h.AssertEq(t, regularFiles(t, img), map[string]string{
+			"/etc/os-release":    layers[0],
+			"/usr/lib/libssl.so": layers[1],
+			"/usr/bin/some-exe":  layers[1],
+			"/app/main.js":       layers[2],
+		})
+
+		// chunks have the parent directories of their entries
+		index, err := imgutil.LayerIndex(img)
+		h.AssertNil(t, err)
+		usr, ok := index.Find("/usr")
+		h.AssertEq(t, ok, true)
+		h.AssertEq(t, usr.Header.Name, "usr")
+	})
+
+	it("splits the layer by size", func() {
+		layerPath := writeLayer(t, tmpDir, "linux", manyFiles("/app", "")...)
+		img := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+
+		chunkPaths, err := imgutil.AddLayerChunks(img, layerPath, imgutil.WithChunkSize(1000), imgutil.WithChunksDir(chunksDir()))
+		h.AssertNil(t, err)
+		h.AssertEq(t, len(chunkPaths) > 1, true)
+
+		files := regularFiles(t, img)
+		h.AssertEq(t, len(files), 50)
+		sizes := map[string]int{}
+		for _, diffID := range files {
+			sizes[diffID] += 100
+		}
+		for diffID, size := range sizes {
+			if size > 1000 {
+				t.Fatalf("chunk '%s' has %d bytes", diffID, size)
+			}
+		}
+	})
+
+	it("keeps the diff ids of chunks with unchanged entries", func() {
+		entries := manyFiles("/app", "")
+		img := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+		_, err := imgutil.AddLayerChunks(img, writeLayer(t, tmpDir, "linux", entries...), imgutil.WithChunkSize(1000), imgutil.WithChunksDir(chunksDir()))
+		h.AssertNil(t, err)
+
+		entries[25] = manyFiles("/app", "x")[25]
+		changedImg := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+		_, err = imgutil.AddLayerChunks(changedImg, writeLayer(t, tmpDir, "linux", entries...), imgutil.WithChunkSize(1000), imgutil.WithChunksDir(chunksDir()))
+		h.AssertNil(t, err)
+
+		layers, err := img.Layers()
+		h.AssertNil(t, err)
+		changedLayers, err := changedImg.Layers()
+		h.AssertNil(t, err)
+		h.AssertEq(t, len(changedLayers), len(layers))
+
+		var changed []string
+		for i := range layers {
+			if layers[i] != changedLayers[i] {
+				changed = append(changed, changedLayers[i])
+			}
+		}
+		h.AssertEq(t, len(changed), 1)
+		h.AssertEq(t, regularFiles(t, changedImg)["/app/file-25"], changed[0])
+	})
+
+	it("keeps whiteouts below the entries of their directory", func() {
+		base := writeLayer(t, tmpDir, "linux",
+			file("/app/old", "old"),
+			file("/etc/removed", "removed"),
+			file("/etc/other", "other"),
+		)
+		layerPath := writeLayer(t, tmpDir, "linux",
+			file("/etc/keep", strings.Repeat("k", 100)),
+			file("/app/a", strings.Repeat("a", 100)),
+			file("/app/b", strings.Repeat("b", 100)),
+			layerEntry{header: &tar.Header{Name: "/app/" + layer.OpaqueWhiteout, Typeflag: tar.TypeReg}},
+			whiteout("/etc/removed"),
+			file("/app/c", "c"),
+		)
+		filePaths := func(img imgutil.Image) []string {
+			t.Helper()
+			index, err := imgutil.LayerIndex(img)
+			h.AssertNil(t, err)
+			var paths []string
+			for _, f := range index.Files() {
+				paths = append(paths, f.Path)
+			}
+			return paths
+		}
+
+		unsplit := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+		h.AssertNil(t, unsplit.AddLayer(base))
+		h.AssertNil(t, unsplit.AddLayer(layerPath))
+
+		img := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+		h.AssertNil(t, img.AddLayer(base))
+		chunkPaths, err := imgutil.AddLayerChunks(img, layerPath, imgutil.WithChunkSize(100), imgutil.WithChunksDir(chunksDir()))
+		h.AssertNil(t, err)
+		h.AssertEq(t, len(chunkPaths) > 2, true)
+
+		h.AssertEq(t, filePaths(img), []string{"/app", "/app/a", "/app/b", "/app/c", "/etc", "/etc/keep", "/etc/other"})
+		h.AssertEq(t, filePaths(img), filePaths(unsplit))
+	})
+
+	it("adds hardlinks to the chunk of their target", func() {
+		layerPath := writeLayer(t, tmpDir, "linux",
+			file("/usr/bin/some-exe", "some-exe"),
+			layerEntry{header: &tar.Header{Name: "/app/some-exe", Typeflag: tar.TypeLink, Linkname: "/usr/bin/some-exe"}},
+		)
+		img := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+
+		_, err := imgutil.AddLayerChunks(img, layerPath, imgutil.WithChunkPaths("/app"), imgutil.WithChunksDir(chunksDir()))
+		h.AssertNil(t, err)
+
+		index, err := imgutil.LayerIndex(img)
+		h.AssertNil(t, err)
+		target, ok := index.Find("/usr/bin/some-exe")
+		h.AssertEq(t, ok, true)
+		link, ok := index.Find("/app/some-exe")
+		h.AssertEq(t, ok, true)
+		h.AssertEq(t, link.Layer, target.Layer)
+		contents, err := index.ReadFile("/app/some-exe")
+		h.AssertNil(t, err)
+		h.AssertEq(t, string(contents), "some-exe")
+	})
+
+	it("removes the chunks when adding them fails", func() {
+		layerPath := writeLayer(t, tmpDir, "linux", file("/usr/bin/some-exe", "some-exe"))
+		img := &failingImage{Image: fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))}
+
+		_, err := imgutil.AddLayerChunks(img, layerPath)
+		h.AssertError(t, err, "some-error")
+
+		h.AssertEq(t, len(img.layerPaths), 1)
+		_, err = os.Stat(filepath.Dir(img.layerPaths[0]))
+		h.AssertEq(t, os.IsNotExist(err), true)
+	})
+
+	when("the image is a Windows image", func() {
+		it("matches paths relative to the Files directory", func() {
+			layerPath := writeLayer(t, tmpDir, "windows",
+				file("/Windows/some-file", "some-file"),
+				file("/app/main.js", "app"),
+			)
+			img := fakes.NewImage("some-image", "", nil, fakes.WithOS("windows"))
+
+			chunkPaths, err := imgutil.AddLayerChunks(img, layerPath, imgutil.WithChunkPaths("/app"), imgutil.WithChunksDir(chunksDir()))
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(chunkPaths), 2)
+
+			f, err := os.Open(chunkPaths[1])
+			h.AssertNil(t, err)
+			defer f.Close()
+			var names []string
+			tr := tar.NewReader(f)
+			for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+				names = append(names, header.Name)
+			}
+			h.AssertEq(t, names, []string{"Files", "Files/app", "Files/app/main.js"})
+			h.AssertEq(t, strings.HasPrefix(filepath.Base(chunkPaths[1]), "layer-1-"), true)
+		})
+	})
+}
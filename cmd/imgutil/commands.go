@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+)
+
+// lifecycleMetadataLabel holds the metadata written by the buildpacks lifecycle, including the top layer of the
+// run image an app image was built on.
+const lifecycleMetadataLabel = "io.buildpacks.lifecycle.metadata"
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	return flags
+}
+
+func parseImageArgs(name string, args []string, count int) ([]imageRef, error) {
+	if len(args) != count {
+		return nil, usageError(name)
+	}
+	refs := make([]imageRef, count)
+	for idx, arg := range args {
+		ref, err := parseImageRef(arg)
+		if err != nil {
+			return nil, err
+		}
+		refs[idx] = ref
+	}
+	return refs, nil
+}
+
+type inspectOutput struct {
+	Name         string            `json:"name"`
+	Identifier   string            `json:"identifier"`
+	ManifestSize int64             `json:"manifestSize"`
+	OS           string            `json:"os"`
+	OSVersion    string            `json:"osVersion,omitempty"`
+	Architecture string            `json:"architecture"`
+	Created      string            `json:"created"`
+	Labels       map[string]string `json:"labels"`
+	Env          []string          `json:"env"`
+	Entrypoint   []string          `json:"entrypoint"`
+	Cmd          []string          `json:"cmd"`
+	Layers       []string          `json:"layers"`
+}
+
+func runInspect(f *imageFactory, args []string, out io.Writer) error {
+	flags := newFlagSet("inspect")
+	jsonOutput := flags.Bool("json", false, "")
+	if err := flags.Parse(args); err != nil {
+		return usageError("inspect")
+	}
+	refs, err := parseImageArgs("inspect", flags.Args(), 1)
+	if err != nil {
+		return err
+	}
+
+	img, err := f.existingImage(refs[0])
+	if err != nil {
+		return err
+	}
+	output, err := inspect(img)
+	if err != nil {
+		return errors.Wrapf(err, "inspecting image '%s'", refs[0])
+	}
+	output.Name = refs[0].String()
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(output)
+	}
+	return printInspect(out, output)
+}
+
+func inspect(img imgutil.Image) (inspectOutput, error) {
+	var (
+		output inspectOutput
+		err    error
+	)
+
+	identifier, err := img.Identifier()
+	if err != nil {
+		return output, err
+	}
+	output.Identifier = identifier.String()
+	if output.ManifestSize, err = img.ManifestSize(); err != nil {
+		return output, err
+	}
+	if output.OS, err = img.OS(); err != nil {
+		return output, err
+	}
+	if output.OSVersion, err = img.OSVersion(); err != nil {
+		return output, err
+	}
+	if output.Architecture, err = img.Architecture(); err != nil {
+		return output, err
+	}
+	created, err := img.CreatedAt()
+	if err != nil {
+		return output, err
+	}
+	output.Created = created.UTC().Format("2006-01-02T15:04:05Z")
+	if output.Labels, err = img.Labels(); err != nil {
+		return output, err
+	}
+	if output.Entrypoint, err = img.Entrypoint(); err != nil {
+		return output, err
+	}
+
+	inspectable, ok := img.(imgutil.InspectableImage)
+	if !ok {
+		return output, nil
+	}
+	if output.Env, err = inspectable.EnvVars(); err != nil {
+		return output, err
+	}
+	if output.Cmd, err = inspectable.Cmd(); err != nil {
+		return output, err
+	}
+	if output.Layers, err = inspectable.Layers(); err != nil {
+		return output, err
+	}
+	return output, nil
+}
+
+func printInspect(out io.Writer, output inspectOutput) error {
+	platform := output.OS + "/" + output.Architecture
+	if output.OSVersion != "" {
+		platform += " (" + output.OSVersion + ")"
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", output.Name)
+	fmt.Fprintf(w, "Identifier:\t%s\n", output.Identifier)
+	fmt.Fprintf(w, "Manifest size:\t%d\n", output.ManifestSize)
+	fmt.Fprintf(w, "Platform:\t%s\n", platform)
+	fmt.Fprintf(w, "Created:\t%s\n", output.Created)
+	fmt.Fprintf(w, "Entrypoint:\t%s\n", formatList(output.Entrypoint))
+	fmt.Fprintf(w, "Cmd:\t%s\n", formatList(output.Cmd))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var labels []string
+	for k, v := range output.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	printSection(out, "Labels", labels)
+	printSection(out, "Env", output.Env)
+	printSection(out, "Layers", output.Layers)
+	return nil
+}
+
+func printSection(out io.Writer, title string, lines []string) {
+	fmt.Fprintf(out, "%s:\n", title)
+	if len(lines) == 0 {
+		fmt.Fprintln(out, "  (none)")
+	}
+	for _, line := range lines {
+		fmt.Fprintf(out, "  %s\n", line)
+	}
+}
+
+func formatList(values []string) string {
+	if len(values) == 0 {
+		return "(none)"
+	}
+	quoted := make([]string, len(values))
+	for idx, v := range values {
+		quoted[idx] = fmt.Sprintf("%q", v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func runRebase(f *imageFactory, args []string, out io.Writer) error {
+	flags := newFlagSet("rebase")
+	runImage := flags.String("run-image", "", "")
+	baseTopLayer := flags.String("base-top-layer", "", "")
+	if err := flags.Parse(args); err != nil || *runImage == "" {
+		return usageError("rebase")
+	}
+	refs, err := parseImageArgs("rebase", append(flags.Args(), *runImage), 2)
+	if err != nil {
+		return err
+	}
+	appRef, runRef := refs[0], refs[1]
+	if appRef.scheme != runRef.scheme {
+		return fmt.Errorf("image '%s' and run image '%s' must use the same backend", appRef, runRef)
+	}
+
+	img, err := f.existingImage(appRef)
+	if err != nil {
+		return err
+	}
+	newBase, err := f.existingImage(runRef)
+	if err != nil {
+		return err
+	}
+
+	if *baseTopLayer == "" {
+		if *baseTopLayer, err = runImageTopLayer(img); err != nil {
+			return err
+		}
+	}
+
+	if err := img.Rebase(*baseTopLayer, newBase); err != nil {
+		return err
+	}
+	if err := img.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Rebased %s on %s\n", appRef, runRef)
+	return nil
+}
+
+// runImageTopLayer reads the top layer of the run image from the lifecycle metadata of img.
+func runImageTopLayer(img imgutil.Image) (string, error) {
+	label, err := img.Label(lifecycleMetadataLabel)
+	if err != nil {
+		return "", err
+	}
+	if label == "" {
+		return "", fmt.Errorf("image '%s' has no label '%s', provide --base-top-layer", img.Name(), lifecycleMetadataLabel)
+	}
+
+	var metadata struct {
+		RunImage struct {
+			TopLayer string `json:"topLayer"`
+		} `json:"runImage"`
+	}
+	if err := json.Unmarshal([]byte(label), &metadata); err != nil {
+		return "", errors.Wrapf(err, "parsing label '%s'", lifecycleMetadataLabel)
+	}
+	if metadata.RunImage.TopLayer == "" {
+		return "", fmt.Errorf("label '%s' has no run image top layer, provide --base-top-layer", lifecycleMetadataLabel)
+	}
+	return metadata.RunImage.TopLayer, nil
+}
+
+func runCopy(f *imageFactory, args []string, out io.Writer) error {
+	refs, err := parseImageArgs("copy", args, 2)
+	if err != nil {
+		return err
+	}
+	srcRef, dstRef := refs[0], refs[1]
+
+	src, err := f.existingImage(srcRef)
+	if err != nil {
+		return err
+	}
+
+	var dst imgutil.Image
+	if srcRef.scheme == dstRef.scheme {
+		// the source is used as the base image, so that the backend copies its layers and config as is
+		dst, err = f.newImage(dstRef, srcRef)
+		if err != nil {
+			return err
+		}
+	} else {
+		dst, err = f.newImage(dstRef, imageRef{})
+		if err != nil {
+			return err
+		}
+		// the copied layers are read by dst on Save
+		layersDir, err := ioutil.TempDir("", "imgutil-copy")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(layersDir)
+		if err := copyImage(src, dst, layersDir); err != nil {
+			return errors.Wrapf(err, "copying image '%s'", srcRef)
+		}
+	}
+
+	if err := dst.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Copied %s to %s\n", srcRef, dstRef)
+	return nil
+}
+
+// copyImage copies the layers and config of src to dst through the imgutil.Image interface. The layers are written to
+// layersDir, which must be kept until dst is saved. The working directory is not exposed by imgutil.Image and is not
+// copied.
+func copyImage(src, dst imgutil.Image, layersDir string) error {
+	inspectable, ok := src.(imgutil.InspectableImage)
+	if !ok {
+		return fmt.Errorf("image '%s' does not support inspection", src.Name())
+	}
+
+	if err := copyPlatform(src, dst); err != nil {
+		return err
+	}
+
+	labels, err := src.Labels()
+	if err != nil {
+		return err
+	}
+	for k, v := range labels {
+		if err := dst.SetLabel(k, v); err != nil {
+			return err
+		}
+	}
+
+	envVars, err := inspectable.EnvVars()
+	if err != nil {
+		return err
+	}
+	for _, envVar := range envVars {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if err := dst.SetEnv(parts[0], parts[1]); err != nil {
+			return err
+		}
+	}
+
+	entrypoint, err := src.Entrypoint()
+	if err != nil {
+		return err
+	}
+	if err := dst.SetEntrypoint(entrypoint...); err != nil {
+		return err
+	}
+	cmd, err := inspectable.Cmd()
+	if err != nil {
+		return err
+	}
+	if err := dst.SetCmd(cmd...); err != nil {
+		return err
+	}
+
+	layers, err := inspectable.Layers()
+	if err != nil {
+		return err
+	}
+	for _, diffID := range layers {
+		if err := copyLayer(src, dst, diffID, layersDir); err != nil {
+			return errors.Wrapf(err, "copying layer '%s'", diffID)
+		}
+	}
+	return nil
+}
+
+func copyPlatform(src, dst imgutil.Image) error {
+	srcOS, err := src.OS()
+	if err != nil {
+		return err
+	}
+	if dstOS, err := dst.OS(); err != nil || dstOS != srcOS {
+		if err := dst.SetOS(srcOS); err != nil {
+			return err
+		}
+	}
+	osVersion, err := src.OSVersion()
+	if err != nil {
+		return err
+	}
+	if err := dst.SetOSVersion(osVersion); err != nil {
+		return err
+	}
+	architecture, err := src.Architecture()
+	if err != nil {
+		return err
+	}
+	return dst.SetArchitecture(architecture)
+}
+
+func copyLayer(src, dst imgutil.Image, diffID, layersDir string) error {
+	rc, err := src.GetLayer(diffID)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tmpFile, err := ioutil.TempFile(layersDir, "layer")
+	if err != nil {
+		return err
+	}
+	defer tmpFile.Close()
+
+	if _, err := io.Copy(tmpFile, rc); err != nil {
+		return err
+	}
+	return dst.AddLayerWithDiffID(tmpFile.Name(), diffID)
+}
+
+func runLabels(f *imageFactory, args []string, out io.Writer) error {
+	if len(args) < 3 || (args[0] != "set" && args[0] != "rm") {
+		return usageError("labels")
+	}
+	ref, err := parseImageRef(args[1])
+	if err != nil {
+		return err
+	}
+	img, err := f.existingImage(ref)
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args[2:] {
+		if args[0] == "rm" {
+			if err := img.RemoveLabel(arg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("label '%s' must be formatted as <key>=<value>", arg)
+		}
+		if err := img.SetLabel(parts[0], parts[1]); err != nil {
+			return err
+		}
+	}
+
+	if err := img.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Updated labels of %s\n", ref)
+	return nil
+}
+
+func runLayers(f *imageFactory, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "get" {
+		return usageError("layers")
+	}
+	flags := newFlagSet("layers")
+	output := flags.String("output", "", "")
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 2 {
+		return usageError("layers")
+	}
+	ref, err := parseImageRef(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	img, err := f.existingImage(ref)
+	if err != nil {
+		return err
+	}
+
+	rc, err := img.GetLayer(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	_, err = io.Copy(out, rc)
+	return err
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/fakes"
+	"github.com/buildpacks/imgutil/remote"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestCommands(t *testing.T) {
+	spec.Run(t, "Commands", testCommands, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testCommands(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server    *httptest.Server
+		host      string
+		factory   *imageFactory
+		out       *bytes.Buffer
+		layerPath string
+		layerID   string
+	)
+
+	it.Before(func() {
+		server = httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+		host = strings.TrimPrefix(server.URL, "http://")
+		factory = &imageFactory{keychain: authn.DefaultKeychain}
+		out = &bytes.Buffer{}
+
+		var err error
+		layerPath, err = h.CreateSingleFileLayerTar("/some-file.txt", "some-content", "linux")
+		h.AssertNil(t, err)
+		layerID = h.FileDiffID(t, layerPath)
+	})
+
+	it.After(func() {
+		server.Close()
+		h.AssertNil(t, os.Remove(layerPath))
+	})
+
+	saveImage := func(repoName string, labels map[string]string, layerPaths ...string) *remote.Image {
+		img, err := remote.NewImage(host+"/"+repoName, authn.DefaultKeychain)
+		h.AssertNil(t, err)
+		for k, v := range labels {
+			h.AssertNil(t, img.SetLabel(k, v))
+		}
+		for _, path := range layerPaths {
+			h.AssertNil(t, img.AddLayer(path))
+		}
+		h.AssertNil(t, img.Save())
+		return img
+	}
+
+	openImage := func(repoName string) *remote.Image {
+		img, err := remote.NewImage(host+"/"+repoName, authn.DefaultKeychain, remote.FromBaseImage(host+"/"+repoName))
+		h.AssertNil(t, err)
+		h.AssertEq(t, img.Found(), true)
+		return img
+	}
+
+	it("prints usage", func() {
+		h.AssertNil(t, run(factory, []string{"help"}, out))
+		h.AssertContains(t, strings.Split(out.String(), "\n"), "  inspect [--json] <image>")
+	})
+
+	it("returns an error for an unknown command", func() {
+		h.AssertError(t, run(factory, []string{"unknown"}, out), "unknown command 'unknown'")
+	})
+
+	it("requires a backend scheme in image references", func() {
+		h.AssertError(t, run(factory, []string{"inspect", host + "/some-image"}, out), "must start with docker:// or registry://")
+	})
+
+	when("inspect", func() {
+		it("prints the image", func() {
+			saveImage("some-image", map[string]string{"some-key": "some-value"}, layerPath)
+
+			h.AssertNil(t, run(factory, []string{"inspect", "registry://" + host + "/some-image"}, out))
+			lines := strings.Split(out.String(), "\n")
+			h.AssertContains(t, lines, "Platform:      linux/amd64", "  some-key=some-value", "  "+layerID)
+		})
+
+		it("prints the image as JSON", func() {
+			saveImage("some-image", map[string]string{"some-key": "some-value"}, layerPath)
+
+			h.AssertNil(t, run(factory, []string{"inspect", "--json", "registry://" + host + "/some-image"}, out))
+			var output inspectOutput
+			h.AssertNil(t, json.Unmarshal(out.Bytes(), &output))
+			h.AssertEq(t, output.Labels, map[string]string{"some-key": "some-value"})
+			h.AssertEq(t, output.Layers, []string{layerID})
+			h.AssertEq(t, strings.HasPrefix(output.Identifier, host+"/some-image@sha256:"), true)
+			h.AssertEq(t, output.ManifestSize > 0, true)
+		})
+
+		it("returns an error when the image does not exist", func() {
+			err := run(factory, []string{"inspect", "registry://" + host + "/missing-image"}, out)
+			h.AssertError(t, err, "not found")
+		})
+	})
+
+	when("labels", func() {
+		it("sets and removes labels", func() {
+			saveImage("some-image", map[string]string{"removed": "value"})
+			ref := "registry://" + host + "/some-image"
+
+			h.AssertNil(t, run(factory, []string{"labels", "set", ref, "some-key=some=value"}, out))
+			h.AssertNil(t, run(factory, []string{"labels", "rm", ref, "removed"}, out))
+
+			labels, err := openImage("some-image").Labels()
+			h.AssertNil(t, err)
+			h.AssertEq(t, labels, map[string]string{"some-key": "some=value"})
+		})
+
+		it("requires labels formatted as key=value", func() {
+			saveImage("some-image", nil)
+			err := run(factory, []string{"labels", "set", "registry://" + host + "/some-image", "some-key"}, out)
+			h.AssertError(t, err, "must be formatted as <key>=<value>")
+		})
+	})
+
+	when("layers get", func() {
+		it("writes the layer contents", func() {
+			saveImage("some-image", nil, layerPath)
+
+			h.AssertNil(t, run(factory, []string{"layers", "get", "registry://" + host + "/some-image", layerID}, out))
+			expected, err := ioutil.ReadFile(layerPath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, out.Bytes(), expected)
+		})
+	})
+
+	when("copy", func() {
+		it("copies the image", func() {
+			saveImage("some-image", map[string]string{"some-key": "some-value"}, layerPath)
+
+			h.AssertNil(t, run(factory, []string{"copy", "registry://" + host + "/some-image", "registry://" + host + "/other-image"}, out))
+
+			copied := openImage("other-image")
+			label, err := copied.Label("some-key")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+			layers, err := copied.Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, layers, []string{layerID})
+		})
+
+		it("copies the image between backends", func() {
+			saveImage("some-image", map[string]string{"some-key": "some-value"}, layerPath)
+			factory.dockerClient = fakes.NewDockerClient("linux")
+
+			h.AssertNil(t, run(factory, []string{"copy", "registry://" + host + "/some-image", "docker://some-image"}, out))
+			h.AssertNil(t, run(factory, []string{"copy", "docker://some-image", "registry://" + host + "/other-image"}, out))
+
+			copied := openImage("other-image")
+			label, err := copied.Label("some-key")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+			layers, err := copied.Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, layers, []string{layerID})
+		})
+
+		it("keeps the copied layers until the copy is saved", func() {
+			src := saveImage("some-image", nil, layerPath)
+			dst := fakes.NewImage("other-image", "", nil)
+			layersDir, err := ioutil.TempDir("", "imgutil-copy")
+			h.AssertNil(t, err)
+			defer os.RemoveAll(layersDir)
+
+			h.AssertNil(t, copyImage(src, dst, layersDir))
+
+			rc, err := dst.GetLayer(layerID)
+			h.AssertNil(t, err)
+			h.AssertNil(t, rc.Close())
+		})
+	})
+
+	when("rebase", func() {
+		var oldBaseTopLayer, newBaseTopLayer, appLayerPath string
+
+		it.Before(func() {
+			newBaseLayerPath, err := h.CreateSingleFileLayerTar("/new-base.txt", "new-base", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(newBaseLayerPath)
+			appLayerPath, err = h.CreateSingleFileLayerTar("/app.txt", "app", "linux")
+			h.AssertNil(t, err)
+
+			oldBaseTopLayer = layerID
+			newBaseTopLayer = h.FileDiffID(t, newBaseLayerPath)
+			saveImage("new-base", nil, newBaseLayerPath)
+		})
+
+		it.After(func() {
+			h.AssertNil(t, os.Remove(appLayerPath))
+		})
+
+		it("rebases the image on the run image", func() {
+			saveImage("some-app", nil, layerPath, appLayerPath)
+
+			h.AssertNil(t, run(factory, []string{
+				"rebase",
+				"--run-image", "registry://" + host + "/new-base",
+				"--base-top-layer", oldBaseTopLayer,
+				"registry://" + host + "/some-app",
+			}, out))
+
+			layers, err := openImage("some-app").Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, layers, []string{newBaseTopLayer, h.FileDiffID(t, appLayerPath)})
+		})
+
+		it("reads the run image top layer from the lifecycle metadata", func() {
+			saveImage("some-app", map[string]string{
+				lifecycleMetadataLabel: `{"runImage":{"topLayer":"` + oldBaseTopLayer + `"}}`,
+			}, layerPath, appLayerPath)
+
+			h.AssertNil(t, run(factory, []string{
+				"rebase",
+				"--run-image", "registry://" + host + "/new-base",
+				"registry://" + host + "/some-app",
+			}, out))
+
+			layers, err := openImage("some-app").Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, layers, []string{newBaseTopLayer, h.FileDiffID(t, appLayerPath)})
+		})
+
+		it("requires the image and run image to use the same backend", func() {
+			saveImage("some-app", nil, layerPath)
+
+			err := run(factory, []string{
+				"rebase",
+				"--run-image", "docker://some-run-image",
+				"--base-top-layer", oldBaseTopLayer,
+				"registry://" + host + "/some-app",
+			}, out)
+			h.AssertError(t, err, "must use the same backend")
+		})
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/auth"
+	"github.com/buildpacks/imgutil/local"
+	"github.com/buildpacks/imgutil/remote"
+)
+
+const (
+	dockerScheme   = "docker://"
+	registryScheme = "registry://"
+)
+
+// imageRef is an image reference with the scheme of its backend.
+type imageRef struct {
+	scheme string
+	name   string
+}
+
+func parseImageRef(ref string) (imageRef, error) {
+	for _, scheme := range []string{dockerScheme, registryScheme} {
+		if strings.HasPrefix(ref, scheme) && len(ref) > len(scheme) {
+			return imageRef{scheme: scheme, name: strings.TrimPrefix(ref, scheme)}, nil
+		}
+	}
+	return imageRef{}, fmt.Errorf("image reference '%s' must start with %s or %s", ref, dockerScheme, registryScheme)
+}
+
+func (r imageRef) String() string {
+	return r.scheme + r.name
+}
+
+// imageFactory creates images for references, connecting to the Docker daemon or reading registry credentials
+// when first needed.
+type imageFactory struct {
+	dockerClient  client.CommonAPIClient
+	keychain      authn.Keychain
+	remoteOptions []remote.ImageOption
+}
+
+// existingImage returns the image ref, which must exist.
+func (f *imageFactory) existingImage(ref imageRef) (imgutil.Image, error) {
+	img, err := f.newImage(ref, ref)
+	if err != nil {
+		return nil, err
+	}
+	if !img.Found() {
+		return nil, fmt.Errorf("image '%s' not found", ref)
+	}
+	return img, nil
+}
+
+// newImage returns an image named ref, starting from the image base when not empty.
+func (f *imageFactory) newImage(ref, base imageRef) (imgutil.Image, error) {
+	if base.name != "" && base.scheme != ref.scheme {
+		return nil, fmt.Errorf("image '%s' and base image '%s' must use the same backend", ref, base)
+	}
+
+	switch ref.scheme {
+	case dockerScheme:
+		dockerClient, err := f.docker()
+		if err != nil {
+			return nil, err
+		}
+		var ops []local.ImageOption
+		if base.name != "" {
+			ops = append(ops, local.FromBaseImage(base.name))
+		}
+		return local.NewImage(ref.name, dockerClient, ops...)
+	default:
+		keychain, err := f.registryKeychain()
+		if err != nil {
+			return nil, err
+		}
+		ops := append([]remote.ImageOption{}, f.remoteOptions...)
+		if base.name != "" {
+			ops = append(ops, remote.FromBaseImage(base.name))
+		}
+		return remote.NewImage(ref.name, keychain, ops...)
+	}
+}
+
+func (f *imageFactory) docker() (client.CommonAPIClient, error) {
+	if f.dockerClient == nil {
+		dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			return nil, errors.Wrap(err, "connecting to docker daemon")
+		}
+		f.dockerClient = dockerClient
+	}
+	return f.dockerClient, nil
+}
+
+func (f *imageFactory) registryKeychain() (authn.Keychain, error) {
+	if f.keychain == nil {
+		keychain, err := auth.NewKeychain("", nil)
+		if err != nil {
+			return nil, err
+		}
+		f.keychain = keychain
+	}
+	return f.keychain, nil
+}
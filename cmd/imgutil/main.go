@@ -0,0 +1,94 @@
+// Command imgutil inspects and modifies images in a Docker daemon or a registry.
+//
+// Images are referenced with a scheme choosing the backend: docker://<name> for images in the Docker daemon
+// configured through the DOCKER_* environment variables, and registry://<name> for images in a registry, using
+// credentials from the Docker config and the CNB_REGISTRY_AUTH environment variable.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	usage       string
+	description string
+	run         func(f *imageFactory, args []string, out io.Writer) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"inspect": {
+			usage:       "inspect [--json] <image>",
+			description: "Show labels, env, layers, platform, identifier and manifest size of an image",
+			run:         runInspect,
+		},
+		"rebase": {
+			usage:       "rebase --run-image <image> [--base-top-layer <diffID>] <image>",
+			description: "Replace the base layers of an image with the layers of a run image",
+			run:         runRebase,
+		},
+		"copy": {
+			usage:       "copy <source-image> <destination-image>",
+			description: "Copy an image, possibly across backends",
+			run:         runCopy,
+		},
+		"labels": {
+			usage:       "labels set <image> <key>=<value>... | labels rm <image> <key>...",
+			description: "Set or remove labels of an image",
+			run:         runLabels,
+		},
+		"layers": {
+			usage:       "layers get [--output <file>] <image> <diffID>",
+			description: "Write the uncompressed contents of a layer",
+			run:         runLayers,
+		},
+	}
+}
+
+func main() {
+	if err := run(&imageFactory{}, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(1)
+	}
+}
+
+func run(f *imageFactory, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(out)
+		return nil
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command '%s', run 'imgutil help' for usage", args[0])
+	}
+	return cmd.run(f, args[1:], out)
+}
+
+func printUsage(out io.Writer) {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(out, "Usage: imgutil <command> [arguments]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Images are referenced as docker://<name> or registry://<name>.")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n      %s\n", commands[name].usage, commands[name].description)
+	}
+}
+
+// usageError returns an error describing the expected usage of the command name.
+func usageError(name string) error {
+	return fmt.Errorf("usage: imgutil %s", strings.TrimSpace(commands[name].usage))
+}
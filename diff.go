@@ -0,0 +1,404 @@
+package imgutil
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ImageDiff is a structured comparison of two images.
+type ImageDiff struct {
+	// Labels holds the labels that differ, by key.
+	Labels map[string]Change
+	// Env holds the environment variables that differ, by key.
+	Env map[string]Change
+	// Entrypoint is nil when both images have the same entrypoint.
+	Entrypoint *ListChange
+	// Cmd is nil when both images have the same cmd.
+	Cmd *ListChange
+	// Platform holds the platform fields that differ, by name: "os", "osVersion" or "architecture".
+	Platform map[string]Change
+	Layers   LayerDiff
+	// Files holds the file changes between the final filesystems of the images, sorted by path.
+	// It is only populated when Diff is called with WithFileChanges.
+	Files []FileChange
+}
+
+// Change describes a value that differs between two images. Added is true when the value is only set on the
+// second image, Removed when it is only set on the first one.
+type Change struct {
+	From    string
+	To      string
+	Added   bool
+	Removed bool
+}
+
+// ListChange describes a list value that differs between two images.
+type ListChange struct {
+	From []string
+	To   []string
+}
+
+// LayerDiff lists layer diff ids by the image they are part of.
+type LayerDiff struct {
+	// Added are the layers only in the second image, ordered as in that image.
+	Added []string
+	// Removed are the layers only in the first image, ordered as in that image.
+	Removed []string
+	// Kept are the layers in both images, ordered as in the second image.
+	Kept []string
+}
+
+type FileChangeKind string
+
+const (
+	FileAdded    FileChangeKind = "added"
+	FileModified FileChangeKind = "modified"
+	FileDeleted  FileChangeKind = "deleted"
+)
+
+// FileChange describes a file that differs between the final filesystems of two images.
+type FileChange struct {
+	Path string
+	Kind FileChangeKind
+	// Layer is the diff id of the layer providing the change, in the first image for deleted files and in the second
+	// image otherwise.
+	Layer string
+}
+
+// Empty returns true when the images have the same config and layers.
+func (d *ImageDiff) Empty() bool {
+	return len(d.Labels) == 0 &&
+		len(d.Env) == 0 &&
+		d.Entrypoint == nil &&
+		d.Cmd == nil &&
+		len(d.Platform) == 0 &&
+		len(d.Layers.Added) == 0 &&
+		len(d.Layers.Removed) == 0 &&
+		len(d.Files) == 0
+}
+
+type DiffOption func(*diffOptions)
+
+type diffOptions struct {
+	fileChanges bool
+}
+
+// WithFileChanges compares the final filesystems of the images, after applying whiteouts, as indexed by LayerIndex.
+// Only the files provided by different layers in each image have their contents read through GetLayer.
+func WithFileChanges() DiffOption {
+	return func(opts *diffOptions) {
+		opts.fileChanges = true
+	}
+}
+
+// Diff compares image a to image b. Both images must implement InspectableImage.
+func Diff(a, b Image, ops ...DiffOption) (*ImageDiff, error) {
+	opts := &diffOptions{}
+	for _, op := range ops {
+		op(opts)
+	}
+
+	from, err := asInspectableImage(a)
+	if err != nil {
+		return nil, err
+	}
+	to, err := asInspectableImage(b)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ImageDiff{}
+
+	fromLabels, err := from.Labels()
+	if err != nil {
+		return nil, err
+	}
+	toLabels, err := to.Labels()
+	if err != nil {
+		return nil, err
+	}
+	diff.Labels = diffMaps(fromLabels, toLabels)
+
+	fromEnv, err := from.EnvVars()
+	if err != nil {
+		return nil, err
+	}
+	toEnv, err := to.EnvVars()
+	if err != nil {
+		return nil, err
+	}
+	diff.Env = diffMaps(envMap(fromEnv), envMap(toEnv))
+
+	fromEntrypoint, err := from.Entrypoint()
+	if err != nil {
+		return nil, err
+	}
+	toEntrypoint, err := to.Entrypoint()
+	if err != nil {
+		return nil, err
+	}
+	diff.Entrypoint = diffLists(fromEntrypoint, toEntrypoint)
+
+	fromCmd, err := from.Cmd()
+	if err != nil {
+		return nil, err
+	}
+	toCmd, err := to.Cmd()
+	if err != nil {
+		return nil, err
+	}
+	diff.Cmd = diffLists(fromCmd, toCmd)
+
+	fromPlatform, err := platformMap(from)
+	if err != nil {
+		return nil, err
+	}
+	toPlatform, err := platformMap(to)
+	if err != nil {
+		return nil, err
+	}
+	diff.Platform = diffMaps(fromPlatform, toPlatform)
+
+	fromLayers, err := from.Layers()
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing layers of image '%s'", from.Name())
+	}
+	toLayers, err := to.Layers()
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing layers of image '%s'", to.Name())
+	}
+	diff.Layers = diffLayers(fromLayers, toLayers)
+
+	if opts.fileChanges {
+		if diff.Files, err = diffFiles(a, b); err != nil {
+			return nil, err
+		}
+	}
+
+	return diff, nil
+}
+
+func asInspectableImage(img Image) (InspectableImage, error) {
+	inspectableImage, ok := img.(InspectableImage)
+	if !ok {
+		return nil, fmt.Errorf("image '%s' does not support inspection", img.Name())
+	}
+	return inspectableImage, nil
+}
+
+func diffMaps(from, to map[string]string) map[string]Change {
+	changes := map[string]Change{}
+	for k, fromValue := range from {
+		toValue, ok := to[k]
+		if !ok {
+			changes[k] = Change{From: fromValue, Removed: true}
+		} else if fromValue != toValue {
+			changes[k] = Change{From: fromValue, To: toValue}
+		}
+	}
+	for k, toValue := range to {
+		if _, ok := from[k]; !ok {
+			changes[k] = Change{To: toValue, Added: true}
+		}
+	}
+	return changes
+}
+
+func diffLists(from, to []string) *ListChange {
+	if len(from) == 0 && len(to) == 0 {
+		return nil
+	}
+	if reflect.DeepEqual(from, to) {
+		return nil
+	}
+	return &ListChange{From: from, To: to}
+}
+
+func envMap(envVars []string) map[string]string {
+	env := map[string]string{}
+	for _, envVar := range envVars {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		} else {
+			env[parts[0]] = ""
+		}
+	}
+	return env
+}
+
+func platformMap(img Image) (map[string]string, error) {
+	os, err := img.OS()
+	if err != nil {
+		return nil, err
+	}
+	osVersion, err := img.OSVersion()
+	if err != nil {
+		return nil, err
+	}
+	architecture, err := img.Architecture()
+	if err != nil {
+		return nil, err
+	}
+	platform := map[string]string{"os": os, "architecture": architecture}
+	if osVersion != "" {
+		platform["osVersion"] = osVersion
+	}
+	return platform, nil
+}
+
+func diffLayers(from, to []string) LayerDiff {
+	inFrom := map[string]bool{}
+	for _, diffID := range from {
+		inFrom[diffID] = true
+	}
+	inTo := map[string]bool{}
+	for _, diffID := range to {
+		inTo[diffID] = true
+	}
+
+	var diff LayerDiff
+	for _, diffID := range from {
+		if !inTo[diffID] {
+			diff.Removed = append(diff.Removed, diffID)
+		}
+	}
+	for _, diffID := range to {
+		if inFrom[diffID] {
+			diff.Kept = append(diff.Kept, diffID)
+		} else {
+			diff.Added = append(diff.Added, diffID)
+		}
+	}
+	return diff
+}
+
+// diffFiles compares the final filesystems of from and to. Files provided by the same layer in both images are
+// unchanged; the others are compared by their headers and contents.
+func diffFiles(from, to Image) ([]FileChange, error) {
+	// the layers kept by to are only read once
+	cache := NewLayerHeaderCache()
+	fromIndex, err := LayerIndex(from, WithLayerHeaderCache(cache))
+	if err != nil {
+		return nil, err
+	}
+	toIndex, err := LayerIndex(to, WithLayerHeaderCache(cache))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		changes                  []FileChange
+		fromCompared, toCompared []LayerFile
+	)
+	for p, toFile := range toIndex.files {
+		fromFile, ok := fromIndex.files[p]
+		switch {
+		case !ok:
+			changes = append(changes, FileChange{Path: p, Kind: FileAdded, Layer: toFile.Layer})
+		case fromFile.Layer != toFile.Layer:
+			fromCompared = append(fromCompared, fromFile)
+			toCompared = append(toCompared, toFile)
+		}
+	}
+	for p, fromFile := range fromIndex.files {
+		if _, ok := toIndex.files[p]; !ok {
+			changes = append(changes, FileChange{Path: p, Kind: FileDeleted, Layer: fromFile.Layer})
+		}
+	}
+
+	fromDigests, err := fileDigests(fromIndex, fromCompared)
+	if err != nil {
+		return nil, err
+	}
+	toDigests, err := fileDigests(toIndex, toCompared)
+	if err != nil {
+		return nil, err
+	}
+	for _, toFile := range toCompared {
+		if fromDigests[toFile.Path] != toDigests[toFile.Path] {
+			changes = append(changes, FileChange{Path: toFile.Path, Kind: FileModified, Layer: toFile.Layer})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// fileDigests returns the digests of files by path, reading each of their layers once. Directories implied by the
+// paths of their contents are identified by their header alone.
+func fileDigests(index *FileIndex, files []LayerFile) (map[string]string, error) {
+	digests := map[string]string{}
+	byLayer := map[string]map[string]string{}
+	for _, file := range files {
+		if file.Header.Name == "" {
+			header := file.Header
+			digest, err := headerDigest(&header, strings.NewReader(""))
+			if err != nil {
+				return nil, err
+			}
+			digests[file.Path] = digest
+			continue
+		}
+		if byLayer[file.Layer] == nil {
+			byLayer[file.Layer] = map[string]string{}
+		}
+		byLayer[file.Layer][file.Header.Name] = file.Path
+	}
+
+	for diffID, paths := range byLayer {
+		if err := readDigests(index.img, diffID, paths, digests); err != nil {
+			return nil, errors.Wrapf(err, "reading layer '%s' of image '%s'", diffID, index.img.Name())
+		}
+	}
+	return digests, nil
+}
+
+// readDigests adds the digests of the entries of a layer named in paths to digests. The last entry with a name wins,
+// as in the index.
+func readDigests(img Image, diffID string, paths map[string]string, digests map[string]string) error {
+	rc, err := img.GetLayer(diffID)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		p, ok := paths[header.Name]
+		if !ok {
+			continue
+		}
+		if digests[p], err = headerDigest(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// headerDigest identifies the contents and metadata of a tar entry.
+func headerDigest(header *tar.Header, r io.Reader) (string, error) {
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%c:%o:%d:%d:%s\n", header.Typeflag, header.Mode, header.Uid, header.Gid, header.Linkname)
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
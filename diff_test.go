@@ -0,0 +1,138 @@
+package imgutil_test
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/fakes"
+	"github.com/buildpacks/imgutil/layer"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestDiff(t *testing.T) {
+	spec.Run(t, "Diff", testDiff, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testDiff(t *testing.T, when spec.G, it spec.S) {
+	var (
+		a, b   *fakes.Image
+		tmpDir string
+	)
+
+	it.Before(func() {
+		a = fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+		b = fakes.NewImage("other-image", "", nil, fakes.WithOS("linux"))
+
+		var err error
+		tmpDir, err = ioutil.TempDir("", "diff")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.RemoveAll(tmpDir))
+	})
+
+	// addSharedLayer adds the same layer to both images and returns its diff id.
+	addSharedLayer := func(entries ...layerEntry) string {
+		layerPath := writeLayer(t, tmpDir, "linux", entries...)
+		diffID := h.FileDiffID(t, layerPath)
+		h.AssertNil(t, a.AddLayerWithDiffID(layerPath, diffID))
+		h.AssertNil(t, b.AddLayerWithDiffID(layerPath, diffID))
+		return diffID
+	}
+
+	it("returns an empty diff for identical images", func() {
+		diff, err := imgutil.Diff(a, b)
+		h.AssertNil(t, err)
+		h.AssertEq(t, diff.Empty(), true)
+	})
+
+	it("reports config differences", func() {
+		h.AssertNil(t, a.SetLabel("changed", "old"))
+		h.AssertNil(t, a.SetLabel("removed", "value"))
+		h.AssertNil(t, b.SetLabel("changed", "new"))
+		h.AssertNil(t, b.SetLabel("added", "value"))
+		h.AssertNil(t, a.SetEnv("SOME_KEY", "some=value"))
+		h.AssertNil(t, b.SetEnv("SOME_KEY", "other=value"))
+		h.AssertNil(t, b.SetEntrypoint("some", "entrypoint"))
+		h.AssertNil(t, b.SetCmd("some-cmd"))
+		h.AssertNil(t, b.SetArchitecture("arm64"))
+
+		diff, err := imgutil.Diff(a, b)
+		h.AssertNil(t, err)
+
+		h.AssertEq(t, diff.Labels, map[string]imgutil.Change{
+			"changed": {From: "old", To: "new"},
+			"removed": {From: "value", Removed: true},
+			"added":   {To: "value", Added: true},
+		})
+		h.AssertEq(t, diff.Env, map[string]imgutil.Change{
+			"SOME_KEY": {From: "some=value", To: "other=value"},
+		})
+		h.AssertEq(t, diff.Entrypoint, &imgutil.ListChange{To: []string{"some", "entrypoint"}})
+		h.AssertEq(t, diff.Cmd, &imgutil.ListChange{From: []string{"initialCMD"}, To: []string{"some-cmd"}})
+		h.AssertEq(t, diff.Platform, map[string]imgutil.Change{
+			"architecture": {From: "amd64", To: "arm64"},
+		})
+		h.AssertEq(t, diff.Empty(), false)
+	})
+
+	it("reports added, removed and kept layers", func() {
+		kept := addSharedLayer(file("/kept.txt", "kept"))
+		removed := addLayer(t, a, tmpDir, file("/file.txt", "old"))
+		added := addLayer(t, b, tmpDir, file("/file.txt", "new"))
+
+		diff, err := imgutil.Diff(a, b)
+		h.AssertNil(t, err)
+		h.AssertEq(t, diff.Layers, imgutil.LayerDiff{
+			Added:   []string{added},
+			Removed: []string{removed},
+			Kept:    []string{kept},
+		})
+		h.AssertEq(t, len(diff.Files), 0)
+	})
+
+	when("#WithFileChanges", func() {
+		it("reports files changed in the layers that differ", func() {
+			removed := addLayer(t, a, tmpDir, file("/modified.txt", "old"))
+			addLayer(t, a, tmpDir, file("/deleted.txt", "deleted"))
+			added := addLayer(t, b, tmpDir, file("/modified.txt", "new"))
+			addLayer(t, b, tmpDir, file("/added.txt", "added"))
+
+			diff, err := imgutil.Diff(a, b, imgutil.WithFileChanges())
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(diff.Files), 3)
+			h.AssertEq(t, diff.Files[0].Path, "/added.txt")
+			h.AssertEq(t, diff.Files[0].Kind, imgutil.FileAdded)
+			h.AssertEq(t, diff.Files[1].Path, "/deleted.txt")
+			h.AssertEq(t, diff.Files[1].Kind, imgutil.FileDeleted)
+			h.AssertEq(t, diff.Files[2], imgutil.FileChange{Path: "/modified.txt", Kind: imgutil.FileModified, Layer: added})
+			h.AssertNotEq(t, removed, added)
+		})
+
+		it("compares the final filesystems after whiteouts", func() {
+			base := addSharedLayer(
+				file("/some-dir/deleted.txt", "deleted"),
+				file("/some-dir/kept.txt", "kept"),
+				file("/shadowed.txt", "same"),
+			)
+			addLayer(t, b, tmpDir,
+				layerEntry{header: &tar.Header{Name: "/some-dir/" + layer.OpaqueWhiteout, Typeflag: tar.TypeReg}},
+				file("/some-dir/kept.txt", "kept"),
+				file("/shadowed.txt", "same"),
+			)
+
+			diff, err := imgutil.Diff(a, b, imgutil.WithFileChanges())
+			h.AssertNil(t, err)
+			h.AssertEq(t, diff.Files, []imgutil.FileChange{
+				{Path: "/some-dir/deleted.txt", Kind: imgutil.FileDeleted, Layer: base},
+			})
+		})
+	})
+}
@@ -0,0 +1,239 @@
+package imgutil
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExportRootFS writes the final filesystem of img, which must implement InspectableImage, to w as a single tar. Every
+// layer read through GetLayer is applied in order, honoring whiteouts. Entry names are relative to the root of the
+// filesystem and, for Windows images, have no "Files/" prefix.
+func ExportRootFS(img Image, w io.Writer) error {
+	index, err := LayerIndex(img)
+	if err != nil {
+		return err
+	}
+
+	e := &rootFSExporter{index: index, tw: tar.NewWriter(w), written: map[string]bool{}}
+	for _, diffID := range index.layers {
+		if err := e.exportLayer(diffID); err != nil {
+			return errors.Wrapf(err, "exporting layer '%s' of image '%s'", diffID, img.Name())
+		}
+	}
+	return e.tw.Close()
+}
+
+// ExtractTo writes the final filesystem of img, as exported by ExportRootFS, to dir. Ownership is not preserved, and
+// device files and fifos are skipped. Entries are never written through the symlinks of the image, and ExtractTo fails
+// for entries and hardlinks that would refer to files outside of dir.
+func ExtractTo(img Image, dir string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(ExportRootFS(img, pw))
+	}()
+	defer pr.Close()
+
+	if err := extractTar(pr, dir); err != nil {
+		return errors.Wrapf(err, "extracting image '%s' to '%s'", img.Name(), dir)
+	}
+	return nil
+}
+
+type rootFSExporter struct {
+	index   *FileIndex
+	tw      *tar.Writer
+	written map[string]bool
+}
+
+func (e *rootFSExporter) exportLayer(diffID string) error {
+	rc, err := e.index.img.GetLayer(diffID)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for entry := 0; ; entry++ {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		p, ok := layerEntryPath(e.index.os, header.Name)
+		if !ok {
+			continue
+		}
+		file, ok := e.index.files[p]
+		if !ok || file.Layer != diffID || e.index.entries[p] != entry || e.written[p] {
+			// the entry is hidden by an upper layer or by a later entry of the layer, or is a whiteout
+			continue
+		}
+
+		if err := e.writeParents(p); err != nil {
+			return err
+		}
+		if err := e.writeHeader(p, header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(e.tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// writeParents writes the parent directories of filePath that were not written yet, as they are in the final
+// filesystem.
+func (e *rootFSExporter) writeParents(filePath string) error {
+	var parents []string
+	for dir := path.Dir(filePath); dir != "/" && !e.written[dir]; dir = path.Dir(dir) {
+		parents = append(parents, dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		header := e.index.files[parents[i]].Header
+		if err := e.writeHeader(parents[i], &header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *rootFSExporter) writeHeader(filePath string, header *tar.Header) error {
+	exported := *header
+	exported.Name = strings.TrimPrefix(filePath, "/")
+	if exported.Typeflag == tar.TypeDir {
+		exported.Name += "/"
+		exported.Size = 0
+	}
+	if exported.Typeflag == tar.TypeLink {
+		linkPath, ok := layerEntryPath(e.index.os, header.Linkname)
+		if !ok {
+			return fmt.Errorf("invalid hardlink '%s' to '%s'", filePath, header.Linkname)
+		}
+		exported.Linkname = strings.TrimPrefix(linkPath, "/")
+	}
+	e.written[filePath] = true
+	return e.tw.WriteHeader(&exported)
+}
+
+func extractTar(r io.Reader, dir string) error {
+	dirModes := map[string]os.FileMode{}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := extractPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		// an existing entry is replaced rather than written through, as it can be a link
+		if info, err := os.Lstat(target); err == nil && (header.Typeflag != tar.TypeDir || !info.IsDir()) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		mode := header.FileInfo().Mode()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirModes[target] = mode.Perm()
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(tr, target, mode.Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkTarget, err := extractPath(dir, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(linkTarget, target); err != nil {
+				return err
+			}
+		}
+	}
+
+	// directory permissions are set last, so that read-only directories can be filled
+	var dirs []string
+	for d := range dirModes {
+		dirs = append(dirs, d)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, d := range dirs {
+		if err := os.Chmod(d, dirModes[d]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractPath returns the path of the entry name inside dir. Links extracted before are not followed: name fails if it
+// leaves dir or if one of its parent directories is a symlink, so that an image cannot make entries refer to files
+// outside of dir.
+func extractPath(dir, name string) (string, error) {
+	clean := path.Clean(strings.TrimPrefix(name, "/"))
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("'%s' is outside of '%s'", name, dir)
+	}
+
+	target := dir
+	parts := strings.Split(clean, "/")
+	for i, part := range parts {
+		target = filepath.Join(target, part)
+		if i == len(parts)-1 {
+			break
+		}
+
+		info, err := os.Lstat(target)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("'%s' has a parent directory that is a symlink", name)
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("'%s' has a parent that is not a directory", name)
+		}
+	}
+	return target, nil
+}
+
+func extractFile(r io.Reader, target string, perm os.FileMode) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
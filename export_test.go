@@ -0,0 +1,206 @@
+package imgutil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/fakes"
+	"github.com/buildpacks/imgutil/layer"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestExport(t *testing.T) {
+	spec.Run(t, "Export", testExport, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testExport(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir string
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "export")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.RemoveAll(tmpDir))
+	})
+
+	// readExport returns the contents of the entries of an exported tar, with "<dir>" for directories and "-> <target>"
+	// for links, in the order of the tar.
+	readExport := func(img imgutil.Image) ([]string, map[string]string) {
+		t.Helper()
+		buf := &bytes.Buffer{}
+		h.AssertNil(t, imgutil.ExportRootFS(img, buf))
+
+		var names []string
+		contents := map[string]string{}
+		tr := tar.NewReader(buf)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return names, contents
+			}
+			h.AssertNil(t, err)
+			names = append(names, header.Name)
+
+			switch header.Typeflag {
+			case tar.TypeDir:
+				contents[header.Name] = "<dir>"
+			case tar.TypeSymlink, tar.TypeLink:
+				contents[header.Name] = "-> " + header.Linkname
+			default:
+				data, err := ioutil.ReadAll(tr)
+				h.AssertNil(t, err)
+				contents[header.Name] = string(data)
+			}
+		}
+	}
+
+	when("#ExportRootFS", func() {
+		it("flattens the layers", func() {
+			img := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+			addLayer(t, img, tmpDir,
+				file("/etc/os-release", "some-os"),
+				file("/etc/deleted-file", "deleted"),
+				file("/opaque-dir/hidden-file", "hidden"),
+				file("/replaced-file", "old"),
+			)
+			addLayer(t, img, tmpDir,
+				whiteout("/etc/deleted-file"),
+				layerEntry{header: &tar.Header{Name: "/opaque-dir/" + layer.OpaqueWhiteout, Typeflag: tar.TypeReg}},
+				file("/replaced-file", "new"),
+				symlink("/link", "replaced-file"),
+				layerEntry{header: &tar.Header{Name: "/hardlink", Typeflag: tar.TypeLink, Linkname: "/etc/os-release"}},
+			)
+
+			names, contents := readExport(img)
+			h.AssertEq(t, names, []string{"etc/", "etc/os-release", "opaque-dir/", "replaced-file", "link", "hardlink"})
+			h.AssertEq(t, contents, map[string]string{
+				"etc/":           "<dir>",
+				"etc/os-release": "some-os",
+				"opaque-dir/":    "<dir>",
+				"replaced-file":  "new",
+				"link":           "-> replaced-file",
+				"hardlink":       "-> etc/os-release",
+			})
+		})
+
+		it("exports the last entry of a layer with the path of a file", func() {
+			img := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+			addLayer(t, img, tmpDir, file("/some-file", "old"), file("/other-file", "other"), file("/some-file", "new"))
+
+			names, contents := readExport(img)
+			h.AssertEq(t, names, []string{"other-file", "some-file"})
+			h.AssertEq(t, contents["some-file"], "new")
+
+			index, err := imgutil.LayerIndex(img)
+			h.AssertNil(t, err)
+			data, err := index.ReadFile("/some-file")
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(data), contents["some-file"])
+		})
+
+		when("the image is a Windows image", func() {
+			it("removes the Files prefix", func() {
+				img := fakes.NewImage("some-image", "", nil, fakes.WithOS("windows"))
+				addLayer(t, img, tmpDir, file("/cnb/some-file", "some-contents"))
+
+				names, contents := readExport(img)
+				h.AssertEq(t, names, []string{"cnb/", "cnb/some-file"})
+				h.AssertEq(t, contents["cnb/some-file"], "some-contents")
+			})
+		})
+	})
+
+	when("#ExtractTo", func() {
+		var dir string
+
+		it.Before(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "extract-to")
+			h.AssertNil(t, err)
+		})
+
+		it.After(func() {
+			h.AssertNil(t, os.RemoveAll(dir))
+		})
+
+		it("writes the final filesystem to dir", func() {
+			if runtime.GOOS == "windows" {
+				t.Skip("creating symlinks requires privileges on Windows")
+			}
+
+			img := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+			addLayer(t, img, tmpDir,
+				file("/bin/some-exe", "some-exe"),
+				file("/etc/deleted-file", "deleted"),
+				layerEntry{header: &tar.Header{Name: "/read-only-dir", Typeflag: tar.TypeDir, Mode: 0555}},
+				file("/read-only-dir/some-file", "some-contents"),
+			)
+			addLayer(t, img, tmpDir, whiteout("/etc/deleted-file"), symlink("/bin/some-link", "some-exe"))
+
+			h.AssertNil(t, imgutil.ExtractTo(img, dir))
+			defer os.Chmod(filepath.Join(dir, "read-only-dir"), 0755)
+
+			contents, err := ioutil.ReadFile(filepath.Join(dir, "bin", "some-link"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "some-exe")
+
+			contents, err = ioutil.ReadFile(filepath.Join(dir, "read-only-dir", "some-file"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "some-contents")
+
+			info, err := os.Stat(filepath.Join(dir, "read-only-dir"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, info.Mode().Perm(), os.FileMode(0555))
+
+			_, err = os.Stat(filepath.Join(dir, "etc", "deleted-file"))
+			h.AssertEq(t, os.IsNotExist(err), true)
+			_, err = os.Stat(filepath.Join(dir, "etc"))
+			h.AssertNil(t, err)
+		})
+
+		when("links of the image point outside of dir", func() {
+			it.Before(func() {
+				if runtime.GOOS == "windows" {
+					t.Skip("creating symlinks requires privileges on Windows")
+				}
+			})
+
+			for name, linkTarget := range map[string]string{"absolute": "/", "relative": "../../.."} {
+				linkTarget := linkTarget
+				it("does not write through symlinks that are "+name, func() {
+					outside := filepath.Join(tmpDir, "outside-file")
+					h.AssertNil(t, ioutil.WriteFile(outside, []byte("outside"), 0644))
+
+					img := fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+					addLayer(t, img, tmpDir,
+						symlink("/some-dir/escape", linkTarget),
+						layerEntry{header: &tar.Header{
+							Name:     "/stolen-file",
+							Typeflag: tar.TypeLink,
+							Linkname: "/some-dir/escape" + filepath.ToSlash(outside),
+						}},
+					)
+
+					err := imgutil.ExtractTo(img, dir)
+					h.AssertError(t, err, "has a parent directory that is a symlink")
+
+					_, err = os.Lstat(filepath.Join(dir, "stolen-file"))
+					h.AssertEq(t, os.IsNotExist(err), true)
+				})
+			}
+		})
+	})
+}
@@ -0,0 +1,461 @@
+package fakes
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+)
+
+// DockerClient is an in-memory Docker daemon implementing the methods of client.CommonAPIClient used by the local
+// package: Info, ImageInspectWithRaw, ImageLoad, ImageSave, ImageTag and ImageRemove. Calling any other method panics.
+type DockerClient struct {
+	client.CommonAPIClient
+
+	mu     sync.Mutex
+	osType string
+	images map[string]*daemonImage
+	tags   map[string]string
+	layers map[string][]byte
+	errs   map[string]error
+}
+
+type daemonImage struct {
+	inspect types.ImageInspect
+	config  []byte
+}
+
+func NewDockerClient(osType string) *DockerClient {
+	return &DockerClient{
+		osType: osType,
+		images: map[string]*daemonImage{},
+		tags:   map[string]string{},
+		layers: map[string][]byte{},
+		errs:   map[string]error{},
+	}
+}
+
+// SetError makes every call to method, e.g. "ImageLoad", return err. A nil err restores the default behavior.
+func (c *DockerClient) SetError(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.errs, method)
+		return
+	}
+	c.errs[method] = err
+}
+
+// ImageIDs returns the IDs of the images in the daemon.
+func (c *DockerClient) ImageIDs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var ids []string
+	for id := range c.images {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (c *DockerClient) Info(ctx context.Context) (types.Info, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.errs["Info"]; err != nil {
+		return types.Info{}, err
+	}
+	return types.Info{OSType: c.osType}, nil
+}
+
+func (c *DockerClient) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.errs["ImageInspectWithRaw"]; err != nil {
+		return types.ImageInspect{}, nil, err
+	}
+
+	img, err := c.findImage(image)
+	if err != nil {
+		return types.ImageInspect{}, nil, err
+	}
+	raw, err := json.Marshal(img.inspect)
+	if err != nil {
+		return types.ImageInspect{}, nil, err
+	}
+	return img.inspect, raw, nil
+}
+
+// ImageLoad loads a tarball in the format written by `docker save`. Like the daemon, it reports invalid tarballs
+// through errors embedded in the response rather than by returning an error.
+func (c *DockerClient) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error) {
+	// like the HTTP client, consume and close the input whatever the outcome of the request
+	if closer, ok := input.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.errs["ImageLoad"]; err != nil {
+		return types.ImageLoadResponse{}, err
+	}
+
+	var messages []string
+	ids, err := c.load(input)
+	if _, drainErr := io.Copy(ioutil.Discard, input); drainErr != nil && err == nil {
+		err = drainErr
+	}
+	if err != nil {
+		messages = append(messages, jsonMessage(map[string]interface{}{
+			"errorDetail": map[string]string{"message": err.Error()},
+			"error":       err.Error(),
+		}))
+	}
+	for _, id := range ids {
+		messages = append(messages, jsonMessage(map[string]string{"stream": "Loaded image ID: " + id + "\n"}))
+	}
+
+	return types.ImageLoadResponse{
+		Body: ioutil.NopCloser(strings.NewReader(strings.Join(messages, ""))),
+		JSON: true,
+	}, nil
+}
+
+func jsonMessage(msg interface{}) string {
+	b, _ := json.Marshal(msg)
+	return string(b) + "\n"
+}
+
+func (c *DockerClient) load(input io.Reader) ([]string, error) {
+	files := map[string][]byte{}
+	tr := tar.NewReader(input)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "reading tarball")
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Clean(strings.TrimPrefix(hdr.Name, "/"))] = contents
+	}
+
+	var manifest []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}
+	manifestFile, ok := files["manifest.json"]
+	if !ok {
+		return nil, errors.New("manifest.json not found in tarball")
+	}
+	if err := json.Unmarshal(manifestFile, &manifest); err != nil {
+		return nil, errors.Wrap(err, "parsing manifest.json")
+	}
+
+	var ids []string
+	for _, entry := range manifest {
+		id, err := c.loadImage(files, entry.Config, entry.Layers)
+		if err != nil {
+			return ids, err
+		}
+		for _, tag := range entry.RepoTags {
+			if err := c.tag(id, tag); err != nil {
+				return ids, err
+			}
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (c *DockerClient) loadImage(files map[string][]byte, configName string, layerNames []string) (string, error) {
+	configFile, ok := files[path.Clean(strings.TrimPrefix(configName, "/"))]
+	if !ok {
+		return "", fmt.Errorf("config '%s' not found in tarball", configName)
+	}
+	config, err := v1.ParseConfigFile(bytes.NewReader(configFile))
+	if err != nil {
+		return "", errors.Wrap(err, "parsing config")
+	}
+	if len(config.RootFS.DiffIDs) != len(layerNames) {
+		return "", fmt.Errorf("config has %d diff ids but manifest has %d layers", len(config.RootFS.DiffIDs), len(layerNames))
+	}
+
+	newLayers := map[string][]byte{}
+	for idx, diffID := range config.RootFS.DiffIDs {
+		layerName := path.Clean(strings.TrimPrefix(layerNames[idx], "/"))
+		contents, ok := files[layerName]
+		if !ok {
+			// layers may be omitted from the tarball when they already exist in the daemon
+			if _, exists := c.layers[diffID.String()]; !exists {
+				return "", fmt.Errorf("layer '%s' not found in tarball or daemon", diffID)
+			}
+			continue
+		}
+		if actual := fmt.Sprintf("sha256:%x", sha256.Sum256(contents)); actual != diffID.String() {
+			return "", fmt.Errorf("layer '%s' has diff id '%s'", diffID, actual)
+		}
+		newLayers[diffID.String()] = contents
+	}
+	for diffID, contents := range newLayers {
+		c.layers[diffID] = contents
+	}
+
+	id := fmt.Sprintf("sha256:%x", sha256.Sum256(configFile))
+	if _, ok := c.images[id]; !ok {
+		c.images[id] = &daemonImage{inspect: inspectFromConfig(id, config), config: configFile}
+	}
+	return id, nil
+}
+
+func inspectFromConfig(id string, config *v1.ConfigFile) types.ImageInspect {
+	diffIDs := make([]string, len(config.RootFS.DiffIDs))
+	for idx, diffID := range config.RootFS.DiffIDs {
+		diffIDs[idx] = diffID.String()
+	}
+
+	exposedPorts := nat.PortSet{}
+	for port := range config.Config.ExposedPorts {
+		exposedPorts[nat.Port(port)] = struct{}{}
+	}
+	var healthcheck *container.HealthConfig
+	if config.Config.Healthcheck != nil {
+		healthcheck = &container.HealthConfig{
+			Test:        config.Config.Healthcheck.Test,
+			Interval:    config.Config.Healthcheck.Interval,
+			Timeout:     config.Config.Healthcheck.Timeout,
+			StartPeriod: config.Config.Healthcheck.StartPeriod,
+			Retries:     config.Config.Healthcheck.Retries,
+		}
+	}
+
+	return types.ImageInspect{
+		ID:            id,
+		Created:       config.Created.UTC().Format(time.RFC3339Nano),
+		Container:     config.Container,
+		DockerVersion: config.DockerVersion,
+		Author:        config.Author,
+		Architecture:  config.Architecture,
+		Os:            config.OS,
+		OsVersion:     config.OSVersion,
+		Config: &container.Config{
+			Hostname:     config.Config.Hostname,
+			Domainname:   config.Config.Domainname,
+			User:         config.Config.User,
+			ExposedPorts: exposedPorts,
+			Env:          config.Config.Env,
+			Cmd:          strslice.StrSlice(config.Config.Cmd),
+			Healthcheck:  healthcheck,
+			Image:        config.Config.Image,
+			Volumes:      config.Config.Volumes,
+			WorkingDir:   config.Config.WorkingDir,
+			Entrypoint:   strslice.StrSlice(config.Config.Entrypoint),
+			OnBuild:      config.Config.OnBuild,
+			Labels:       config.Config.Labels,
+			StopSignal:   config.Config.StopSignal,
+			Shell:        strslice.StrSlice(config.Config.Shell),
+		},
+		RootFS: types.RootFS{Type: "layers", Layers: diffIDs},
+	}
+}
+
+// ImageSave writes the images in the format read by ImageLoad.
+func (c *DockerClient) ImageSave(ctx context.Context, images []string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.errs["ImageSave"]; err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	var manifest []map[string]interface{}
+	for _, image := range images {
+		img, err := c.findImage(image)
+		if err != nil {
+			return nil, err
+		}
+
+		configName := strings.TrimPrefix(img.inspect.ID, "sha256:") + ".json"
+		if err := addFileToTar(tw, configName, img.config); err != nil {
+			return nil, err
+		}
+		var layerNames []string
+		for _, diffID := range img.inspect.RootFS.Layers {
+			layerName := strings.TrimPrefix(diffID, "sha256:") + "/layer.tar"
+			if err := addFileToTar(tw, layerName, c.layers[diffID]); err != nil {
+				return nil, err
+			}
+			layerNames = append(layerNames, layerName)
+		}
+		manifest = append(manifest, map[string]interface{}{
+			"Config":   configName,
+			"RepoTags": img.inspect.RepoTags,
+			"Layers":   layerNames,
+		})
+	}
+
+	manifestFile, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := addFileToTar(tw, "manifest.json", manifestFile); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(buf), nil
+}
+
+func addFileToTar(tw *tar.Writer, name string, contents []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}); err != nil {
+		return err
+	}
+	_, err := tw.Write(contents)
+	return err
+}
+
+func (c *DockerClient) ImageTag(ctx context.Context, image, ref string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.errs["ImageTag"]; err != nil {
+		return err
+	}
+
+	img, err := c.findImage(image)
+	if err != nil {
+		return err
+	}
+	return c.tag(img.inspect.ID, ref)
+}
+
+func (c *DockerClient) tag(id, ref string) error {
+	tag, err := name.NewTag(ref, name.WeakValidation)
+	if err != nil {
+		return errdefs.InvalidParameter(err)
+	}
+	tagName := tag.Name()
+
+	if previousID, ok := c.tags[tagName]; ok && previousID != id {
+		previous := c.images[previousID]
+		previous.inspect.RepoTags = removeString(previous.inspect.RepoTags, tagName)
+	}
+	c.tags[tagName] = id
+
+	img := c.images[id]
+	img.inspect.RepoTags = append(removeString(img.inspect.RepoTags, tagName), tagName)
+	return nil
+}
+
+// ImageRemove untags the image when referenced by tag, and deletes it once it has no tags left. Images referenced by
+// ID are deleted with all their tags, which requires Force when they have several tags.
+func (c *DockerClient) ImageRemove(ctx context.Context, image string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.errs["ImageRemove"]; err != nil {
+		return nil, err
+	}
+
+	img, err := c.findImage(image)
+	if err != nil {
+		return nil, err
+	}
+
+	var untag []string
+	if tagName, ok := c.tagName(image); ok && c.tags[tagName] == img.inspect.ID {
+		untag = []string{tagName}
+	} else {
+		if len(img.inspect.RepoTags) > 1 && !options.Force {
+			return nil, errdefs.Conflict(fmt.Errorf("unable to delete %s (must be forced) - image is referenced in multiple repositories", img.inspect.ID))
+		}
+		untag = append([]string{}, img.inspect.RepoTags...)
+	}
+
+	var items []types.ImageDeleteResponseItem
+	for _, tagName := range untag {
+		delete(c.tags, tagName)
+		img.inspect.RepoTags = removeString(img.inspect.RepoTags, tagName)
+		items = append(items, types.ImageDeleteResponseItem{Untagged: tagName})
+	}
+	if len(img.inspect.RepoTags) == 0 {
+		delete(c.images, img.inspect.ID)
+		items = append(items, types.ImageDeleteResponseItem{Deleted: img.inspect.ID})
+	}
+	return items, nil
+}
+
+// findImage resolves image by ID, tag or unique ID prefix.
+func (c *DockerClient) findImage(image string) (*daemonImage, error) {
+	if img, ok := c.images[image]; ok {
+		return img, nil
+	}
+	if img, ok := c.images["sha256:"+image]; ok {
+		return img, nil
+	}
+	if tagName, ok := c.tagName(image); ok {
+		if id, ok := c.tags[tagName]; ok {
+			return c.images[id], nil
+		}
+	}
+
+	var found *daemonImage
+	for id, img := range c.images {
+		if strings.HasPrefix(strings.TrimPrefix(id, "sha256:"), strings.TrimPrefix(image, "sha256:")) {
+			if found != nil {
+				return nil, errdefs.InvalidParameter(fmt.Errorf("ambiguous image reference: %s", image))
+			}
+			found = img
+		}
+	}
+	if found == nil {
+		return nil, errdefs.NotFound(fmt.Errorf("Error: No such image: %s", image))
+	}
+	return found, nil
+}
+
+func (c *DockerClient) tagName(ref string) (string, bool) {
+	tag, err := name.NewTag(ref, name.WeakValidation)
+	if err != nil {
+		return "", false
+	}
+	return tag.Name(), true
+}
+
+func removeString(values []string, value string) []string {
+	var result []string
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
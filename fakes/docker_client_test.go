@@ -0,0 +1,170 @@
+package fakes_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/fakes"
+	"github.com/buildpacks/imgutil/local"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestDockerClient(t *testing.T) {
+	spec.Run(t, "DockerClient", testDockerClient, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testDockerClient(t *testing.T, when spec.G, it spec.S) {
+	var (
+		dockerClient *fakes.DockerClient
+		layerPath    string
+		repoName     = "some-org/some-image"
+	)
+
+	it.Before(func() {
+		dockerClient = fakes.NewDockerClient("linux")
+
+		var err error
+		layerPath, err = h.CreateSingleFileLayerTar("/some-file.txt", "some-content", "linux")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.Remove(layerPath))
+	})
+
+	saveImage := func(repoName string) *local.Image {
+		img, err := local.NewImage(repoName, dockerClient)
+		h.AssertNil(t, err)
+		h.AssertNil(t, img.SetLabel("some-key", "some-value"))
+		h.AssertNil(t, img.SetEnv("SOME_KEY", "some-value"))
+		h.AssertNil(t, img.AddLayer(layerPath))
+		h.AssertNil(t, img.Save())
+		return img
+	}
+
+	it("implements the daemon client used by local images", func() {
+		var _ client.CommonAPIClient = dockerClient
+	})
+
+	it("reports its os", func() {
+		info, err := dockerClient.Info(context.TODO())
+		h.AssertNil(t, err)
+		h.AssertEq(t, info.OSType, "linux")
+	})
+
+	when("#ImageLoad", func() {
+		it("stores images saved by local images", func() {
+			saveImage(repoName)
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.RepoTags, []string{"index.docker.io/some-org/some-image:latest"})
+			h.AssertEq(t, inspect.Config.Labels["some-key"], "some-value")
+			h.AssertEq(t, inspect.Config.Env, []string{"SOME_KEY=some-value"})
+			h.AssertEq(t, inspect.RootFS.Layers, []string{h.FileDiffID(t, layerPath)})
+			h.AssertEq(t, inspect.Os, "linux")
+		})
+
+		it("reuses layers already in the daemon", func() {
+			saveImage(repoName)
+
+			img, err := local.NewImage("other-image", dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("other-key", "other-value"))
+			h.AssertNil(t, img.Save())
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), "other-image")
+			h.AssertNil(t, err)
+			h.AssertEq(t, inspect.RootFS.Layers, []string{h.FileDiffID(t, layerPath)})
+			h.AssertEq(t, inspect.Config.Labels["other-key"], "other-value")
+		})
+
+		it("reports invalid tarballs in the response", func() {
+			res, err := dockerClient.ImageLoad(context.TODO(), h.CreateSingleFileTarReader("/manifest.json", `[{"Config":"config.json","Layers":[]}]`), true)
+			h.AssertNil(t, err)
+			defer res.Body.Close()
+
+			body, err := ioutil.ReadAll(res.Body)
+			h.AssertNil(t, err)
+			h.AssertEq(t, strings.Contains(string(body), `"error":"config 'config.json' not found in tarball"`), true)
+		})
+	})
+
+	when("#ImageSave", func() {
+		it("provides the layers of the image", func() {
+			saveImage(repoName)
+
+			img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+
+			rc, err := img.GetLayer(h.FileDiffID(t, layerPath))
+			h.AssertNil(t, err)
+			defer rc.Close()
+
+			contents, err := ioutil.ReadAll(rc)
+			h.AssertNil(t, err)
+			expected, err := ioutil.ReadFile(layerPath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, contents, expected)
+		})
+	})
+
+	when("#ImageTag", func() {
+		it("tags the image", func() {
+			img := saveImage(repoName)
+
+			h.AssertNil(t, img.Save("some-org/other-image:some-tag"))
+
+			inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), "some-org/other-image:some-tag")
+			h.AssertNil(t, err)
+			h.AssertContains(t, inspect.RepoTags, "index.docker.io/some-org/other-image:some-tag")
+		})
+	})
+
+	when("#ImageRemove", func() {
+		it("deletes the image", func() {
+			img := saveImage(repoName)
+			h.AssertNil(t, img.Save("some-org/other-image"))
+
+			h.AssertNil(t, img.Delete())
+
+			_, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertEq(t, client.IsErrNotFound(err), true)
+			h.AssertEq(t, len(dockerClient.ImageIDs()), 0)
+		})
+
+		it("untags images referenced by tag", func() {
+			img := saveImage(repoName)
+			h.AssertNil(t, img.Save("some-org/other-image"))
+
+			items, err := dockerClient.ImageRemove(context.TODO(), "some-org/other-image", types.ImageRemoveOptions{})
+			h.AssertNil(t, err)
+			h.AssertEq(t, items, []types.ImageDeleteResponseItem{{Untagged: "index.docker.io/some-org/other-image:latest"}})
+
+			_, _, err = dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+			h.AssertNil(t, err)
+		})
+	})
+
+	when("#SetError", func() {
+		it("returns the error from the method", func() {
+			dockerClient.SetError("ImageInspectWithRaw", errors.New("some-error"))
+
+			_, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertError(t, err, "some-error")
+
+			dockerClient.SetError("ImageInspectWithRaw", nil)
+			_, err = local.NewImage(repoName, dockerClient, local.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+		})
+	})
+}
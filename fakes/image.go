@@ -4,11 +4,13 @@ import (
 	"archive/tar"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -18,8 +20,28 @@ import (
 	"github.com/buildpacks/imgutil"
 )
 
-func NewImage(name, topLayerSha string, identifier imgutil.Identifier) *Image {
-	return &Image{
+type ImageOption func(*Image)
+
+// WithStrictMode makes the image behave like the local and remote images instead of accepting every change:
+// the image is not found until saved, its OS cannot be changed, Rebase replaces its base layers, TopLayer is
+// read from its layers, every Save gives it a new identifier derived from its config and, on Windows, env vars that
+// differ only in case replace each other.
+func WithStrictMode() ImageOption {
+	return func(i *Image) {
+		i.strict = true
+		i.deleted = true
+	}
+}
+
+// WithOS sets the OS of the image, which cannot be changed afterwards in strict mode.
+func WithOS(osVal string) ImageOption {
+	return func(i *Image) {
+		i.os = osVal
+	}
+}
+
+func NewImage(name, topLayerSha string, identifier imgutil.Identifier, ops ...ImageOption) *Image {
+	image := &Image{
 		labels:        nil,
 		env:           map[string]string{},
 		topLayerSha:   topLayerSha,
@@ -33,16 +55,57 @@ func NewImage(name, topLayerSha string, identifier imgutil.Identifier) *Image {
 		os:            "linux",
 		osVersion:     "",
 		architecture:  "amd64",
+		snapshots:     map[string]Snapshot{},
+	}
+
+	for _, op := range ops {
+		op(image)
 	}
+
+	return image
+}
+
+// Snapshot is the state of an Image at the time it was saved under a name.
+type Snapshot struct {
+	Identifier   imgutil.Identifier
+	Labels       map[string]string
+	Annotations  map[string]string
+	Env          map[string]string
+	Entrypoint   []string
+	Cmd          []string
+	WorkingDir   string
+	OS           string
+	OSVersion    string
+	Architecture string
+	Layers       []string
+}
+
+func (s Snapshot) copy() Snapshot {
+	s.Labels = copyMap(s.Labels)
+	s.Annotations = copyMap(s.Annotations)
+	s.Env = copyMap(s.Env)
+	s.Entrypoint = copySlice(s.Entrypoint)
+	s.Cmd = copySlice(s.Cmd)
+	s.Layers = copySlice(s.Layers)
+	return s
+}
+
+// Identifier identifies an Image saved in strict mode by the digest of its config.
+type Identifier string
+
+func (i Identifier) String() string {
+	return string(i)
 }
 
 type Image struct {
 	deleted       bool
 	layers        []string
+	diffIDs       []string
 	layersMap     map[string]string
 	prevLayersMap map[string]string
 	reusedLayers  []string
 	labels        map[string]string
+	annotations   map[string]string
 	env           map[string]string
 	topLayerSha   string
 	os            string
@@ -58,6 +121,8 @@ type Image struct {
 	workingDir    string
 	savedNames    map[string]bool
 	manifestSize  int64
+	strict        bool
+	snapshots     map[string]Snapshot
 }
 
 func (i *Image) CreatedAt() (time.Time, error) {
@@ -101,10 +166,62 @@ func (i *Image) Identifier() (imgutil.Identifier, error) {
 }
 
 func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image) error {
+	if i.strict {
+		if err := i.rebase(baseTopLayer, newBase); err != nil {
+			return err
+		}
+	}
 	i.base = newBase.Name()
 	return nil
 }
 
+func (i *Image) rebase(baseTopLayer string, newBase imgutil.Image) error {
+	var keepLayersIdx int
+	for idx, diffID := range i.diffIDs {
+		if diffID == baseTopLayer {
+			keepLayersIdx = idx + 1
+			break
+		}
+	}
+	if keepLayersIdx == 0 {
+		return fmt.Errorf("'%s' not found in '%s' during rebase", baseTopLayer, i.name)
+	}
+
+	inspectable, ok := newBase.(imgutil.InspectableImage)
+	if !ok {
+		return fmt.Errorf("image '%s' does not support listing layers", newBase.Name())
+	}
+	baseLayers, err := inspectable.Layers()
+	if err != nil {
+		return errors.Wrapf(err, "get layers of '%s'", newBase.Name())
+	}
+
+	if fakeBase, ok := newBase.(*Image); ok {
+		for _, diffID := range baseLayers {
+			if path, ok := fakeBase.layersMap[diffID]; ok {
+				i.layersMap[diffID] = path
+			}
+		}
+	}
+	for _, diffID := range i.diffIDs[:keepLayersIdx] {
+		if !contains(baseLayers, diffID) {
+			delete(i.layersMap, diffID)
+		}
+	}
+	i.diffIDs = append(copySlice(baseLayers), i.diffIDs[keepLayersIdx:]...)
+
+	if i.os, err = newBase.OS(); err != nil {
+		return err
+	}
+	if i.osVersion, err = newBase.OSVersion(); err != nil {
+		return err
+	}
+	if i.architecture, err = newBase.Architecture(); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (i *Image) SetLabel(k string, v string) error {
 	if i.labels == nil {
 		i.labels = map[string]string{}
@@ -118,12 +235,39 @@ func (i *Image) RemoveLabel(key string) error {
 	return nil
 }
 
+func (i *Image) Annotations() (map[string]string, error) {
+	copiedAnnotations := make(map[string]string)
+	for k, v := range i.annotations {
+		copiedAnnotations[k] = v
+	}
+	return copiedAnnotations, nil
+}
+
+func (i *Image) SetAnnotation(k string, v string) error {
+	if i.annotations == nil {
+		i.annotations = map[string]string{}
+	}
+	i.annotations[k] = v
+	return nil
+}
+
 func (i *Image) SetEnv(k string, v string) error {
+	if i.strict && i.os == "windows" {
+		// like the real images, replace an existing key that differs only in case
+		for existing := range i.env {
+			if strings.EqualFold(existing, k) {
+				delete(i.env, existing)
+			}
+		}
+	}
 	i.env[k] = v
 	return nil
 }
 
 func (i *Image) SetOS(o string) error {
+	if i.strict && o != i.os {
+		return fmt.Errorf(`invalid os: must match the daemon: "%s"`, i.os)
+	}
 	i.os = o
 	return nil
 }
@@ -157,7 +301,26 @@ func (i *Image) Env(k string) (string, error) {
 	return i.env[k], nil
 }
 
+func (i *Image) EnvVars() ([]string, error) {
+	var envVars []string
+	for k, v := range i.env {
+		envVars = append(envVars, k+"="+v)
+	}
+	sort.Strings(envVars)
+	return envVars, nil
+}
+
+func (i *Image) Layers() ([]string, error) {
+	return append([]string{}, i.diffIDs...), nil
+}
+
 func (i *Image) TopLayer() (string, error) {
+	if i.strict {
+		if len(i.diffIDs) == 0 {
+			return "", fmt.Errorf("image '%s' has no layers", i.name)
+		}
+		return i.diffIDs[len(i.diffIDs)-1], nil
+	}
 	return i.topLayerSha, nil
 }
 
@@ -169,12 +332,14 @@ func (i *Image) AddLayer(path string) error {
 
 	i.layersMap["sha256:"+sha] = path
 	i.layers = append(i.layers, path)
+	i.diffIDs = append(i.diffIDs, "sha256:"+sha)
 	return nil
 }
 
 func (i *Image) AddLayerWithDiffID(path string, diffID string) error {
 	i.layersMap[diffID] = path
 	i.layers = append(i.layers, path)
+	i.diffIDs = append(i.diffIDs, diffID)
 	return nil
 }
 
@@ -209,6 +374,7 @@ func (i *Image) ReuseLayer(sha string) error {
 	}
 	i.reusedLayers = append(i.reusedLayers, sha)
 	i.layersMap[sha] = prevLayer
+	i.diffIDs = append(i.diffIDs, sha)
 	return nil
 }
 
@@ -230,6 +396,13 @@ func (i *Image) Save(additionalNames ...string) error {
 		i.layers[l] = filepath.Join(i.layerDir, filepath.Base(layerPath))
 	}
 
+	if i.strict {
+		if i.identifier, err = i.configDigest(); err != nil {
+			return err
+		}
+	}
+	snapshot := i.snapshot()
+
 	allNames := append([]string{i.name}, additionalNames...)
 
 	var errs []imgutil.SaveDiagnostic
@@ -239,6 +412,10 @@ func (i *Image) Save(additionalNames ...string) error {
 			errs = append(errs, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
 		} else {
 			i.savedNames[n] = true
+			i.snapshots[n] = snapshot.copy()
+			if n == i.name {
+				i.deleted = false
+			}
 		}
 	}
 
@@ -249,6 +426,36 @@ func (i *Image) Save(additionalNames ...string) error {
 	return nil
 }
 
+func (i *Image) snapshot() Snapshot {
+	return Snapshot{
+		Identifier:   i.identifier,
+		Labels:       i.labels,
+		Annotations:  i.annotations,
+		Env:          i.env,
+		Entrypoint:   i.entryPoint,
+		Cmd:          i.cmd,
+		WorkingDir:   i.workingDir,
+		OS:           i.os,
+		OSVersion:    i.osVersion,
+		Architecture: i.architecture,
+		Layers:       i.diffIDs,
+	}.copy()
+}
+
+// configDigest is a stand-in for the image ID or manifest digest of the real images: it changes whenever the
+// config or layers of the image change.
+func (i *Image) configDigest() (Identifier, error) {
+	snapshot := i.snapshot()
+	snapshot.Identifier = nil
+
+	config, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal config")
+	}
+	sum := sha256.Sum256(config)
+	return Identifier("sha256:" + hex.EncodeToString(sum[:])), nil
+}
+
 func (i *Image) copyLayer(path, newPath string) error {
 	src, err := os.Open(path)
 	if err != nil {
@@ -413,6 +620,15 @@ func (i *Image) SavedNames() []string {
 	return names
 }
 
+// SavedSnapshot returns the state of the image when it was last saved as name.
+func (i *Image) SavedSnapshot(name string) (Snapshot, bool) {
+	snapshot, ok := i.snapshots[name]
+	if !ok {
+		return Snapshot{}, false
+	}
+	return snapshot.copy(), true
+}
+
 func (i *Image) SetManifestSize(size int64) {
 	i.manifestSize = size
 }
@@ -420,3 +636,30 @@ func (i *Image) SetManifestSize(size int64) {
 func (i *Image) ManifestSize() (int64, error) {
 	return i.manifestSize, nil
 }
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func copySlice(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
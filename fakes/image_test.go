@@ -16,10 +16,11 @@ import (
 
 	"github.com/buildpacks/imgutil"
 	"github.com/buildpacks/imgutil/fakes"
+	"github.com/buildpacks/imgutil/imgutiltest"
 	h "github.com/buildpacks/imgutil/testhelpers"
 )
 
-var localTestRegistry *h.DockerRegistry
+var localTestRegistry *h.InMemoryRegistry
 
 func newRepoName() string {
 	return "test-image-" + h.RandString(10)
@@ -28,18 +29,200 @@ func newRepoName() string {
 func TestFake(t *testing.T) {
 	rand.Seed(time.Now().UTC().UnixNano())
 
-	localTestRegistry = h.NewDockerRegistry()
+	localTestRegistry = h.NewInMemoryRegistry()
 	localTestRegistry.Start(t)
 	defer localTestRegistry.Stop(t)
 
 	spec.Run(t, "FakeImage", testFake, spec.Parallel(), spec.Report(report.Terminal{}))
 }
 
+type strictImageFactory struct{}
+
+func (strictImageFactory) NewImage(repoName string) (imgutil.Image, error) {
+	return fakes.NewImage(repoName, "", nil, fakes.WithStrictMode()), nil
+}
+
+func (strictImageFactory) RepoName(name string) string {
+	return name
+}
+
+func TestFakeSuite(t *testing.T) {
+	imgutiltest.RunImageSuite(t, strictImageFactory{})
+}
+
 func testFake(t *testing.T, when spec.G, it spec.S) {
 	it("implements imgutil.Image", func() {
 		var _ imgutil.Image = fakes.NewImage("", "", nil)
 	})
 
+	it("implements imgutil.AnnotatableImage", func() {
+		var _ imgutil.AnnotatableImage = fakes.NewImage("", "", nil)
+	})
+
+	when("#Layers", func() {
+		it("returns the diff ids of added and reused layers in order", func() {
+			image := fakes.NewImage(newRepoName(), "", nil)
+			image.AddPreviousLayer("sha256:reused", "")
+
+			h.AssertNil(t, image.AddLayerWithDiffID("some-path", "sha256:added"))
+			h.AssertNil(t, image.ReuseLayer("sha256:reused"))
+
+			layers, err := image.Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, layers, []string{"sha256:added", "sha256:reused"})
+		})
+	})
+
+	when("#EnvVars", func() {
+		it("returns the environment sorted by key", func() {
+			image := fakes.NewImage(newRepoName(), "", nil)
+			h.AssertNil(t, image.SetEnv("SOME_KEY", "some-value"))
+			h.AssertNil(t, image.SetEnv("OTHER_KEY", "other-value"))
+
+			envVars, err := image.EnvVars()
+			h.AssertNil(t, err)
+			h.AssertEq(t, envVars, []string{"OTHER_KEY=other-value", "SOME_KEY=some-value"})
+		})
+	})
+
+	when("strict mode", func() {
+		var image *fakes.Image
+
+		it.Before(func() {
+			image = fakes.NewImage(newRepoName(), "", nil, fakes.WithStrictMode())
+		})
+
+		it("is not found until saved", func() {
+			h.AssertEq(t, image.Found(), false)
+			h.AssertNil(t, image.Save())
+			h.AssertEq(t, image.Found(), true)
+		})
+
+		it("rejects changing the os", func() {
+			h.AssertNil(t, image.SetOS("linux"))
+			h.AssertError(t, image.SetOS("windows"), `invalid os: must match the daemon: "linux"`)
+		})
+
+		it("reads the top layer from its layers", func() {
+			_, err := image.TopLayer()
+			h.AssertError(t, err, "has no layers")
+
+			h.AssertNil(t, image.AddLayerWithDiffID("some-path", "sha256:some-layer"))
+			topLayer, err := image.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, "sha256:some-layer")
+		})
+
+		it("changes the identifier when the saved config changes", func() {
+			h.AssertNil(t, image.Save())
+			first, err := image.Identifier()
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, image.Save())
+			same, err := image.Identifier()
+			h.AssertNil(t, err)
+			h.AssertEq(t, same.String(), first.String())
+
+			h.AssertNil(t, image.SetLabel("some-key", "some-value"))
+			h.AssertNil(t, image.Save())
+			second, err := image.Identifier()
+			h.AssertNil(t, err)
+			h.AssertNotEq(t, second.String(), first.String())
+		})
+
+		when("#Rebase", func() {
+			var newBase *fakes.Image
+
+			it.Before(func() {
+				h.AssertNil(t, image.AddLayerWithDiffID("old-base-path", "sha256:old-base"))
+				h.AssertNil(t, image.AddLayerWithDiffID("app-path", "sha256:app"))
+
+				newBase = fakes.NewImage(newRepoName(), "", nil, fakes.WithStrictMode())
+				h.AssertNil(t, newBase.AddLayerWithDiffID("new-base-path", "sha256:new-base"))
+				h.AssertNil(t, newBase.SetOSVersion("some-os-version"))
+			})
+
+			it("replaces the base layers and platform", func() {
+				h.AssertNil(t, image.Rebase("sha256:old-base", newBase))
+
+				layers, err := image.Layers()
+				h.AssertNil(t, err)
+				h.AssertEq(t, layers, []string{"sha256:new-base", "sha256:app"})
+
+				osVersion, err := image.OSVersion()
+				h.AssertNil(t, err)
+				h.AssertEq(t, osVersion, "some-os-version")
+				h.AssertEq(t, image.Base(), newBase.Name())
+			})
+
+			it("fails when the base top layer is not found", func() {
+				err := image.Rebase("sha256:unknown", newBase)
+				h.AssertError(t, err, fmt.Sprintf("'sha256:unknown' not found in '%s' during rebase", image.Name()))
+			})
+		})
+
+		when("windows", func() {
+			it("replaces env vars that differ only in case", func() {
+				image := fakes.NewImage(newRepoName(), "", nil, fakes.WithStrictMode(), fakes.WithOS("windows"))
+				h.AssertNil(t, image.SetEnv("Path", "some-path"))
+				h.AssertNil(t, image.SetEnv("PATH", "other-path"))
+
+				val, err := image.Env("PATH")
+				h.AssertNil(t, err)
+				h.AssertEq(t, val, "other-path")
+
+				envVars, err := image.EnvVars()
+				h.AssertNil(t, err)
+				h.AssertEq(t, envVars, []string{"PATH=other-path"})
+			})
+		})
+	})
+
+	when("#SavedSnapshot", func() {
+		it("records the state of the image per saved name", func() {
+			repoName := newRepoName()
+			otherName := newRepoName()
+			image := fakes.NewImage(repoName, "", nil)
+			h.AssertNil(t, image.SetLabel("some-key", "some-value"))
+			h.AssertNil(t, image.Save(otherName))
+
+			h.AssertNil(t, image.SetLabel("some-key", "other-value"))
+			h.AssertNil(t, image.Save())
+
+			snapshot, ok := image.SavedSnapshot(repoName)
+			h.AssertEq(t, ok, true)
+			h.AssertEq(t, snapshot.Labels["some-key"], "other-value")
+
+			snapshot, ok = image.SavedSnapshot(otherName)
+			h.AssertEq(t, ok, true)
+			h.AssertEq(t, snapshot.Labels["some-key"], "some-value")
+
+			snapshot.Labels["some-key"] = "modified"
+			snapshot, _ = image.SavedSnapshot(otherName)
+			h.AssertEq(t, snapshot.Labels["some-key"], "some-value")
+
+			_, ok = image.SavedSnapshot("never-saved")
+			h.AssertEq(t, ok, false)
+		})
+	})
+
+	when("#SetAnnotation", func() {
+		it("records the annotations in the saved snapshot", func() {
+			repoName := newRepoName()
+			image := fakes.NewImage(repoName, "", nil)
+			h.AssertNil(t, image.SetAnnotation("org.opencontainers.image.source", "https://example.com/some-repo"))
+			h.AssertNil(t, image.Save())
+
+			annotations, err := image.Annotations()
+			h.AssertNil(t, err)
+			h.AssertEq(t, annotations, map[string]string{"org.opencontainers.image.source": "https://example.com/some-repo"})
+
+			snapshot, ok := image.SavedSnapshot(repoName)
+			h.AssertEq(t, ok, true)
+			h.AssertEq(t, snapshot.Annotations, annotations)
+		})
+	})
+
 	when("#SavedNames", func() {
 		when("additional names are provided during save", func() {
 			var (
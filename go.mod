@@ -1,6 +1,7 @@
 module github.com/buildpacks/imgutil
 
 require (
+	github.com/docker/cli v0.0.0-20191017083524-a8ff7f821017
 	github.com/docker/docker v1.4.2-0.20190924003213-a8608b5b67c7
 	github.com/docker/go-connections v0.4.0
 	github.com/google/go-cmp v0.5.5
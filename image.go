@@ -5,6 +5,8 @@ import (
 	"io"
 	"strings"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 var NormalizedDateTime = time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC)
@@ -33,6 +35,19 @@ type Platform struct {
 	OSVersion    string
 }
 
+// MediaTypes selects the media types of the manifest, config and layers of a saved image.
+type MediaTypes int
+
+const (
+	// DefaultTypes keeps the media types of the base image, or uses Docker media types for new images.
+	DefaultTypes MediaTypes = iota
+	// OCITypes uses OCI image media types, e.g. "application/vnd.oci.image.manifest.v1+json".
+	OCITypes
+	// DockerTypes uses Docker image manifest v2, schema 2 media types, e.g.
+	// "application/vnd.docker.distribution.manifest.v2+json".
+	DockerTypes
+)
+
 type Image interface {
 	Name() string
 	Rename(name string)
@@ -71,4 +86,27 @@ type Image interface {
 	ManifestSize() (int64, error)
 }
 
+// InspectableImage is an Image that exposes its complete config and the list of its layers.
+type InspectableImage interface {
+	Image
+	Cmd() ([]string, error)
+	// EnvVars returns the environment of the image as KEY=VALUE pairs.
+	EnvVars() ([]string, error)
+	// Layers returns the diff ids of the layers of the image, ordered from the bottom layer.
+	Layers() ([]string, error)
+}
+
+// AnnotatableImage is an Image that stores annotations, such as "org.opencontainers.image.source", in its manifest.
+type AnnotatableImage interface {
+	Image
+	// Annotations returns the annotations that the manifest of the image has when it is saved.
+	Annotations() (map[string]string, error)
+	// SetAnnotation sets an annotation that is written to the manifest of the image on Save.
+	SetAnnotation(key, val string) error
+}
+
+// ErrAnnotationsUnsupported is returned by Annotations and SetAnnotation of images without a manifest, such as images
+// saved to a Docker daemon. Such images can carry the same metadata in labels instead.
+var ErrAnnotationsUnsupported = errors.New("annotations are not supported: the image has no manifest")
+
 type Identifier fmt.Stringer
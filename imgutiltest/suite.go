@@ -0,0 +1,301 @@
+// Package imgutiltest provides a conformance suite for implementations of imgutil.Image.
+package imgutiltest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+// ImageFactory creates the images exercised by the suite.
+type ImageFactory interface {
+	// NewImage returns an image that is saved as repoName, without a base image or previous image.
+	NewImage(repoName string) (imgutil.Image, error)
+	// RepoName returns a name images can be saved as, e.g. by prefixing name with a registry.
+	RepoName(name string) string
+}
+
+// RunImageSuite verifies that the images created by factory behave like the images of this module.
+func RunImageSuite(t *testing.T, factory ImageFactory) {
+	spec.Run(t, "ImageSuite", func(t *testing.T, when spec.G, it spec.S) {
+		testImage(t, when, it, factory)
+	}, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testImage(t *testing.T, when spec.G, it spec.S, factory ImageFactory) {
+	var (
+		image     imgutil.Image
+		repoName  string
+		layerPath string
+	)
+
+	newImage := func() imgutil.Image {
+		img, err := factory.NewImage(factory.RepoName("imgutiltest-" + h.RandString(10)))
+		h.AssertNil(t, err)
+		return img
+	}
+
+	newLayer := func(path, contents string) string {
+		osType, err := image.OS()
+		h.AssertNil(t, err)
+		layer, err := h.CreateSingleFileLayerTar(path, contents, osType)
+		h.AssertNil(t, err)
+		return layer
+	}
+
+	it.Before(func() {
+		image = newImage()
+		repoName = image.Name()
+		layerPath = newLayer("/some-file.txt", "some-content")
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.Remove(layerPath))
+	})
+
+	when("#Found", func() {
+		it("is false before the image is saved", func() {
+			h.AssertEq(t, image.Found(), false)
+		})
+
+		it("is true after the image is saved", func() {
+			h.AssertNil(t, image.Save())
+			h.AssertEq(t, image.Found(), true)
+		})
+
+		it("is false after the image is deleted", func() {
+			h.AssertNil(t, image.Save())
+			h.AssertNil(t, image.Delete())
+			h.AssertEq(t, image.Found(), false)
+		})
+	})
+
+	when("#Name", func() {
+		it("returns the name given at creation or by Rename", func() {
+			h.AssertEq(t, image.Name(), repoName)
+
+			newName := factory.RepoName("imgutiltest-" + h.RandString(10))
+			image.Rename(newName)
+			h.AssertEq(t, image.Name(), newName)
+		})
+	})
+
+	when("labels", func() {
+		it("sets, reads and removes labels", func() {
+			h.AssertNil(t, image.SetLabel("some-key", "some-value"))
+
+			val, err := image.Label("some-key")
+			h.AssertNil(t, err)
+			h.AssertEq(t, val, "some-value")
+
+			labels, err := image.Labels()
+			h.AssertNil(t, err)
+			h.AssertEq(t, labels["some-key"], "some-value")
+
+			h.AssertNil(t, image.RemoveLabel("some-key"))
+			val, err = image.Label("some-key")
+			h.AssertNil(t, err)
+			h.AssertEq(t, val, "")
+		})
+
+		it("returns a copy of the labels", func() {
+			h.AssertNil(t, image.SetLabel("some-key", "some-value"))
+
+			labels, err := image.Labels()
+			h.AssertNil(t, err)
+			labels["some-key"] = "modified"
+
+			val, err := image.Label("some-key")
+			h.AssertNil(t, err)
+			h.AssertEq(t, val, "some-value")
+		})
+
+		it("returns an empty value for a missing label", func() {
+			val, err := image.Label("missing-key")
+			h.AssertNil(t, err)
+			h.AssertEq(t, val, "")
+		})
+	})
+
+	when("env", func() {
+		it("sets and reads env vars", func() {
+			h.AssertNil(t, image.SetEnv("SOME_KEY", "some-value"))
+			h.AssertNil(t, image.SetEnv("SOME_KEY", "other-value"))
+
+			val, err := image.Env("SOME_KEY")
+			h.AssertNil(t, err)
+			h.AssertEq(t, val, "other-value")
+		})
+
+		it("returns an empty value for a missing env var", func() {
+			val, err := image.Env("MISSING_KEY")
+			h.AssertNil(t, err)
+			h.AssertEq(t, val, "")
+		})
+
+		it("replaces env vars that differ only in case on windows", func() {
+			osType, err := image.OS()
+			h.AssertNil(t, err)
+			if osType != "windows" && image.SetOS("windows") != nil {
+				t.Skip("the image os cannot be changed to windows")
+			}
+
+			h.AssertNil(t, image.SetEnv("Path", "some-path"))
+			h.AssertNil(t, image.SetEnv("PATH", "other-path"))
+
+			val, err := image.Env("PATH")
+			h.AssertNil(t, err)
+			h.AssertEq(t, val, "other-path")
+
+			inspectable, ok := image.(imgutil.InspectableImage)
+			if !ok {
+				return
+			}
+			envVars, err := inspectable.EnvVars()
+			h.AssertNil(t, err)
+			h.AssertContains(t, envVars, "PATH=other-path")
+			h.AssertDoesNotContain(t, envVars, "Path=some-path")
+		})
+	})
+
+	when("config", func() {
+		it("sets the entrypoint", func() {
+			h.AssertNil(t, image.SetEntrypoint("some", "entrypoint"))
+
+			entrypoint, err := image.Entrypoint()
+			h.AssertNil(t, err)
+			h.AssertEq(t, entrypoint, []string{"some", "entrypoint"})
+		})
+
+		it("sets the cmd", func() {
+			h.AssertNil(t, image.SetCmd("some", "cmd"))
+
+			inspectable, ok := image.(imgutil.InspectableImage)
+			if !ok {
+				return
+			}
+			cmd, err := inspectable.Cmd()
+			h.AssertNil(t, err)
+			h.AssertEq(t, cmd, []string{"some", "cmd"})
+		})
+
+		it("sets the os version and architecture", func() {
+			h.AssertNil(t, image.SetOSVersion("some-os-version"))
+			h.AssertNil(t, image.SetArchitecture("arm64"))
+
+			osVersion, err := image.OSVersion()
+			h.AssertNil(t, err)
+			h.AssertEq(t, osVersion, "some-os-version")
+
+			arch, err := image.Architecture()
+			h.AssertNil(t, err)
+			h.AssertEq(t, arch, "arm64")
+		})
+	})
+
+	when("layers", func() {
+		it("fails to read the top layer of an image without layers", func() {
+			_, err := image.TopLayer()
+			h.AssertError(t, err, "has no layers")
+		})
+
+		it("adds layers", func() {
+			h.AssertNil(t, image.AddLayer(layerPath))
+
+			topLayer, err := image.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, topLayer, h.FileDiffID(t, layerPath))
+
+			rc, err := image.GetLayer(topLayer)
+			h.AssertNil(t, err)
+			defer rc.Close()
+
+			contents, err := ioutil.ReadAll(rc)
+			h.AssertNil(t, err)
+			expected, err := ioutil.ReadFile(layerPath)
+			h.AssertNil(t, err)
+			h.AssertEq(t, contents, expected)
+		})
+
+		it("fails to get an unknown layer", func() {
+			_, err := image.GetLayer("sha256:" + h.RandString(64))
+			h.AssertError(t, err, "")
+		})
+	})
+
+	when("#Save", func() {
+		it("changes the identifier when the image changes", func() {
+			h.AssertNil(t, image.Save())
+			before, err := image.Identifier()
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, image.SetLabel("some-key", "some-value"))
+			h.AssertNil(t, image.Save())
+			after, err := image.Identifier()
+			h.AssertNil(t, err)
+
+			h.AssertNotEq(t, after.String(), before.String())
+		})
+
+		it("reports invalid names", func() {
+			badName := repoName + ":🧨"
+
+			err := image.Save(badName)
+			saveErr, ok := err.(imgutil.SaveError)
+			h.AssertEq(t, ok, true)
+			h.AssertEq(t, len(saveErr.Errors), 1)
+			h.AssertEq(t, saveErr.Errors[0].ImageName, badName)
+		})
+	})
+
+	when("#Rebase", func() {
+		var (
+			oldBaseLayerPath string
+			newBaseLayerPath string
+		)
+
+		it.Before(func() {
+			oldBaseLayerPath = newLayer("/old-base.txt", "old-base")
+			newBaseLayerPath = newLayer("/new-base.txt", "new-base")
+		})
+
+		it.After(func() {
+			h.AssertNil(t, os.Remove(oldBaseLayerPath))
+			h.AssertNil(t, os.Remove(newBaseLayerPath))
+		})
+
+		it("replaces the layers up to the base top layer", func() {
+			newBase := newImage()
+			h.AssertNil(t, newBase.AddLayer(newBaseLayerPath))
+			h.AssertNil(t, newBase.Save())
+
+			h.AssertNil(t, image.AddLayer(oldBaseLayerPath))
+			h.AssertNil(t, image.AddLayer(layerPath))
+			h.AssertNil(t, image.Rebase(h.FileDiffID(t, oldBaseLayerPath), newBase))
+
+			inspectable, ok := image.(imgutil.InspectableImage)
+			if !ok {
+				return
+			}
+			layers, err := inspectable.Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, layers, []string{h.FileDiffID(t, newBaseLayerPath), h.FileDiffID(t, layerPath)})
+		})
+
+		it("fails when the base top layer is not in the image", func() {
+			newBase := newImage()
+			h.AssertNil(t, newBase.AddLayer(newBaseLayerPath))
+			h.AssertNil(t, newBase.Save())
+
+			h.AssertNil(t, image.AddLayer(layerPath))
+			err := image.Rebase(h.FileDiffID(t, oldBaseLayerPath), newBase)
+			h.AssertError(t, err, "")
+		})
+	})
+}
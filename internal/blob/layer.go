@@ -0,0 +1,35 @@
+// Package blob provides blobs held in memory, such as the payloads of artifacts and signatures.
+package blob
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Layer is a v1.Layer holding contents in memory, which are stored uncompressed.
+type Layer struct {
+	contents  []byte
+	mediaType types.MediaType
+	hash      v1.Hash
+}
+
+func NewLayer(contents []byte, mediaType types.MediaType) (*Layer, error) {
+	hash, _, err := v1.SHA256(bytes.NewReader(contents))
+	if err != nil {
+		return nil, err
+	}
+	return &Layer{contents: contents, mediaType: mediaType, hash: hash}, nil
+}
+
+func (l *Layer) Digest() (v1.Hash, error)            { return l.hash, nil }
+func (l *Layer) DiffID() (v1.Hash, error)            { return l.hash, nil }
+func (l *Layer) Compressed() (io.ReadCloser, error)  { return l.Uncompressed() }
+func (l *Layer) Size() (int64, error)                { return int64(len(l.contents)), nil }
+func (l *Layer) MediaType() (types.MediaType, error) { return l.mediaType, nil }
+func (l *Layer) Uncompressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.contents)), nil
+}
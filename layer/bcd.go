@@ -0,0 +1,37 @@
+package layer
+
+// The BCD store generated with hivex, which the tests compare BCDHive to, is generated using `make generate`
+//go:generate docker run --rm -v $PWD:/out/ bcdhive-generator -file=/out/layer/bcdhive_generated_test.go -package=layer_test -func=hivexBCD
+
+// bcdObjects are the objects of the BCD store created with:
+// bcdedit /createstore c:\output-bcd
+// bcdedit /create {6a6c1f1b-59d4-11ea-9438-9402e6abd998} /d buildpacks.io /application osloader /store c:\output-bcd
+// bcdedit /create {bootmgr} /store c:\output-bcd
+// bcdedit /set {bootmgr} default {6a6c1f1b-59d4-11ea-9438-9402e6abd998} /store c:\output-bcd
+const (
+	bcdOSLoaderGUID = "{6a6c1f1b-59d4-11ea-9438-9402e6abd998}"
+	bcdBootMgrGUID  = "{9dea862c-5cdd-4e70-acc1-f32b344d4795}"
+)
+
+// BCDHive returns the BCD store of Windows base layers, with a boot manager whose default is a
+// "buildpacks.io" OS loader.
+func BCDHive() *Hive {
+	hive := NewHive()
+
+	description := hive.Key("Description")
+	description.SetDword("FirmwareModified", 1)
+	description.SetString("KeyName", "BCD00000000")
+
+	hive.Key("Objects/"+bcdOSLoaderGUID+"/Description").SetDword("Type", 0x10200003)
+	hive.Key("Objects/"+bcdOSLoaderGUID+"/Elements/12000004").SetString("Element", "buildpacks.io")
+
+	hive.Key("Objects/"+bcdBootMgrGUID+"/Description").SetDword("Type", 0x10100002)
+	hive.Key("Objects/"+bcdBootMgrGUID+"/Elements/23000003").SetString("Element", bcdOSLoaderGUID)
+
+	return hive
+}
+
+// BaseLayerBCD returns the encoded BCD store of Windows base layers.
+func BaseLayerBCD() ([]byte, error) {
+	return BCDHive().Bytes()
+}
@@ -0,0 +1,367 @@
+package layer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// registry value types
+const (
+	RegNone     = 0
+	RegSz       = 1
+	RegExpandSz = 2
+	RegBinary   = 3
+	RegDword    = 4
+	RegMultiSz  = 7
+	RegQword    = 11
+)
+
+const (
+	hiveBaseBlockLen = 0x1000
+	hiveBinLen       = 0x1000
+	hiveBinHeaderLen = 0x20
+	hiveNKLen        = 0x4C
+	hiveVKLen        = 0x14
+	hiveMaxDataLen   = 16344 // larger values need big data (db) cells, which are not supported
+	hiveNoOffset     = 0xFFFFFFFF
+
+	hiveKeyRoot     = 0x0C // KEY_HIVE_ENTRY | KEY_NO_DELETE
+	hiveKeyCompName = 0x20
+	hiveValCompName = 0x01
+	hiveDataInline  = 0x80000000
+)
+
+// hiveSDDL is the security descriptor shared by all keys of a hive.
+const hiveSDDL = "O:BAG:SYD:PAI(A;;KR;;;BU)(A;CIIO;GR;;;BU)(A;;KA;;;BA)(A;CIIO;GA;;;BA)(A;;KA;;;SY)(A;CIIO;GA;;;SY)(A;CIIO;GA;;;CO)"
+
+// hiveTime is the last written time of the hive and its keys, so that encoding a hive is reproducible.
+var hiveTime = time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC)
+
+// Hive is a Windows registry hive, which is encoded in the regf format used by the files in
+// Windows/System32/config or by the BCD store.
+type Hive struct {
+	root *HiveKey
+}
+
+// HiveKey is a key of a Hive.
+type HiveKey struct {
+	name    string
+	subkeys []*HiveKey
+	values  []hiveValue
+}
+
+type hiveValue struct {
+	name      string
+	valueType uint32
+	data      []byte
+}
+
+// NewHive returns an empty hive, which only has a root key.
+func NewHive() *Hive {
+	return &Hive{root: &HiveKey{name: "ROOT"}}
+}
+
+// Key returns the key at keyPath, e.g. "Objects/{guid}/Elements", creating it and its parents when they do not exist.
+// Key names are case-insensitive and an empty keyPath returns the root key.
+func (h *Hive) Key(keyPath string) *HiveKey {
+	key := h.root
+	for _, name := range strings.Split(keyPath, "/") {
+		if name != "" {
+			key = key.Subkey(name)
+		}
+	}
+	return key
+}
+
+// Subkey returns the subkey with name, creating it when it does not exist.
+func (k *HiveKey) Subkey(name string) *HiveKey {
+	for _, subkey := range k.subkeys {
+		if strings.EqualFold(subkey.name, name) {
+			return subkey
+		}
+	}
+	subkey := &HiveKey{name: name}
+	k.subkeys = append(k.subkeys, subkey)
+	return subkey
+}
+
+// SetValue sets the value with name to data of valueType, e.g. RegBinary. An empty name sets the default value.
+func (k *HiveKey) SetValue(name string, valueType uint32, data []byte) {
+	value := hiveValue{name: name, valueType: valueType, data: data}
+	for i := range k.values {
+		if strings.EqualFold(k.values[i].name, name) {
+			k.values[i] = value
+			return
+		}
+	}
+	k.values = append(k.values, value)
+}
+
+// SetString sets the value with name to a REG_SZ string.
+func (k *HiveKey) SetString(name, value string) {
+	k.SetValue(name, RegSz, encodeUTF16(value+"\x00"))
+}
+
+// SetDword sets the value with name to a REG_DWORD.
+func (k *HiveKey) SetDword(name string, value uint32) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, value)
+	k.SetValue(name, RegDword, data)
+}
+
+// Bytes encodes the hive in the regf format.
+func (h *Hive) Bytes() ([]byte, error) {
+	sd, err := SecurityDescriptorFromSDDL(hiveSDDL)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &hiveEncoder{bins: make([]byte, hiveBinHeaderLen)}
+	rootOffset, err := e.writeKey(h.root, 0, hiveKeyRoot|hiveKeyCompName, sd)
+	if err != nil {
+		return nil, err
+	}
+	e.closeBins()
+
+	base := make([]byte, hiveBaseBlockLen)
+	copy(base, "regf")
+	le := binary.LittleEndian
+	le.PutUint32(base[0x04:], 1) // primary sequence number
+	le.PutUint32(base[0x08:], 1) // secondary sequence number
+	le.PutUint64(base[0x0C:], fileTime(hiveTime))
+	le.PutUint32(base[0x14:], 1) // major version
+	le.PutUint32(base[0x18:], 5) // minor version
+	le.PutUint32(base[0x1C:], 0) // primary file
+	le.PutUint32(base[0x20:], 1) // direct memory load
+	le.PutUint32(base[0x24:], rootOffset)
+	le.PutUint32(base[0x28:], uint32(len(e.bins)))
+	le.PutUint32(base[0x2C:], 1) // clustering factor
+
+	le.PutUint32(base[0x1FC:], hiveChecksum(base))
+
+	return append(base, e.bins...), nil
+}
+
+// hiveChecksum returns the XOR-32 checksum of the first 508 bytes of the base block. Windows rejects the values 0 and
+// 0xFFFFFFFF, which are written as 1 and 0xFFFFFFFE.
+func hiveChecksum(base []byte) uint32 {
+	var checksum uint32
+	for i := 0; i < 0x1FC; i += 4 {
+		checksum ^= binary.LittleEndian.Uint32(base[i:])
+	}
+	switch checksum {
+	case 0:
+		return 1
+	case 0xFFFFFFFF:
+		return 0xFFFFFFFE
+	}
+	return checksum
+}
+
+// hiveEncoder allocates the cells of a hive in a single hive bin. Offsets are relative to the start of the bin.
+type hiveEncoder struct {
+	bins       []byte
+	skOffset   uint32
+	skRefCount uint32
+}
+
+// alloc appends an allocated cell for data of size and returns its offset.
+func (e *hiveEncoder) alloc(size int) uint32 {
+	cellSize := (size + 4 + 7) &^ 7
+	offset := uint32(len(e.bins))
+	e.bins = append(e.bins, make([]byte, cellSize)...)
+	e.put32(offset, uint32(-int32(cellSize)))
+	return offset
+}
+
+// cell returns the data of the cell at offset.
+func (e *hiveEncoder) cell(offset uint32) []byte {
+	return e.bins[offset+4:]
+}
+
+func (e *hiveEncoder) put16(offset uint32, value uint16) {
+	binary.LittleEndian.PutUint16(e.bins[offset:], value)
+}
+
+func (e *hiveEncoder) put32(offset uint32, value uint32) {
+	binary.LittleEndian.PutUint32(e.bins[offset:], value)
+}
+
+// closeBins pads the bin to a multiple of the bin size with a free cell and writes its header.
+func (e *hiveEncoder) closeBins() {
+	if free := len(e.bins) % hiveBinLen; free != 0 {
+		offset := uint32(len(e.bins))
+		e.bins = append(e.bins, make([]byte, hiveBinLen-free)...)
+		e.put32(offset, uint32(hiveBinLen-free))
+	}
+	copy(e.bins, "hbin")
+	e.put32(0x04, 0) // offset of the bin
+	e.put32(0x08, uint32(len(e.bins)))
+	binary.LittleEndian.PutUint64(e.bins[0x14:], fileTime(hiveTime))
+	e.put32(e.skOffset+4+0x0C, e.skRefCount)
+}
+
+// writeKey writes the nk cell of key and the cells of its values and subkeys, and returns the offset of the nk cell.
+func (e *hiveEncoder) writeKey(key *HiveKey, parent uint32, flags uint16, sd []byte) (uint32, error) {
+	name, compressed := encodeHiveName(key.name)
+	if compressed {
+		flags |= hiveKeyCompName
+	} else {
+		flags &^= hiveKeyCompName
+	}
+
+	offset := e.alloc(hiveNKLen + len(name))
+	nk := offset + 4
+	copy(e.cell(offset), "nk")
+	e.put16(nk+0x02, flags)
+	binary.LittleEndian.PutUint64(e.bins[nk+0x04:], fileTime(hiveTime))
+	e.put32(nk+0x10, parent)
+	e.put32(nk+0x14, uint32(len(key.subkeys)))
+	e.put32(nk+0x1C, hiveNoOffset)
+	e.put32(nk+0x20, hiveNoOffset)
+	e.put32(nk+0x24, uint32(len(key.values)))
+	e.put32(nk+0x28, hiveNoOffset)
+	e.put32(nk+0x30, hiveNoOffset)
+	e.put16(nk+0x48, uint16(len(name)))
+	copy(e.bins[nk+hiveNKLen:], name)
+
+	if sd != nil {
+		e.skOffset = e.writeSecurity(sd)
+	}
+	e.put32(nk+0x2C, e.skOffset)
+	e.skRefCount++
+
+	if len(key.values) > 0 {
+		listOffset, maxNameLen, maxDataLen, err := e.writeValues(key.values)
+		if err != nil {
+			return 0, err
+		}
+		e.put32(nk+0x28, listOffset)
+		e.put32(nk+0x3C, maxNameLen)
+		e.put32(nk+0x40, maxDataLen)
+	}
+
+	if len(key.subkeys) > 0 {
+		subkeys := make([]*HiveKey, len(key.subkeys))
+		copy(subkeys, key.subkeys)
+		sort.Slice(subkeys, func(i, j int) bool {
+			return strings.ToUpper(subkeys[i].name) < strings.ToUpper(subkeys[j].name)
+		})
+
+		listOffset := e.alloc(4 + 8*len(subkeys))
+		copy(e.cell(listOffset), "lh")
+		e.put16(listOffset+4+0x02, uint16(len(subkeys)))
+
+		var maxNameLen uint32
+		for i, subkey := range subkeys {
+			subkeyOffset, err := e.writeKey(subkey, offset, 0, nil)
+			if err != nil {
+				return 0, err
+			}
+			entry := listOffset + 4 + 4 + uint32(8*i)
+			e.put32(entry, subkeyOffset)
+			e.put32(entry+4, hiveNameHash(subkey.name))
+			if nameLen := uint32(2 * len(utf16.Encode([]rune(subkey.name)))); nameLen > maxNameLen {
+				maxNameLen = nameLen
+			}
+		}
+		e.put32(nk+0x1C, listOffset)
+		e.put32(nk+0x34, maxNameLen)
+	}
+
+	return offset, nil
+}
+
+// writeSecurity writes an sk cell with sd, which is its own previous and next sk cell.
+func (e *hiveEncoder) writeSecurity(sd []byte) uint32 {
+	offset := e.alloc(0x14 + len(sd))
+	sk := offset + 4
+	copy(e.cell(offset), "sk")
+	e.put32(sk+0x04, offset) // flink
+	e.put32(sk+0x08, offset) // blink
+	e.put32(sk+0x10, uint32(len(sd)))
+	copy(e.bins[sk+0x14:], sd)
+	return offset
+}
+
+// writeValues writes the vk cells of values and the list referencing them.
+func (e *hiveEncoder) writeValues(values []hiveValue) (uint32, uint32, uint32, error) {
+	listOffset := e.alloc(4 * len(values))
+
+	var maxNameLen, maxDataLen uint32
+	for i, value := range values {
+		if len(value.data) > hiveMaxDataLen {
+			return 0, 0, 0, fmt.Errorf("value '%s' is too large: %d bytes exceeds %d", value.name, len(value.data), hiveMaxDataLen)
+		}
+
+		name, compressed := encodeHiveName(value.name)
+		offset := e.alloc(hiveVKLen + len(name))
+		vk := offset + 4
+		copy(e.cell(offset), "vk")
+		e.put16(vk+0x02, uint16(len(name)))
+		e.put32(vk+0x0C, value.valueType)
+		if compressed && len(name) > 0 {
+			e.put16(vk+0x10, hiveValCompName)
+		}
+		copy(e.bins[vk+hiveVKLen:], name)
+
+		if len(value.data) <= 4 {
+			e.put32(vk+0x04, uint32(len(value.data))|hiveDataInline)
+			copy(e.bins[vk+0x08:vk+0x0C], value.data)
+		} else {
+			dataOffset := e.alloc(len(value.data))
+			copy(e.cell(dataOffset), value.data)
+			e.put32(vk+0x04, uint32(len(value.data)))
+			e.put32(vk+0x08, dataOffset)
+		}
+		e.put32(listOffset+4+uint32(4*i), offset)
+
+		if nameLen := uint32(2 * len(utf16.Encode([]rune(value.name)))); nameLen > maxNameLen {
+			maxNameLen = nameLen
+		}
+		if dataLen := uint32(len(value.data)); dataLen > maxDataLen {
+			maxDataLen = dataLen
+		}
+	}
+	return listOffset, maxNameLen, maxDataLen, nil
+}
+
+// encodeHiveName encodes name as Latin-1 when possible, which is flagged as a compressed name, or as UTF-16 otherwise.
+func encodeHiveName(name string) ([]byte, bool) {
+	compressed := make([]byte, 0, len(name))
+	for _, r := range name {
+		if r > 0xFF {
+			return encodeUTF16(name), false
+		}
+		compressed = append(compressed, byte(r))
+	}
+	return compressed, true
+}
+
+// hiveNameHash is the hash of name used by lh subkey lists.
+func hiveNameHash(name string) uint32 {
+	var hash uint32
+	for _, c := range utf16.Encode([]rune(strings.ToUpper(name))) {
+		hash = hash*37 + uint32(c)
+	}
+	return hash
+}
+
+func encodeUTF16(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	data := make([]byte, 2*len(units))
+	for i, unit := range units {
+		binary.LittleEndian.PutUint16(data[2*i:], unit)
+	}
+	return data
+}
+
+// fileTime converts t to a Windows FILETIME, the number of 100ns intervals since January 1, 1601.
+func fileTime(t time.Time) uint64 {
+	const epochDelta = 116444736000000000
+	return uint64(t.UnixNano()/100) + epochDelta
+}
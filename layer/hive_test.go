@@ -0,0 +1,272 @@
+package layer_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/layer"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestHive(t *testing.T) {
+	spec.Run(t, "hive", testHive, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testHive(t *testing.T, when spec.G, it spec.S) {
+	when("#Bytes", func() {
+		it("encodes an empty hive", func() {
+			hiveBytes, err := layer.NewHive().Bytes()
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, len(hiveBytes), 0x2000)
+			h.AssertEq(t, readHive(t, hiveBytes), map[string]string{})
+		})
+
+		it("encodes keys and values", func() {
+			hive := layer.NewHive()
+			hive.Key("Some/Key").SetString("some-string", "some-value")
+			hive.Key("Some/Key").SetDword("some-dword", 0x12345678)
+			hive.Key("some/other-key").SetValue("", layer.RegBinary, []byte{1, 2, 3, 4, 5})
+			hive.Key("Some/Key").SetDword("SOME-DWORD", 1)
+
+			hiveBytes, err := hive.Bytes()
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, readHive(t, hiveBytes), map[string]string{
+				"Some/Key:some-string": "1:some-value",
+				"Some/Key:SOME-DWORD":  "4:00000001",
+				"Some/other-key:":      "3:0102030405",
+				"Some/Key":             "",
+				"Some/other-key":       "",
+				"Some":                 "",
+			})
+		})
+
+		it("encodes names that are not Latin-1", func() {
+			hive := layer.NewHive()
+			hive.Key("キー").SetString("値", "value")
+
+			hiveBytes, err := hive.Bytes()
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, readHive(t, hiveBytes), map[string]string{
+				"キー":   "",
+				"キー:値": "1:value",
+			})
+		})
+
+		it("grows hive bins for large hives", func() {
+			hive := layer.NewHive()
+			for i := 0; i < 100; i++ {
+				hive.Key(fmt.Sprintf("key-%d", i)).SetValue("data", layer.RegBinary, make([]byte, 1000))
+			}
+
+			hiveBytes, err := hive.Bytes()
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, len(readHive(t, hiveBytes)), 200)
+		})
+
+		it("fails for values larger than a cell", func() {
+			hive := layer.NewHive()
+			hive.Key("some-key").SetValue("some-value", layer.RegBinary, make([]byte, 20000))
+
+			_, err := hive.Bytes()
+			h.AssertError(t, err, "value 'some-value' is too large")
+		})
+
+		it("is reproducible", func() {
+			first, err := layer.BCDHive().Bytes()
+			h.AssertNil(t, err)
+			second, err := layer.BCDHive().Bytes()
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, first, second)
+		})
+	})
+
+	when("#BaseLayerBCD", func() {
+		it("has the boot manager and OS loader objects", func() {
+			bcdBytes, err := layer.BaseLayerBCD()
+			h.AssertNil(t, err)
+
+			osLoader := "Objects/{6a6c1f1b-59d4-11ea-9438-9402e6abd998}"
+			bootMgr := "Objects/{9dea862c-5cdd-4e70-acc1-f32b344d4795}"
+			h.AssertEq(t, readHive(t, bcdBytes), map[string]string{
+				"Description":                           "",
+				"Description:FirmwareModified":          "4:00000001",
+				"Description:KeyName":                   "1:BCD00000000",
+				"Objects":                               "",
+				osLoader:                                "",
+				osLoader + "/Description":               "",
+				osLoader + "/Description:Type":          "4:10200003",
+				osLoader + "/Elements":                  "",
+				osLoader + "/Elements/12000004":         "",
+				osLoader + "/Elements/12000004:Element": "1:buildpacks.io",
+				bootMgr:                                 "",
+				bootMgr + "/Description":                "",
+				bootMgr + "/Description:Type":           "4:10100002",
+				bootMgr + "/Elements":                   "",
+				bootMgr + "/Elements/23000003":          "",
+				bootMgr + "/Elements/23000003:Element":  "1:{6a6c1f1b-59d4-11ea-9438-9402e6abd998}",
+			})
+		})
+
+		it("has the keys and values of the BCD store generated with hivex", func() {
+			bcdBytes, err := layer.BaseLayerBCD()
+			h.AssertNil(t, err)
+			golden, err := hivexBCD()
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, readHive(t, bcdBytes), readHive(t, golden))
+		})
+	})
+}
+
+// readHive validates the structure of hiveBytes and returns its keys, mapped to "", and values, mapped to
+// "<type>:<data>", with REG_SZ data as a string, REG_DWORD data in hex and other data as hex bytes.
+func readHive(t *testing.T, hiveBytes []byte) map[string]string {
+	t.Helper()
+	le := binary.LittleEndian
+
+	h.AssertEq(t, string(hiveBytes[:4]), "regf")
+	var checksum uint32
+	for i := 0; i < 0x1FC; i += 4 {
+		checksum ^= le.Uint32(hiveBytes[i:])
+	}
+	switch checksum {
+	case 0:
+		checksum = 1
+	case 0xFFFFFFFF:
+		checksum = 0xFFFFFFFE
+	}
+	h.AssertEq(t, le.Uint32(hiveBytes[0x1FC:]), checksum)
+
+	bins := hiveBytes[0x1000:]
+	h.AssertEq(t, int(le.Uint32(hiveBytes[0x28:])), len(bins))
+
+	// every cell is within a bin and the cells fill the bins
+	allocated := map[uint32]bool{}
+	for binOffset := 0; binOffset < len(bins); {
+		h.AssertEq(t, string(bins[binOffset:binOffset+4]), "hbin")
+		h.AssertEq(t, int(le.Uint32(bins[binOffset+4:])), binOffset)
+		binSize := int(le.Uint32(bins[binOffset+8:]))
+		h.AssertEq(t, binSize%0x1000, 0)
+
+		cellOffset := binOffset + 0x20
+		for cellOffset < binOffset+binSize {
+			size := int32(le.Uint32(bins[cellOffset:]))
+			if size < 0 {
+				allocated[uint32(cellOffset)] = true
+				size = -size
+			}
+			h.AssertEq(t, size%8, int32(0))
+			cellOffset += int(size)
+		}
+		h.AssertEq(t, cellOffset, binOffset+binSize)
+		binOffset += binSize
+	}
+
+	cell := func(offset uint32) []byte {
+		t.Helper()
+		if !allocated[offset] {
+			t.Fatalf("offset 0x%x is not an allocated cell", offset)
+		}
+		return bins[offset+4:]
+	}
+	name := func(data []byte, compressed bool) string {
+		if compressed {
+			runes := make([]rune, len(data))
+			for i, b := range data {
+				runes[i] = rune(b)
+			}
+			return string(runes)
+		}
+		return decodeUTF16(data)
+	}
+
+	entries := map[string]string{}
+	var readKey func(offset uint32, parent uint32, keyPath string)
+	readKey = func(offset uint32, parent uint32, keyPath string) {
+		nk := cell(offset)
+		h.AssertEq(t, string(nk[:2]), "nk")
+		if keyPath != "" {
+			h.AssertEq(t, le.Uint32(nk[0x10:]), parent)
+		}
+		h.AssertEq(t, string(cell(le.Uint32(nk[0x2C:]))[:2]), "sk")
+
+		if valueCount := le.Uint32(nk[0x24:]); valueCount > 0 {
+			list := cell(le.Uint32(nk[0x28:]))
+			for i := uint32(0); i < valueCount; i++ {
+				vk := cell(le.Uint32(list[4*i:]))
+				h.AssertEq(t, string(vk[:2]), "vk")
+				valueName := name(vk[0x14:0x14+le.Uint16(vk[2:])], le.Uint16(vk[0x10:])&1 == 1)
+
+				dataSize := le.Uint32(vk[4:])
+				var data []byte
+				if dataSize&0x80000000 != 0 {
+					data = vk[8 : 8+dataSize&^0x80000000]
+				} else {
+					data = cell(le.Uint32(vk[8:]))[:dataSize]
+				}
+
+				var value string
+				switch valueType := le.Uint32(vk[0x0C:]); valueType {
+				case layer.RegSz:
+					value = strings.TrimSuffix(decodeUTF16(data), "\x00")
+				case layer.RegDword:
+					value = fmt.Sprintf("%08x", le.Uint32(data))
+				default:
+					value = fmt.Sprintf("%x", data)
+				}
+				entries[keyPath+":"+valueName] = fmt.Sprintf("%d:%s", le.Uint32(vk[0x0C:]), value)
+			}
+		}
+
+		if subkeyCount := le.Uint32(nk[0x14:]); subkeyCount > 0 {
+			list := cell(le.Uint32(nk[0x1C:]))
+			h.AssertEq(t, string(list[:2]), "lh")
+			h.AssertEq(t, uint32(le.Uint16(list[2:])), subkeyCount)
+
+			var names []string
+			for i := uint32(0); i < subkeyCount; i++ {
+				subkeyOffset := le.Uint32(list[4+8*i:])
+				subkey := cell(subkeyOffset)
+				subkeyName := name(subkey[0x4C:0x4C+le.Uint16(subkey[0x48:])], le.Uint16(subkey[2:])&0x20 != 0)
+				names = append(names, strings.ToUpper(subkeyName))
+
+				var hash uint32
+				for _, c := range utf16.Encode([]rune(strings.ToUpper(subkeyName))) {
+					hash = hash*37 + uint32(c)
+				}
+				h.AssertEq(t, le.Uint32(list[4+8*i+4:]), hash)
+
+				subkeyPath := subkeyName
+				if keyPath != "" {
+					subkeyPath = keyPath + "/" + subkeyName
+				}
+				entries[subkeyPath] = ""
+				readKey(subkeyOffset, offset, subkeyPath)
+			}
+			h.AssertEq(t, sort.StringsAreSorted(names), true)
+		}
+	}
+	readKey(le.Uint32(hiveBytes[0x24:]), 0, "")
+
+	return entries
+}
+
+func decodeUTF16(data []byte) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(data[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
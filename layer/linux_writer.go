@@ -0,0 +1,140 @@
+package layer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// normalizedModTime is the modification time of every entry written by LinuxWriter.
+var normalizedModTime = time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC)
+
+// LinuxWriter writes reproducible layers for Linux images: modification times, user names and group names are
+// normalized and entries are written in the USTAR format, unless they need PAX records. Entries are named relative to
+// the root of the filesystem, as in the layers Docker creates, e.g. "/cnb/lifecycle" is written as "cnb/lifecycle".
+type LinuxWriter struct {
+	tarWriter          *tar.Writer
+	writtenParentPaths map[string]bool
+}
+
+func NewLinuxWriter(fileWriter io.Writer) *LinuxWriter {
+	return &LinuxWriter{
+		tarWriter:          tar.NewWriter(fileWriter),
+		writtenParentPaths: map[string]bool{},
+	}
+}
+
+func (w *LinuxWriter) Write(content []byte) (int, error) {
+	return w.tarWriter.Write(content)
+}
+
+// WriteHeader writes a copy of header, named relative to the root of the filesystem and normalized.
+func (w *LinuxWriter) WriteHeader(header *tar.Header) error {
+	if err := w.validateHeader(header); err != nil {
+		return err
+	}
+	entry := *header
+	entry.Name = relativePath(entry.Name)
+	if entry.Typeflag == tar.TypeLink && path.IsAbs(entry.Linkname) {
+		entry.Linkname = relativePath(entry.Linkname)
+	}
+
+	if err := w.writeParentPaths(entry.Name); err != nil {
+		return err
+	}
+
+	normalizeHeader(&entry)
+	if entry.Typeflag == tar.TypeDir {
+		return w.writeDirHeader(&entry)
+	}
+	return w.tarWriter.WriteHeader(&entry)
+}
+
+// WriteWhiteout marks the file or directory at the absolute, posix path filePath as deleted from lower layers.
+func (w *LinuxWriter) WriteWhiteout(filePath string) error {
+	return w.WriteHeader(&tar.Header{
+		Name:     WhiteoutPath(filePath),
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+	})
+}
+
+// WriteOpaqueWhiteout hides the contents of the directory at the absolute, posix path dirPath in lower layers.
+func (w *LinuxWriter) WriteOpaqueWhiteout(dirPath string) error {
+	return w.WriteHeader(&tar.Header{
+		Name:     path.Join(dirPath, OpaqueWhiteout),
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+	})
+}
+
+func (w *LinuxWriter) Close() error {
+	return w.tarWriter.Close()
+}
+
+func (w *LinuxWriter) Flush() error {
+	return w.tarWriter.Flush()
+}
+
+func (w *LinuxWriter) writeParentPaths(childPath string) error {
+	parentDir := ""
+	for _, pathPart := range strings.Split(path.Dir(childPath), "/") {
+		if pathPart == "" || pathPart == "." {
+			continue
+		}
+		parentDir = path.Join(parentDir, pathPart)
+
+		header := &tar.Header{
+			Name:     parentDir,
+			Typeflag: tar.TypeDir,
+			Mode:     0755,
+		}
+		normalizeHeader(header)
+		if err := w.writeDirHeader(header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *LinuxWriter) writeDirHeader(header *tar.Header) error {
+	if w.writtenParentPaths[header.Name] {
+		return nil
+	}
+
+	if err := w.tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	w.writtenParentPaths[header.Name] = true
+	return nil
+}
+
+// relativePath returns the absolute, posix path p relative to the root of the filesystem.
+func relativePath(p string) string {
+	return strings.TrimPrefix(path.Clean(p), "/")
+}
+
+func (w *LinuxWriter) validateHeader(header *tar.Header) error {
+	if !path.IsAbs(header.Name) || header.Name == "/" {
+		return fmt.Errorf("invalid header name: must be absolute, posix path: %s", header.Name)
+	}
+	return nil
+}
+
+func normalizeHeader(header *tar.Header) {
+	header.ModTime = normalizedModTime
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.Uname = ""
+	header.Gname = ""
+
+	if len(header.PAXRecords) == 0 {
+		// let the tar writer choose USTAR, and PAX only when the header cannot be encoded otherwise
+		header.Format = tar.FormatUnknown
+	} else {
+		header.Format = tar.FormatPAX
+	}
+}
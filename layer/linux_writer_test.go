@@ -0,0 +1,195 @@
+package layer_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/layer"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestLinuxWriter(t *testing.T) {
+	spec.Run(t, "linux-writer", testLinuxWriter, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testLinuxWriter(t *testing.T, when spec.G, it spec.S) {
+	var (
+		buf *bytes.Buffer
+		lw  *layer.LinuxWriter
+	)
+
+	it.Before(func() {
+		buf = &bytes.Buffer{}
+		lw = layer.NewLinuxWriter(buf)
+	})
+
+	readHeaders := func() []*tar.Header {
+		t.Helper()
+		var headers []*tar.Header
+		tr := tar.NewReader(buf)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return headers
+			}
+			h.AssertNil(t, err)
+			headers = append(headers, header)
+		}
+	}
+
+	when("#WriteHeader", func() {
+		it("writes missing parent directories once", func() {
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{Name: "/cnb/lifecycle/launcher", Typeflag: tar.TypeReg, Mode: 0755}))
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{Name: "/cnb/lifecycle/builder", Typeflag: tar.TypeReg, Mode: 0755}))
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{Name: "/cnb", Typeflag: tar.TypeDir, Mode: 0700}))
+			h.AssertNil(t, lw.Close())
+
+			headers := readHeaders()
+			h.AssertEq(t, len(headers), 4)
+
+			h.AssertEq(t, headers[0].Name, "cnb")
+			h.AssertEq(t, headers[0].Typeflag, byte(tar.TypeDir))
+			h.AssertEq(t, headers[0].Mode, int64(0755))
+			h.AssertEq(t, headers[1].Name, "cnb/lifecycle")
+			h.AssertEq(t, headers[1].Typeflag, byte(tar.TypeDir))
+			h.AssertEq(t, headers[2].Name, "cnb/lifecycle/launcher")
+			h.AssertEq(t, headers[3].Name, "cnb/lifecycle/builder")
+		})
+
+		it("names entries and hardlink targets relative to the root of the filesystem", func() {
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{Name: "/some-file", Typeflag: tar.TypeReg}))
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{Name: "/some-hardlink", Typeflag: tar.TypeLink, Linkname: "/some-file"}))
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{Name: "/some-symlink", Typeflag: tar.TypeSymlink, Linkname: "/some-file"}))
+			h.AssertNil(t, lw.Close())
+
+			headers := readHeaders()
+			h.AssertEq(t, len(headers), 3)
+			h.AssertEq(t, headers[0].Name, "some-file")
+			h.AssertEq(t, headers[1].Name, "some-hardlink")
+			h.AssertEq(t, headers[1].Linkname, "some-file")
+			h.AssertEq(t, headers[2].Name, "some-symlink")
+			h.AssertEq(t, headers[2].Linkname, "/some-file")
+		})
+
+		it("normalizes headers", func() {
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{
+				Name:       "/some-file",
+				Typeflag:   tar.TypeReg,
+				Mode:       0644,
+				Uid:        1000,
+				Gid:        1000,
+				Uname:      "some-user",
+				Gname:      "some-group",
+				ModTime:    time.Now(),
+				AccessTime: time.Now(),
+				ChangeTime: time.Now(),
+				Format:     tar.FormatGNU,
+			}))
+			h.AssertNil(t, lw.Close())
+
+			headers := readHeaders()
+			h.AssertEq(t, len(headers), 1)
+			h.AssertEq(t, headers[0].ModTime.UTC(), time.Date(1980, time.January, 1, 0, 0, 1, 0, time.UTC))
+			h.AssertEq(t, headers[0].AccessTime.IsZero(), true)
+			h.AssertEq(t, headers[0].ChangeTime.IsZero(), true)
+			h.AssertEq(t, headers[0].Uname, "")
+			h.AssertEq(t, headers[0].Gname, "")
+			h.AssertEq(t, headers[0].Uid, 1000)
+			h.AssertEq(t, headers[0].Gid, 1000)
+			h.AssertEq(t, headers[0].Format, tar.FormatUSTAR)
+		})
+
+		it("uses PAX for headers with PAX records or long names", func() {
+			longName := "/" + strings.Repeat("a", 120)
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{
+				Name:       "/some-file",
+				Typeflag:   tar.TypeReg,
+				PAXRecords: map[string]string{"SCHILY.xattr.user.some-key": "some-value"},
+			}))
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{Name: longName, Typeflag: tar.TypeReg}))
+			h.AssertNil(t, lw.Close())
+
+			headers := readHeaders()
+			h.AssertEq(t, len(headers), 2)
+			h.AssertEq(t, headers[0].Format, tar.FormatPAX)
+			h.AssertEq(t, headers[0].PAXRecords["SCHILY.xattr.user.some-key"], "some-value")
+			h.AssertEq(t, headers[1].Name, strings.TrimPrefix(longName, "/"))
+			h.AssertEq(t, headers[1].Format, tar.FormatPAX)
+		})
+
+		it("is reproducible", func() {
+			write := func(modTime time.Time) []byte {
+				buf := &bytes.Buffer{}
+				lw := layer.NewLinuxWriter(buf)
+				h.AssertNil(t, lw.WriteHeader(&tar.Header{Name: "/some/file", Typeflag: tar.TypeReg, Size: 4, ModTime: modTime}))
+				_, err := lw.Write([]byte("data"))
+				h.AssertNil(t, err)
+				h.AssertNil(t, lw.Close())
+				return buf.Bytes()
+			}
+
+			h.AssertEq(t, write(time.Now()), write(time.Now().Add(time.Hour)))
+		})
+
+		when("header.Name is invalid", func() {
+			it("returns an error", func() {
+				err := lw.WriteHeader(&tar.Header{Name: "some/relative/path", Typeflag: tar.TypeReg})
+				h.AssertError(t, err, "invalid header name: must be absolute, posix path: some/relative/path")
+			})
+		})
+	})
+
+	when("whiteouts", func() {
+		it("writes whiteouts and opaque whiteouts", func() {
+			h.AssertNil(t, lw.WriteWhiteout("/some/dir/deleted-file"))
+			h.AssertNil(t, lw.WriteOpaqueWhiteout("/some/other-dir"))
+			h.AssertNil(t, lw.Close())
+
+			headers := readHeaders()
+			h.AssertEq(t, len(headers), 5)
+			h.AssertEq(t, headers[0].Name, "some")
+			h.AssertEq(t, headers[1].Name, "some/dir")
+			h.AssertEq(t, headers[2].Name, "some/dir/.wh.deleted-file")
+			h.AssertEq(t, headers[2].Size, int64(0))
+			h.AssertEq(t, headers[3].Name, "some/other-dir")
+			h.AssertEq(t, headers[4].Name, "some/other-dir/.wh..wh..opq")
+		})
+	})
+
+	when("#NewWriter", func() {
+		it("chooses the writer of the OS", func() {
+			_, ok := layer.NewWriter(buf, "windows").(*layer.WindowsWriter)
+			h.AssertEq(t, ok, true)
+			_, ok = layer.NewWriter(buf, "linux").(*layer.LinuxWriter)
+			h.AssertEq(t, ok, true)
+		})
+
+		it("leaves the headers of the caller unchanged", func() {
+			for _, osType := range []string{"linux", "windows"} {
+				header := &tar.Header{
+					Name:       "/some-dir/hardlink",
+					Typeflag:   tar.TypeLink,
+					Linkname:   "/some-dir/some-file",
+					PAXRecords: map[string]string{"some-key": "some-value"},
+				}
+				w := layer.NewWriter(&bytes.Buffer{}, osType)
+				h.AssertNil(t, w.WriteHeader(header))
+				h.AssertNil(t, w.Close())
+
+				h.AssertEq(t, header, &tar.Header{
+					Name:       "/some-dir/hardlink",
+					Typeflag:   tar.TypeLink,
+					Linkname:   "/some-dir/some-file",
+					PAXRecords: map[string]string{"some-key": "some-value"},
+				})
+			}
+		})
+	})
+}
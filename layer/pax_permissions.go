@@ -4,6 +4,7 @@ package layer
 // $sddlBytes = [byte[]]::New($sddl.RawDescriptor.BinaryLength)
 // $sddl.RawDescriptor.GetBinaryForm($sddlBytes, 0)
 // [Convert]::ToBase64String($sddlBytes)
+// or, without PowerShell, by base64-encoding SecurityDescriptorFromSDDL($sddlValue)
 
 // owner: BUILTIN/Administrators group: BUILTIN/Administrators ($sddlValue="O:BAG:BA")
 const AdministratratorOwnerAndGroupSID = "AQAAgBQAAAAkAAAAAAAAAAAAAAABAgAAAAAABSAAAAAgAgAAAQIAAAAAAAUgAAAAIAIAAA=="
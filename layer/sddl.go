@@ -0,0 +1,345 @@
+package layer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// security descriptor control flags
+const (
+	seDaclPresent         = 0x0004
+	seDaclAutoInheritReq  = 0x0100
+	seDaclAutoInherited   = 0x0400
+	seDaclProtected       = 0x1000
+	seSelfRelative        = 0x8000
+	aclRevision           = 2
+	securityDescriptorLen = 20
+	aclHeaderLen          = 8
+	aceHeaderLen          = 8
+)
+
+// well-known SIDs, by their SDDL alias
+var sddlSIDAliases = map[string]string{
+	"AN": "S-1-5-7",
+	"AU": "S-1-5-11",
+	"BA": "S-1-5-32-544",
+	"BG": "S-1-5-32-546",
+	"BO": "S-1-5-32-551",
+	"BU": "S-1-5-32-545",
+	"CG": "S-1-3-1",
+	"CO": "S-1-3-0",
+	"IU": "S-1-5-4",
+	"LS": "S-1-5-19",
+	"NS": "S-1-5-20",
+	"NU": "S-1-5-2",
+	"OW": "S-1-3-4",
+	"PU": "S-1-5-32-547",
+	"RD": "S-1-5-32-555",
+	"SU": "S-1-5-6",
+	"SY": "S-1-5-18",
+	"WD": "S-1-1-0",
+}
+
+var sddlACETypes = map[string]byte{
+	"A": 0x0, // ACCESS_ALLOWED_ACE_TYPE
+	"D": 0x1, // ACCESS_DENIED_ACE_TYPE
+}
+
+var sddlACEFlags = map[string]byte{
+	"OI": 0x01,
+	"CI": 0x02,
+	"NP": 0x04,
+	"IO": 0x08,
+	"ID": 0x10,
+}
+
+var sddlRights = map[string]uint32{
+	"GA": 0x10000000,
+	"GX": 0x20000000,
+	"GW": 0x40000000,
+	"GR": 0x80000000,
+	"SD": 0x00010000,
+	"RC": 0x00020000,
+	"WD": 0x00040000,
+	"WO": 0x00080000,
+	"FA": 0x001F01FF,
+	"FR": 0x00120089,
+	"FW": 0x00120116,
+	"FX": 0x001200A0,
+	"KA": 0x000F003F,
+	"KR": 0x00020019,
+	"KW": 0x00020006,
+	"KX": 0x00020019,
+	"CC": 0x00000001,
+	"DC": 0x00000002,
+	"LC": 0x00000004,
+	"SW": 0x00000008,
+	"RP": 0x00000010,
+	"WP": 0x00000020,
+	"DT": 0x00000040,
+	"LO": 0x00000080,
+	"CR": 0x00000100,
+}
+
+// SecurityDescriptorFromSDDL encodes sddl, e.g. "O:BAG:BAD:P(A;OICI;FA;;;BA)", as a binary self-relative security
+// descriptor. It supports owners, groups and DACLs with allow and deny ACEs. SIDs are given as SDDL aliases or in
+// the S-1-... form, e.g. "S-1-5-93-2-2" for ContainerUser.
+func SecurityDescriptorFromSDDL(sddl string) ([]byte, error) {
+	parts, err := splitSDDL(sddl)
+	if err != nil {
+		return nil, err
+	}
+
+	control := uint16(seSelfRelative)
+	var owner, group, dacl []byte
+
+	if value, ok := parts['O']; ok {
+		if owner, err = encodeSID(value); err != nil {
+			return nil, err
+		}
+	}
+	if value, ok := parts['G']; ok {
+		if group, err = encodeSID(value); err != nil {
+			return nil, err
+		}
+	}
+	if value, ok := parts['D']; ok {
+		var daclControl uint16
+		if dacl, daclControl, err = encodeDACL(value); err != nil {
+			return nil, err
+		}
+		control |= seDaclPresent | daclControl
+	}
+	if _, ok := parts['S']; ok {
+		return nil, fmt.Errorf("invalid SDDL %q: SACLs are not supported", sddl)
+	}
+
+	buf := &bytes.Buffer{}
+	offset := uint32(securityDescriptorLen)
+	offsetOf := func(section []byte) uint32 {
+		if section == nil {
+			return 0
+		}
+		sectionOffset := offset
+		offset += uint32(len(section))
+		return sectionOffset
+	}
+	ownerOffset := offsetOf(owner)
+	groupOffset := offsetOf(group)
+	daclOffset := offsetOf(dacl)
+
+	buf.Write([]byte{1, 0}) // revision, sbz1
+	writeLE(buf, control)
+	writeLE(buf, ownerOffset)
+	writeLE(buf, groupOffset)
+	writeLE(buf, uint32(0)) // sacl
+	writeLE(buf, daclOffset)
+	buf.Write(owner)
+	buf.Write(group)
+	buf.Write(dacl)
+	return buf.Bytes(), nil
+}
+
+// splitSDDL splits sddl into its owner (O), group (G), DACL (D) and SACL (S) components.
+func splitSDDL(sddl string) (map[byte]string, error) {
+	parts := map[byte]string{}
+	rest := strings.TrimSpace(sddl)
+	for rest != "" {
+		if len(rest) < 2 || rest[1] != ':' || !strings.ContainsRune("OGDS", rune(rest[0])) {
+			return nil, fmt.Errorf("invalid SDDL %q: expected O:, G:, D: or S: at %q", sddl, rest)
+		}
+		key := rest[0]
+		if _, ok := parts[key]; ok {
+			return nil, fmt.Errorf("invalid SDDL %q: duplicate %c: component", sddl, key)
+		}
+		rest = rest[2:]
+
+		end := nextComponent(rest)
+		parts[key] = rest[:end]
+		rest = rest[end:]
+	}
+	return parts, nil
+}
+
+// nextComponent returns the index of the next O:, G:, D: or S: component of s, outside of ACEs.
+func nextComponent(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		default:
+			if depth == 0 && i+1 < len(s) && s[i+1] == ':' && strings.ContainsRune("OGDS", rune(s[i])) {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+func encodeSID(value string) ([]byte, error) {
+	if alias, ok := sddlSIDAliases[value]; ok {
+		value = alias
+	}
+
+	fields := strings.Split(value, "-")
+	if len(fields) < 3 || fields[0] != "S" || fields[1] != "1" {
+		return nil, fmt.Errorf("invalid SID %q", value)
+	}
+	authority, err := strconv.ParseUint(fields[2], 10, 48)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SID %q: %s", value, err)
+	}
+	subAuthorities := fields[3:]
+	if len(subAuthorities) > 15 {
+		return nil, fmt.Errorf("invalid SID %q: too many sub-authorities", value)
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{1, byte(len(subAuthorities))})
+	authorityBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(authorityBytes, authority)
+	buf.Write(authorityBytes[2:])
+	for _, field := range subAuthorities {
+		subAuthority, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SID %q: %s", value, err)
+		}
+		writeLE(buf, uint32(subAuthority))
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeDACL(value string) ([]byte, uint16, error) {
+	flagsEnd := strings.IndexByte(value, '(')
+	if flagsEnd < 0 {
+		flagsEnd = len(value)
+	}
+
+	var control uint16
+	flags := value[:flagsEnd]
+	for flags != "" {
+		switch {
+		case strings.HasPrefix(flags, "P"):
+			control |= seDaclProtected
+			flags = flags[1:]
+		case strings.HasPrefix(flags, "AI"):
+			control |= seDaclAutoInherited
+			flags = flags[2:]
+		case strings.HasPrefix(flags, "AR"):
+			control |= seDaclAutoInheritReq
+			flags = flags[2:]
+		default:
+			return nil, 0, fmt.Errorf("invalid DACL flags %q", value[:flagsEnd])
+		}
+	}
+
+	var aces [][]byte
+	rest := value[flagsEnd:]
+	for rest != "" {
+		end := strings.IndexByte(rest, ')')
+		if rest[0] != '(' || end < 0 {
+			return nil, 0, fmt.Errorf("invalid ACE in %q", rest)
+		}
+		ace, err := encodeACE(rest[1:end])
+		if err != nil {
+			return nil, 0, err
+		}
+		aces = append(aces, ace)
+		rest = rest[end+1:]
+	}
+
+	size := aclHeaderLen
+	for _, ace := range aces {
+		size += len(ace)
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{aclRevision, 0})
+	writeLE(buf, uint16(size))
+	writeLE(buf, uint16(len(aces)))
+	writeLE(buf, uint16(0))
+	for _, ace := range aces {
+		buf.Write(ace)
+	}
+	return buf.Bytes(), control, nil
+}
+
+// encodeACE encodes an ACE given as "ace_type;ace_flags;rights;object_guid;inherit_object_guid;account_sid".
+func encodeACE(value string) ([]byte, error) {
+	fields := strings.Split(value, ";")
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid ACE %q: expected 6 fields", value)
+	}
+
+	aceType, ok := sddlACETypes[fields[0]]
+	if !ok {
+		return nil, fmt.Errorf("invalid ACE %q: unsupported type %q", value, fields[0])
+	}
+	if fields[3] != "" || fields[4] != "" {
+		return nil, fmt.Errorf("invalid ACE %q: object ACEs are not supported", value)
+	}
+
+	var aceFlags byte
+	for flags := fields[1]; flags != ""; flags = flags[2:] {
+		flag, ok := sddlACEFlags[flags[:min(2, len(flags))]]
+		if !ok {
+			return nil, fmt.Errorf("invalid ACE %q: unsupported flags %q", value, fields[1])
+		}
+		aceFlags |= flag
+	}
+
+	mask, err := parseRights(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACE %q: %s", value, err)
+	}
+
+	sid, err := encodeSID(fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{aceType, aceFlags})
+	writeLE(buf, uint16(aceHeaderLen+len(sid)))
+	writeLE(buf, mask)
+	buf.Write(sid)
+	return buf.Bytes(), nil
+}
+
+func parseRights(rights string) (uint32, error) {
+	if strings.HasPrefix(rights, "0x") || strings.HasPrefix(rights, "0X") {
+		mask, err := strconv.ParseUint(rights[2:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("unsupported rights %q", rights)
+		}
+		return uint32(mask), nil
+	}
+
+	var mask uint32
+	for r := rights; r != ""; r = r[2:] {
+		right, ok := sddlRights[r[:min(2, len(r))]]
+		if !ok {
+			return 0, fmt.Errorf("unsupported rights %q", rights)
+		}
+		mask |= right
+	}
+	return mask, nil
+}
+
+func writeLE(buf *bytes.Buffer, value interface{}) {
+	// writing to a bytes.Buffer cannot fail
+	_ = binary.Write(buf, binary.LittleEndian, value)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,84 @@
+package layer_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/layer"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestSDDL(t *testing.T) {
+	spec.Run(t, "sddl", testSDDL, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testSDDL(t *testing.T, when spec.G, it spec.S) {
+	when("#SecurityDescriptorFromSDDL", func() {
+		it("encodes owners and groups", func() {
+			sd, err := layer.SecurityDescriptorFromSDDL("O:BAG:BA")
+			h.AssertNil(t, err)
+			h.AssertEq(t, base64.StdEncoding.EncodeToString(sd), layer.AdministratratorOwnerAndGroupSID)
+
+			sd, err = layer.SecurityDescriptorFromSDDL("O:BUG:BU")
+			h.AssertNil(t, err)
+			h.AssertEq(t, base64.StdEncoding.EncodeToString(sd), layer.UserOwnerAndGroupSID)
+		})
+
+		it("encodes SIDs given in the S-1 form", func() {
+			sd, err := layer.SecurityDescriptorFromSDDL("O:S-1-5-32-544G:S-1-5-32-544")
+			h.AssertNil(t, err)
+			h.AssertEq(t, base64.StdEncoding.EncodeToString(sd), layer.AdministratratorOwnerAndGroupSID)
+		})
+
+		it("encodes DACLs", func() {
+			sd, err := layer.SecurityDescriptorFromSDDL("O:S-1-5-93-2-2G:S-1-5-93-2-2D:P(A;OICI;FA;;;S-1-5-93-2-2)")
+			h.AssertNil(t, err)
+
+			containerUser := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x5d, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}
+			expected := []byte{
+				0x01, 0x00, 0x04, 0x90, // revision, control: self-relative, DACL present and protected
+				0x14, 0x00, 0x00, 0x00, // owner offset
+				0x28, 0x00, 0x00, 0x00, // group offset
+				0x00, 0x00, 0x00, 0x00, // SACL offset
+				0x3c, 0x00, 0x00, 0x00, // DACL offset
+			}
+			expected = append(expected, containerUser...)
+			expected = append(expected, containerUser...)
+			expected = append(expected,
+				0x02, 0x00, 0x24, 0x00, 0x01, 0x00, 0x00, 0x00, // ACL revision, size, ACE count
+				0x00, 0x03, 0x1c, 0x00, // allowed ACE, object and container inherit, size
+				0xff, 0x01, 0x1f, 0x00, // file all access
+			)
+			expected = append(expected, containerUser...)
+
+			h.AssertEq(t, sd, expected)
+		})
+
+		it("combines rights", func() {
+			readExecute, err := layer.SecurityDescriptorFromSDDL("D:(A;;0x1200a9;;;BU)")
+			h.AssertNil(t, err)
+
+			combined, err := layer.SecurityDescriptorFromSDDL("D:(A;;FRFX;;;BU)")
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, combined, readExecute)
+		})
+
+		it("returns an error for unsupported SDDL", func() {
+			_, err := layer.SecurityDescriptorFromSDDL("O:BAS:(AU;SA;FA;;;WD)")
+			h.AssertError(t, err, "SACLs are not supported")
+
+			_, err = layer.SecurityDescriptorFromSDDL("D:(OA;;FA;;;BA)")
+			h.AssertError(t, err, `unsupported type "OA"`)
+
+			_, err = layer.SecurityDescriptorFromSDDL("O:XX")
+			h.AssertError(t, err, `invalid SID "XX"`)
+
+			_, err = layer.SecurityDescriptorFromSDDL("some-sddl")
+			h.AssertError(t, err, "expected O:, G:, D: or S:")
+		})
+	})
+}
@@ -0,0 +1,22 @@
+package layer
+
+import (
+	"path"
+	"strings"
+)
+
+// WhiteoutPrefix marks files deleted from lower layers, as defined by the OCI image spec
+const WhiteoutPrefix = ".wh."
+
+// OpaqueWhiteout marks directories whose contents in lower layers are hidden, as defined by the OCI image spec
+const OpaqueWhiteout = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+
+// WhiteoutPath returns the path of the whiteout entry deleting filePath.
+func WhiteoutPath(filePath string) string {
+	dir, base := path.Split(filePath)
+	return path.Join(dir, WhiteoutPrefix+base)
+}
+
+func isWhiteout(filePath string) bool {
+	return strings.HasPrefix(path.Base(filePath), WhiteoutPrefix)
+}
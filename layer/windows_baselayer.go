@@ -6,16 +6,13 @@ import (
 	"io"
 )
 
-// Generate using `make generate`
-//go:generate docker run --rm -v $PWD:/out/ bcdhive-generator -file=/out/layer/bcdhive_generated.go -package=layer -func=BaseLayerBCD
-
 // Windows base layers must follow this pattern:
-//  \-> UtilityVM/Files/EFI/Microsoft/Boot/BCD   (file must exist and a valid BCD format - from bcdhive_gen)
-//  \-> Files/Windows/System32/config/DEFAULT   (file and must exist but can be empty)
-//  \-> Files/Windows/System32/config/SAM       (file must exist but can be empty)
-//  \-> Files/Windows/System32/config/SECURITY  (file must exist but can be empty)
-//  \-> Files/Windows/System32/config/SOFTWARE  (file must exist but can be empty)
-//  \-> Files/Windows/System32/config/SYSTEM    (file must exist but can be empty)
+//  \-> UtilityVM/Files/EFI/Microsoft/Boot/BCD   (file must exist and a valid BCD format - from BCDHive)
+//  \-> Files/Windows/System32/config/DEFAULT   (file must exist - an empty hive)
+//  \-> Files/Windows/System32/config/SAM       (file must exist - an empty hive)
+//  \-> Files/Windows/System32/config/SECURITY  (file must exist - an empty hive)
+//  \-> Files/Windows/System32/config/SOFTWARE  (file must exist - an empty hive)
+//  \-> Files/Windows/System32/config/SYSTEM    (file must exist - an empty hive)
 // Refs:
 // https://github.com/microsoft/hcsshim/blob/master/internal/wclayer/legacy.go
 // https://github.com/moby/moby/blob/master/daemon/graphdriver/windows/windows.go#L48
@@ -64,20 +61,17 @@ func WindowsBaseLayer() (io.Reader, error) {
 		return nil, err
 	}
 
-	if err := tw.WriteHeader(&tar.Header{Name: "Files/Windows/System32/config/DEFAULT", Size: 0, Mode: 0644}); err != nil {
-		return nil, err
-	}
-	if err := tw.WriteHeader(&tar.Header{Name: "Files/Windows/System32/config/SAM", Size: 0, Mode: 0644}); err != nil {
-		return nil, err
-	}
-	if err := tw.WriteHeader(&tar.Header{Name: "Files/Windows/System32/config/SECURITY", Size: 0, Mode: 0644}); err != nil {
-		return nil, err
-	}
-	if err := tw.WriteHeader(&tar.Header{Name: "Files/Windows/System32/config/SOFTWARE", Size: 0, Mode: 0644}); err != nil {
+	emptyHiveBytes, err := NewHive().Bytes()
+	if err != nil {
 		return nil, err
 	}
-	if err := tw.WriteHeader(&tar.Header{Name: "Files/Windows/System32/config/SYSTEM", Size: 0, Mode: 0644}); err != nil {
-		return nil, err
+	for _, name := range []string{"DEFAULT", "SAM", "SECURITY", "SOFTWARE", "SYSTEM"} {
+		if err := tw.WriteHeader(&tar.Header{Name: "Files/Windows/System32/config/" + name, Size: int64(len(emptyHiveBytes)), Mode: 0644}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(emptyHiveBytes); err != nil {
+			return nil, err
+		}
 	}
 
 	return layerBuffer, nil
@@ -2,22 +2,61 @@ package layer
 
 import (
 	"archive/tar"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"path"
+	"strconv"
 	"strings"
 )
 
+// PAX records read by the Windows daemon when importing layers
+const (
+	WindowsFileAttributesPAXRecord     = "MSWINDOWS.fileattr"
+	WindowsSecurityDescriptorPAXRecord = "MSWINDOWS.rawsd"
+)
+
+// Windows file attributes, as set in the WindowsFileAttributesPAXRecord record
+const (
+	FileAttributeDirectory    = 0x10
+	FileAttributeReparsePoint = 0x400
+)
+
 type WindowsWriter struct {
-	tarWriter          *tar.Writer
-	writtenParentPaths map[string]bool
+	tarWriter                 *tar.Writer
+	writtenParentPaths        map[string]bool
+	defaultSecurityDescriptor string
+	securityDescriptors       map[string]string
+}
+
+type WindowsWriterOption func(*WindowsWriter)
+
+// WithDefaultSecurityDescriptor sets the binary security descriptor of entries without a security descriptor record,
+// instead of choosing one from their uid and gid. See SecurityDescriptorFromSDDL.
+func WithDefaultSecurityDescriptor(sd []byte) WindowsWriterOption {
+	return func(w *WindowsWriter) {
+		w.defaultSecurityDescriptor = base64.StdEncoding.EncodeToString(sd)
+	}
+}
+
+// WithSecurityDescriptor sets the binary security descriptor of the entry at the absolute, posix path filePath,
+// including when it is written as a parent directory. See SecurityDescriptorFromSDDL.
+func WithSecurityDescriptor(filePath string, sd []byte) WindowsWriterOption {
+	return func(w *WindowsWriter) {
+		w.securityDescriptors[layerFilesPath(filePath)] = base64.StdEncoding.EncodeToString(sd)
+	}
 }
 
-func NewWindowsWriter(fileWriter io.Writer) *WindowsWriter {
-	return &WindowsWriter{
-		tarWriter:          tar.NewWriter(fileWriter),
-		writtenParentPaths: map[string]bool{},
+func NewWindowsWriter(fileWriter io.Writer, ops ...WindowsWriterOption) *WindowsWriter {
+	w := &WindowsWriter{
+		tarWriter:           tar.NewWriter(fileWriter),
+		writtenParentPaths:  map[string]bool{},
+		securityDescriptors: map[string]string{},
+	}
+	for _, op := range ops {
+		op(w)
 	}
+	return w
 }
 
 func (w *WindowsWriter) Write(content []byte) (int, error) {
@@ -32,6 +71,13 @@ func (w *WindowsWriter) WriteHeader(header *tar.Header) error {
 	if err := w.validateHeader(header); err != nil {
 		return err
 	}
+	// the entry is a copy, so that the header of the caller is left unchanged
+	entry := *header
+	entry.PAXRecords = map[string]string{}
+	for k, v := range header.PAXRecords {
+		entry.PAXRecords[k] = v
+	}
+	header = &entry
 	header.Name = layerFilesPath(header.Name)
 
 	err := w.writeParentPaths(header.Name)
@@ -39,24 +85,97 @@ func (w *WindowsWriter) WriteHeader(header *tar.Header) error {
 		return err
 	}
 
-	header.Format = tar.FormatPAX
-	if header.PAXRecords == nil {
-		header.PAXRecords = map[string]string{}
+	if isWhiteout(header.Name) {
+		return w.writeWhiteoutHeader(header)
 	}
-	ensureSecurityDescriptor(header)
 
-	if header.Typeflag == tar.TypeDir {
+	header.Format = tar.FormatPAX
+	w.ensureSecurityDescriptor(header)
+
+	switch header.Typeflag {
+	case tar.TypeDir:
 		return w.writeDirHeader(header)
+	case tar.TypeSymlink:
+		if err := addFileAttributes(header, FileAttributeReparsePoint); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		// the daemon resolves hardlinks relative to the root of the layer
+		header.Linkname = layerLinkPath(header.Linkname)
 	}
 	return w.tarWriter.WriteHeader(header)
 }
 
-func ensureSecurityDescriptor(header *tar.Header) {
-	if _, ok := header.PAXRecords["MSWINDOWS.rawsd"]; !ok {
-		if header.Uid == 0 && header.Gid == 0 {
-			header.PAXRecords["MSWINDOWS.rawsd"] = AdministratratorOwnerAndGroupSID
+// WriteHive adds a registry hive named name, e.g. "Software_Delta", to the Hives directory of the layer.
+func (w *WindowsWriter) WriteHive(name string, contents []byte) error {
+	if err := w.initializeLayer(); err != nil {
+		return err
+	}
+
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid hive name: must be a file name: %s", name)
+	}
+
+	header := &tar.Header{
+		Name:       path.Join("Hives", name),
+		Typeflag:   tar.TypeReg,
+		Mode:       0644,
+		Size:       int64(len(contents)),
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{},
+	}
+	w.ensureSecurityDescriptor(header)
+	if err := w.tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := w.tarWriter.Write(contents)
+	return err
+}
+
+// WriteWhiteout marks the file or directory at the absolute, posix path filePath as deleted from lower layers.
+func (w *WindowsWriter) WriteWhiteout(filePath string) error {
+	return w.WriteHeader(&tar.Header{
+		Name:     WhiteoutPath(filePath),
+		Typeflag: tar.TypeReg,
+	})
+}
+
+func (w *WindowsWriter) writeWhiteoutHeader(header *tar.Header) error {
+	if path.Base(header.Name) == OpaqueWhiteout {
+		return fmt.Errorf("invalid header name: opaque whiteouts are not supported in Windows layers: %s", header.Name)
+	}
+
+	// whiteouts are empty files without any Windows metadata
+	return w.tarWriter.WriteHeader(&tar.Header{
+		Name:     header.Name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		ModTime:  header.ModTime,
+	})
+}
+
+func addFileAttributes(header *tar.Header, attributes uint64) error {
+	if existing, ok := header.PAXRecords[WindowsFileAttributesPAXRecord]; ok {
+		value, err := strconv.ParseUint(existing, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %s record: %s", WindowsFileAttributesPAXRecord, existing)
+		}
+		attributes |= value
+	}
+	header.PAXRecords[WindowsFileAttributesPAXRecord] = strconv.FormatUint(attributes, 10)
+	return nil
+}
+
+func (w *WindowsWriter) ensureSecurityDescriptor(header *tar.Header) {
+	if _, ok := header.PAXRecords[WindowsSecurityDescriptorPAXRecord]; !ok {
+		if sd, ok := w.securityDescriptors[header.Name]; ok {
+			header.PAXRecords[WindowsSecurityDescriptorPAXRecord] = sd
+		} else if w.defaultSecurityDescriptor != "" {
+			header.PAXRecords[WindowsSecurityDescriptorPAXRecord] = w.defaultSecurityDescriptor
+		} else if header.Uid == 0 && header.Gid == 0 {
+			header.PAXRecords[WindowsSecurityDescriptorPAXRecord] = AdministratratorOwnerAndGroupSID
 		} else {
-			header.PAXRecords["MSWINDOWS.rawsd"] = UserOwnerAndGroupSID
+			header.PAXRecords[WindowsSecurityDescriptorPAXRecord] = UserOwnerAndGroupSID
 		}
 	}
 }
@@ -80,10 +199,15 @@ func (w *WindowsWriter) writeParentPaths(childPath string) error {
 	for _, pathPart := range strings.Split(path.Dir(childPath), "/") {
 		parentDir = path.Join(parentDir, pathPart)
 
-		if err := w.writeDirHeader(&tar.Header{
+		header := &tar.Header{
 			Name:     parentDir,
 			Typeflag: tar.TypeDir,
-		}); err != nil {
+		}
+		if sd, ok := w.securityDescriptors[parentDir]; ok {
+			header.Format = tar.FormatPAX
+			header.PAXRecords = map[string]string{WindowsSecurityDescriptorPAXRecord: sd}
+		}
+		if err := w.writeDirHeader(header); err != nil {
 			return err
 		}
 	}
@@ -94,6 +218,16 @@ func layerFilesPath(origPath string) string {
 	return path.Join("Files", origPath)
 }
 
+// layerLinkPath returns the path of a hardlink target relative to the root of the layer. Targets relative to the
+// filesystem are moved to the Files directory, and targets already in the Files directory are kept.
+func layerLinkPath(linkname string) string {
+	clean := path.Clean(linkname)
+	if clean == "Files" || strings.HasPrefix(clean, "Files/") {
+		return clean
+	}
+	return layerFilesPath(clean)
+}
+
 func (w *WindowsWriter) initializeLayer() error {
 	if err := w.writeDirHeader(&tar.Header{
 		Name:     "Files",
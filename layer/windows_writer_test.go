@@ -3,6 +3,7 @@ package layer_test
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/base64"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -239,6 +240,226 @@ func testWindowsWriter(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("security descriptor options", func() {
+		it("uses the default security descriptor for entries without one", func() {
+			sd, err := layer.SecurityDescriptorFromSDDL("O:S-1-5-93-2-2G:S-1-5-93-2-2D:P(A;OICI;FA;;;S-1-5-93-2-2)")
+			h.AssertNil(t, err)
+
+			buf := &bytes.Buffer{}
+			lw := layer.NewWindowsWriter(buf, layer.WithDefaultSecurityDescriptor(sd))
+
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{Name: "/my-file", Typeflag: tar.TypeReg}))
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{
+				Name:       "/other-file",
+				Typeflag:   tar.TypeReg,
+				PAXRecords: map[string]string{"MSWINDOWS.rawsd": "bar"},
+			}))
+			h.AssertNil(t, lw.Close())
+
+			tr := tar.NewReader(buf)
+			tr.Next() // Files
+			tr.Next() // Hives
+
+			th, err := tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, th.PAXRecords["MSWINDOWS.rawsd"], base64.StdEncoding.EncodeToString(sd))
+
+			th, err = tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, th.PAXRecords["MSWINDOWS.rawsd"], "bar")
+		})
+
+		it("uses the security descriptor of the path, including for parent directories", func() {
+			dirSD, err := layer.SecurityDescriptorFromSDDL("O:BUG:BUD:(A;OICI;FA;;;BU)")
+			h.AssertNil(t, err)
+			fileSD, err := layer.SecurityDescriptorFromSDDL("O:BAG:BAD:(A;;FR;;;BU)")
+			h.AssertNil(t, err)
+
+			buf := &bytes.Buffer{}
+			lw := layer.NewWindowsWriter(buf,
+				layer.WithSecurityDescriptor("/cnb", dirSD),
+				layer.WithSecurityDescriptor("/cnb/my-file", fileSD),
+			)
+
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{Name: "/cnb/my-file", Typeflag: tar.TypeReg}))
+			h.AssertNil(t, lw.Close())
+
+			tr := tar.NewReader(buf)
+			tr.Next() // Files
+			tr.Next() // Hives
+
+			th, err := tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, th.Name, "Files/cnb")
+			h.AssertEq(t, th.PAXRecords, map[string]string{"MSWINDOWS.rawsd": base64.StdEncoding.EncodeToString(dirSD)})
+
+			th, err = tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, th.Name, "Files/cnb/my-file")
+			h.AssertEq(t, th.PAXRecords, map[string]string{"MSWINDOWS.rawsd": base64.StdEncoding.EncodeToString(fileSD)})
+		})
+	})
+
+	when("links", func() {
+		it("marks symlinks as reparse points", func() {
+			buf := &bytes.Buffer{}
+			lw := layer.NewWindowsWriter(buf)
+
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{
+				Name:     "/cnb/file-link",
+				Typeflag: tar.TypeSymlink,
+				Linkname: "../some-file",
+			}))
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{
+				Name:       "/cnb/dir-link",
+				Typeflag:   tar.TypeSymlink,
+				Linkname:   "/some-dir",
+				PAXRecords: map[string]string{layer.WindowsFileAttributesPAXRecord: "16"},
+			}))
+			h.AssertNil(t, lw.Close())
+
+			tr := tar.NewReader(buf)
+			tr.Next() // Files
+			tr.Next() // Hives
+			tr.Next() // Files/cnb
+
+			th, err := tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, th.Name, "Files/cnb/file-link")
+			h.AssertEq(t, th.Linkname, "../some-file")
+			h.AssertEq(t, th.PAXRecords[layer.WindowsFileAttributesPAXRecord], "1024")
+			h.AssertEq(t, th.PAXRecords[layer.WindowsSecurityDescriptorPAXRecord], layer.AdministratratorOwnerAndGroupSID)
+
+			th, err = tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, th.Name, "Files/cnb/dir-link")
+			h.AssertEq(t, th.Linkname, "/some-dir")
+			h.AssertEq(t, th.PAXRecords[layer.WindowsFileAttributesPAXRecord], "1040")
+		})
+
+		it("makes hardlink targets relative to the layer", func() {
+			buf := &bytes.Buffer{}
+			lw := layer.NewWindowsWriter(buf)
+
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{
+				Name:     "/cnb/hardlink",
+				Typeflag: tar.TypeLink,
+				Linkname: "/cnb/some-file",
+			}))
+			h.AssertNil(t, lw.Close())
+
+			tr := tar.NewReader(buf)
+			tr.Next() // Files
+			tr.Next() // Hives
+			tr.Next() // Files/cnb
+
+			th, err := tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, th.Name, "Files/cnb/hardlink")
+			h.AssertEq(t, th.Typeflag, byte(tar.TypeLink))
+			h.AssertEq(t, th.Linkname, "Files/cnb/some-file")
+		})
+
+		it("accepts relative hardlink targets", func() {
+			buf := &bytes.Buffer{}
+			lw := layer.NewWindowsWriter(buf)
+
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{
+				Name:     "/cnb/hardlink",
+				Typeflag: tar.TypeLink,
+				Linkname: "cnb/some-file",
+			}))
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{
+				Name:     "/cnb/other-hardlink",
+				Typeflag: tar.TypeLink,
+				Linkname: "Files/cnb/some-file",
+			}))
+			h.AssertNil(t, lw.Close())
+
+			tr := tar.NewReader(buf)
+			tr.Next() // Files
+			tr.Next() // Hives
+			tr.Next() // Files/cnb
+
+			for _, name := range []string{"Files/cnb/hardlink", "Files/cnb/other-hardlink"} {
+				th, err := tr.Next()
+				h.AssertNil(t, err)
+				h.AssertEq(t, th.Name, name)
+				h.AssertEq(t, th.Linkname, "Files/cnb/some-file")
+			}
+		})
+	})
+
+	when("whiteouts", func() {
+		it("writes empty whiteout entries without Windows metadata", func() {
+			buf := &bytes.Buffer{}
+			lw := layer.NewWindowsWriter(buf)
+
+			h.AssertNil(t, lw.WriteWhiteout("/cnb/deleted-file"))
+			h.AssertNil(t, lw.WriteHeader(&tar.Header{
+				Name:     "/cnb/.wh.other-deleted-file",
+				Typeflag: tar.TypeReg,
+				Uid:      1000,
+			}))
+			h.AssertNil(t, lw.Close())
+
+			tr := tar.NewReader(buf)
+			tr.Next() // Files
+			tr.Next() // Hives
+			tr.Next() // Files/cnb
+
+			th, err := tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, th.Name, "Files/cnb/.wh.deleted-file")
+			h.AssertEq(t, th.Typeflag, byte(tar.TypeReg))
+			h.AssertEq(t, th.Size, int64(0))
+			h.AssertEq(t, th.PAXRecords, map[string]string(nil))
+
+			th, err = tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, th.Name, "Files/cnb/.wh.other-deleted-file")
+			h.AssertEq(t, th.PAXRecords, map[string]string(nil))
+		})
+
+		it("rejects opaque whiteouts", func() {
+			lw := layer.NewWindowsWriter(&bytes.Buffer{})
+			h.AssertError(t, lw.WriteHeader(&tar.Header{
+				Name:     "/cnb/" + layer.OpaqueWhiteout,
+				Typeflag: tar.TypeReg,
+			}), "opaque whiteouts are not supported in Windows layers")
+		})
+	})
+
+	when("#WriteHive", func() {
+		it("writes the hive to the Hives directory", func() {
+			buf := &bytes.Buffer{}
+			lw := layer.NewWindowsWriter(buf)
+
+			h.AssertNil(t, lw.WriteHive("Software_Delta", []byte("some-hive")))
+			h.AssertNil(t, lw.Close())
+
+			tr := tar.NewReader(buf)
+			tr.Next() // Files
+			tr.Next() // Hives
+
+			th, err := tr.Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, th.Name, "Hives/Software_Delta")
+			h.AssertEq(t, th.Typeflag, byte(tar.TypeReg))
+			h.AssertEq(t, th.PAXRecords, map[string]string{"MSWINDOWS.rawsd": layer.AdministratratorOwnerAndGroupSID})
+
+			contents, err := ioutil.ReadAll(tr)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "some-hive")
+		})
+
+		it("rejects names that are not file names", func() {
+			lw := layer.NewWindowsWriter(&bytes.Buffer{})
+			h.AssertError(t, lw.WriteHive("some/hive", nil), "invalid hive name: must be a file name: some/hive")
+			h.AssertError(t, lw.WriteHive("", nil), "invalid hive name")
+		})
+	})
+
 	when("#Close", func() {
 		it("writes required parent dirs on empty layer", func() {
 			var err error
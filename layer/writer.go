@@ -0,0 +1,24 @@
+package layer
+
+import (
+	"archive/tar"
+	"io"
+)
+
+// Writer writes the entries of a layer tar. Entry names are absolute, posix paths of the image filesystem, and
+// missing parent directories are added.
+type Writer interface {
+	WriteHeader(header *tar.Header) error
+	Write(content []byte) (int, error)
+	// WriteWhiteout marks the file or directory at the absolute, posix path filePath as deleted from lower layers.
+	WriteWhiteout(filePath string) error
+	Close() error
+}
+
+// NewWriter returns a Writer of layers for images with the os, e.g. the value of Image.OS().
+func NewWriter(fileWriter io.Writer, os string) Writer {
+	if os == "windows" {
+		return NewWindowsWriter(fileWriter)
+	}
+	return NewLinuxWriter(fileWriter)
+}
@@ -0,0 +1,349 @@
+package imgutil
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil/layer"
+)
+
+const maxLinkDepth = 40
+
+// LayerFile is a file, directory or link in the final filesystem of an image.
+type LayerFile struct {
+	// Path is the absolute path of the file in the image.
+	Path string
+	// Layer is the diff id of the topmost layer providing the file.
+	Layer string
+	// Header is the header of the file in the layer. Directories that are only implied by the paths of their
+	// contents have a header without a name.
+	Header tar.Header
+}
+
+// FileIndex is the merged view of the filesystems of the layers of an image, after applying whiteouts.
+type FileIndex struct {
+	img    Image
+	os     string
+	layers []string
+	files  map[string]LayerFile
+	// entries holds the position of the entry of each file in its layer, as a layer can have several entries with the
+	// same path of which the last one is kept
+	entries map[string]int
+}
+
+type IndexOption func(*indexOptions)
+
+type indexOptions struct {
+	headerCache *LayerHeaderCache
+}
+
+// WithLayerHeaderCache reads the headers of layers through cache, so that the layers shared by the images indexed with
+// the same cache are only read once.
+func WithLayerHeaderCache(cache *LayerHeaderCache) IndexOption {
+	return func(opts *indexOptions) {
+		opts.headerCache = cache
+	}
+}
+
+// LayerIndex reads the layers of img, which must implement InspectableImage, through GetLayer and indexes its final
+// filesystem. The entries of Windows layers are indexed without their "Files/" prefix.
+func LayerIndex(img Image, ops ...IndexOption) (*FileIndex, error) {
+	opts := &indexOptions{}
+	for _, op := range ops {
+		op(opts)
+	}
+
+	inspectableImage, err := asInspectableImage(img)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := inspectableImage.Layers()
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing layers of image '%s'", img.Name())
+	}
+	os, err := img.OS()
+	if err != nil {
+		return nil, err
+	}
+
+	index := &FileIndex{img: img, os: os, layers: layers, files: map[string]LayerFile{}, entries: map[string]int{}}
+	for _, diffID := range layers {
+		var headers []tar.Header
+		if opts.headerCache != nil {
+			headers, err = opts.headerCache.get(img, diffID)
+		} else {
+			headers, err = readLayerHeaders(img, diffID)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading layer '%s' of image '%s'", diffID, img.Name())
+		}
+		index.apply(diffID, headers)
+	}
+	return index, nil
+}
+
+// Find returns the file at filePath and whether it exists in the image.
+func (x *FileIndex) Find(filePath string) (LayerFile, bool) {
+	file, ok := x.files[path.Clean("/"+filePath)]
+	return file, ok
+}
+
+// Files returns all files of the image, sorted by path.
+func (x *FileIndex) Files() []LayerFile {
+	var files []LayerFile
+	for _, file := range x.files {
+		files = append(files, file)
+	}
+	sortLayerFiles(files)
+	return files
+}
+
+// List returns the files in the directory at dirPath, sorted by path.
+func (x *FileIndex) List(dirPath string) ([]LayerFile, error) {
+	dirPath = path.Clean("/" + dirPath)
+	if dirPath != "/" {
+		dir, ok := x.files[dirPath]
+		if !ok {
+			return nil, fmt.Errorf("'%s' not found in image '%s'", dirPath, x.img.Name())
+		}
+		if dir.Header.Typeflag != tar.TypeDir {
+			return nil, fmt.Errorf("'%s' is not a directory in image '%s'", dirPath, x.img.Name())
+		}
+	}
+
+	var files []LayerFile
+	for p, file := range x.files {
+		if path.Dir(p) == dirPath && p != dirPath {
+			files = append(files, file)
+		}
+	}
+	sortLayerFiles(files)
+	return files, nil
+}
+
+// ReadFile returns the contents of the file at filePath, following symlinks and hardlinks.
+func (x *FileIndex) ReadFile(filePath string) ([]byte, error) {
+	file, err := x.resolve(path.Clean("/" + filePath))
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := x.img.GetLayer(file.Layer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting layer '%s' of image '%s'", file.Layer, x.img.Name())
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for entry := 0; ; entry++ {
+		_, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("'%s' not found in layer '%s' of image '%s'", file.Path, file.Layer, x.img.Name())
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading layer '%s' of image '%s'", file.Layer, x.img.Name())
+		}
+		if entry == x.entries[file.Path] {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+// resolve returns the regular file at filePath, following links in filePath and in its parent directories one path
+// component at a time.
+func (x *FileIndex) resolve(filePath string) (LayerFile, error) {
+	resolved := "/"
+	remaining := strings.Split(filePath, "/")
+	links := 0
+	for len(remaining) > 0 {
+		part := remaining[0]
+		remaining = remaining[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		p := path.Join(resolved, part)
+		file, ok := x.files[p]
+		if !ok {
+			return LayerFile{}, fmt.Errorf("'%s' not found in image '%s'", p, x.img.Name())
+		}
+
+		var target string
+		switch file.Header.Typeflag {
+		case tar.TypeSymlink:
+			target = file.Header.Linkname
+			if path.IsAbs(target) {
+				resolved = "/"
+			}
+		case tar.TypeLink:
+			linkPath, ok := layerEntryPath(x.os, file.Header.Linkname)
+			if !ok {
+				return LayerFile{}, fmt.Errorf("invalid hardlink '%s' to '%s' in image '%s'", p, file.Header.Linkname, x.img.Name())
+			}
+			target = linkPath
+			resolved = "/"
+		default:
+			if len(remaining) > 0 && file.Header.Typeflag != tar.TypeDir {
+				return LayerFile{}, fmt.Errorf("'%s' is not a directory in image '%s'", p, x.img.Name())
+			}
+			resolved = p
+			continue
+		}
+
+		links++
+		if links > maxLinkDepth {
+			return LayerFile{}, fmt.Errorf("too many levels of links resolving '%s' in image '%s'", filePath, x.img.Name())
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+
+	file, ok := x.files[resolved]
+	if !ok || (file.Header.Typeflag != tar.TypeReg && file.Header.Typeflag != tar.TypeRegA) {
+		return LayerFile{}, fmt.Errorf("'%s' is not a regular file in image '%s'", resolved, x.img.Name())
+	}
+	return file, nil
+}
+
+// apply adds the entries of a layer to the index, after removing the lower files hidden by its whiteouts.
+func (x *FileIndex) apply(diffID string, headers []tar.Header) {
+	var added []LayerFile
+	addedEntries := map[string]int{}
+	for entry, header := range headers {
+		p, ok := layerEntryPath(x.os, header.Name)
+		if !ok {
+			continue
+		}
+		dir, base := path.Split(p)
+		switch {
+		case base == layer.OpaqueWhiteout:
+			x.removeChildren(path.Clean(dir))
+		case strings.HasPrefix(base, layer.WhiteoutPrefix):
+			deleted := path.Join(dir, strings.TrimPrefix(base, layer.WhiteoutPrefix))
+			delete(x.files, deleted)
+			x.removeChildren(deleted)
+		default:
+			added = append(added, LayerFile{Path: p, Layer: diffID, Header: header})
+			addedEntries[p] = entry
+		}
+	}
+
+	for _, file := range added {
+		if file.Header.Typeflag != tar.TypeDir {
+			// a file replacing a directory hides the contents of the directory
+			x.removeChildren(file.Path)
+		}
+		x.addParents(file.Path, diffID)
+		x.files[file.Path] = file
+		x.entries[file.Path] = addedEntries[file.Path]
+	}
+}
+
+func (x *FileIndex) removeChildren(dirPath string) {
+	prefix := dirPath + "/"
+	if dirPath == "/" {
+		prefix = "/"
+	}
+	for p := range x.files {
+		if strings.HasPrefix(p, prefix) {
+			delete(x.files, p)
+		}
+	}
+}
+
+// addParents adds the missing parent directories of filePath.
+func (x *FileIndex) addParents(filePath, diffID string) {
+	for dir := path.Dir(filePath); dir != "/"; dir = path.Dir(dir) {
+		if parent, ok := x.files[dir]; ok && parent.Header.Typeflag == tar.TypeDir {
+			return
+		}
+		x.files[dir] = LayerFile{Path: dir, Layer: diffID, Header: tar.Header{Typeflag: tar.TypeDir, Mode: 0755}}
+		x.entries[dir] = -1
+	}
+}
+
+// layerEntryPath returns the absolute path in the image filesystem of the layer entry name, or false for entries that
+// are not part of the filesystem, e.g. the Hives of Windows layers.
+func layerEntryPath(os, name string) (string, bool) {
+	if os == "windows" {
+		name = strings.ReplaceAll(name, `\`, "/")
+		p := path.Clean("/" + name)
+		if p == "/Files" || !strings.HasPrefix(p, "/Files/") {
+			return "", false
+		}
+		return strings.TrimPrefix(p, "/Files"), true
+	}
+
+	p := path.Clean("/" + name)
+	if p == "/" {
+		return "", false
+	}
+	return p, true
+}
+
+func sortLayerFiles(files []LayerFile) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+}
+
+// LayerHeaderCache holds the headers of layers by diff id. It is safe for concurrent use and is never pruned, so it
+// should live no longer than the images it is used for.
+type LayerHeaderCache struct {
+	mu      sync.Mutex
+	headers map[string][]tar.Header
+}
+
+func NewLayerHeaderCache() *LayerHeaderCache {
+	return &LayerHeaderCache{headers: map[string][]tar.Header{}}
+}
+
+func (c *LayerHeaderCache) get(img Image, diffID string) ([]tar.Header, error) {
+	c.mu.Lock()
+	headers, ok := c.headers[diffID]
+	c.mu.Unlock()
+	if ok {
+		return headers, nil
+	}
+
+	headers, err := readLayerHeaders(img, diffID)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.headers[diffID] = headers
+	c.mu.Unlock()
+	return headers, nil
+}
+
+func readLayerHeaders(img Image, diffID string) ([]tar.Header, error) {
+	rc, err := img.GetLayer(diffID)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var headers []tar.Header
+	tr := tar.NewReader(rc)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return headers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, *header)
+	}
+}
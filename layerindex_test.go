@@ -0,0 +1,300 @@
+package imgutil_test
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/fakes"
+	"github.com/buildpacks/imgutil/layer"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestLayerIndex(t *testing.T) {
+	spec.Run(t, "LayerIndex", testLayerIndex, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+type layerEntry struct {
+	header   *tar.Header
+	contents string
+}
+
+func file(name, contents string) layerEntry {
+	return layerEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents))}, contents: contents}
+}
+
+func symlink(name, target string) layerEntry {
+	return layerEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}}
+}
+
+func whiteout(name string) layerEntry {
+	return layerEntry{header: &tar.Header{Name: layer.WhiteoutPath(name), Typeflag: tar.TypeReg}}
+}
+
+// writeLayer writes entries to a new layer in dir with the layer writer for osType and returns the path of the layer.
+func writeLayer(t *testing.T, dir, osType string, entries ...layerEntry) string {
+	t.Helper()
+	f, err := ioutil.TempFile(dir, "layer")
+	h.AssertNil(t, err)
+	defer f.Close()
+
+	w := layer.NewWriter(f, osType)
+	for _, entry := range entries {
+		h.AssertNil(t, w.WriteHeader(entry.header))
+		_, err := w.Write([]byte(entry.contents))
+		h.AssertNil(t, err)
+	}
+	h.AssertNil(t, w.Close())
+	return f.Name()
+}
+
+// addLayer writes entries to a new layer in dir for the OS of img, adds it to img and returns its diff id.
+func addLayer(t *testing.T, img *fakes.Image, dir string, entries ...layerEntry) string {
+	t.Helper()
+	osType, err := img.OS()
+	h.AssertNil(t, err)
+
+	layerPath := writeLayer(t, dir, osType, entries...)
+	diffID := h.FileDiffID(t, layerPath)
+	h.AssertNil(t, img.AddLayerWithDiffID(layerPath, diffID))
+	return diffID
+}
+
+// regularFiles returns the diff id of the layer providing each regular file in the final filesystem of img.
+func regularFiles(t *testing.T, img imgutil.Image) map[string]string {
+	t.Helper()
+	index, err := imgutil.LayerIndex(img)
+	h.AssertNil(t, err)
+
+	files := map[string]string{}
+	for _, f := range index.Files() {
+		if f.Header.Typeflag == tar.TypeReg {
+			files[f.Path] = f.Layer
+		}
+	}
+	return files
+}
+
+// countingImage counts the layers read through GetLayer.
+type countingImage struct {
+	*fakes.Image
+	reads map[string]int
+}
+
+func (i *countingImage) GetLayer(diffID string) (io.ReadCloser, error) {
+	i.reads[diffID]++
+	return i.Image.GetLayer(diffID)
+}
+
+func testLayerIndex(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir string
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "layer-index")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.RemoveAll(tmpDir))
+	})
+
+	paths := func(files []imgutil.LayerFile) []string {
+		var result []string
+		for _, f := range files {
+			result = append(result, f.Path)
+		}
+		return result
+	}
+
+	when("#LayerIndex", func() {
+		var img *fakes.Image
+
+		it.Before(func() {
+			img = fakes.NewImage("some-image", "", nil, fakes.WithOS("linux"))
+		})
+
+		it("finds the topmost layer providing a path", func() {
+			bottom := addLayer(t, img, tmpDir, file("/usr/lib/libssl.so", "old"), file("/etc/os-release", "some-os"))
+			top := addLayer(t, img, tmpDir, file("/usr/lib/libssl.so", "new"))
+
+			index, err := imgutil.LayerIndex(img)
+			h.AssertNil(t, err)
+
+			libssl, ok := index.Find("/usr/lib/libssl.so")
+			h.AssertEq(t, ok, true)
+			h.AssertEq(t, libssl.Layer, top)
+			osRelease, ok := index.Find("etc/os-release")
+			h.AssertEq(t, ok, true)
+			h.AssertEq(t, osRelease.Layer, bottom)
+			_, ok = index.Find("/some-missing-file")
+			h.AssertEq(t, ok, false)
+
+			contents, err := index.ReadFile("/usr/lib/libssl.so")
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "new")
+		})
+
+		it("applies whiteouts", func() {
+			addLayer(t, img, tmpDir,
+				file("/some-dir/deleted-file", "deleted"),
+				file("/some-dir/kept-file", "kept"),
+				file("/deleted-dir/some-file", "deleted"),
+				file("/opaque-dir/some-file", "hidden"),
+			)
+			addLayer(t, img, tmpDir,
+				whiteout("/some-dir/deleted-file"),
+				whiteout("/deleted-dir"),
+				layerEntry{header: &tar.Header{Name: "/opaque-dir/" + layer.OpaqueWhiteout, Typeflag: tar.TypeReg}},
+				file("/opaque-dir/new-file", "new"),
+			)
+
+			index, err := imgutil.LayerIndex(img)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, paths(index.Files()), []string{
+				"/opaque-dir",
+				"/opaque-dir/new-file",
+				"/some-dir",
+				"/some-dir/kept-file",
+			})
+		})
+
+		it("hides the contents of directories replaced by files", func() {
+			addLayer(t, img, tmpDir, file("/some-path/some-file", "some-contents"))
+			addLayer(t, img, tmpDir, file("/some-path", "replaced"))
+
+			index, err := imgutil.LayerIndex(img)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, paths(index.Files()), []string{"/some-path"})
+			_, err = index.List("/some-path")
+			h.AssertError(t, err, "'/some-path' is not a directory in image 'some-image'")
+		})
+
+		it("lists directories", func() {
+			addLayer(t, img, tmpDir, file("/some-dir/b-file", "b"), file("/some-dir/sub-dir/c-file", "c"))
+			addLayer(t, img, tmpDir, file("/some-dir/a-file", "a"))
+
+			index, err := imgutil.LayerIndex(img)
+			h.AssertNil(t, err)
+
+			files, err := index.List("/some-dir")
+			h.AssertNil(t, err)
+			h.AssertEq(t, paths(files), []string{"/some-dir/a-file", "/some-dir/b-file", "/some-dir/sub-dir"})
+
+			files, err = index.List("/")
+			h.AssertNil(t, err)
+			h.AssertEq(t, paths(files), []string{"/some-dir"})
+
+			_, err = index.List("/missing-dir")
+			h.AssertError(t, err, "'/missing-dir' not found in image 'some-image'")
+		})
+
+		it("reads files through links", func() {
+			addLayer(t, img, tmpDir, file("/usr/lib/libssl.so.1.1", "libssl"))
+			addLayer(t, img, tmpDir,
+				symlink("/usr/lib/libssl.so", "libssl.so.1.1"),
+				symlink("/lib", "/usr/lib"),
+				layerEntry{header: &tar.Header{Name: "/hardlink", Typeflag: tar.TypeLink, Linkname: "/usr/lib/libssl.so.1.1"}},
+				symlink("/loop", "/loop"),
+			)
+
+			index, err := imgutil.LayerIndex(img)
+			h.AssertNil(t, err)
+
+			contents, err := index.ReadFile("/usr/lib/libssl.so")
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "libssl")
+			_, err = index.ReadFile("/hardlink")
+			h.AssertNil(t, err)
+
+			_, err = index.ReadFile("/lib")
+			h.AssertError(t, err, "'/usr/lib' is not a regular file")
+			_, err = index.ReadFile("/loop")
+			h.AssertError(t, err, "too many levels of links")
+		})
+
+		it("follows links in parent directories", func() {
+			addLayer(t, img, tmpDir, file("/usr/lib/libssl.so.1.1", "libssl"))
+			addLayer(t, img, tmpDir,
+				symlink("/lib", "usr/lib"),
+				symlink("/usr/lib64", "/lib"),
+				symlink("/etc/ssl/lib", "../../lib64"),
+				symlink("/lib64", "usr/lib64"),
+				file("/etc/os-release", "some-os"),
+			)
+
+			index, err := imgutil.LayerIndex(img)
+			h.AssertNil(t, err)
+
+			for _, p := range []string{"/lib/libssl.so.1.1", "/usr/lib64/libssl.so.1.1", "/etc/ssl/lib/libssl.so.1.1"} {
+				contents, err := index.ReadFile(p)
+				h.AssertNil(t, err)
+				h.AssertEq(t, string(contents), "libssl")
+			}
+			_, err = index.ReadFile("/etc/os-release/some-file")
+			h.AssertError(t, err, "'/etc/os-release' is not a directory")
+		})
+
+		it("reads the last entry of a layer with the path of a file", func() {
+			addLayer(t, img, tmpDir, file("/some-file", "first"), file("/some-file", "last"))
+
+			index, err := imgutil.LayerIndex(img)
+			h.AssertNil(t, err)
+
+			contents, err := index.ReadFile("/some-file")
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "last")
+		})
+
+		when("#WithLayerHeaderCache", func() {
+			it("reads the layers shared by images once", func() {
+				diffID := addLayer(t, img, tmpDir, file("/some-file", "some-contents"))
+				counting := &countingImage{Image: img, reads: map[string]int{}}
+
+				cache := imgutil.NewLayerHeaderCache()
+				_, err := imgutil.LayerIndex(counting, imgutil.WithLayerHeaderCache(cache))
+				h.AssertNil(t, err)
+				_, err = imgutil.LayerIndex(counting, imgutil.WithLayerHeaderCache(cache))
+				h.AssertNil(t, err)
+				h.AssertEq(t, counting.reads[diffID], 1)
+
+				_, err = imgutil.LayerIndex(counting)
+				h.AssertNil(t, err)
+				h.AssertEq(t, counting.reads[diffID], 2)
+			})
+		})
+
+		when("the image is a Windows image", func() {
+			it.Before(func() {
+				img = fakes.NewImage("some-image", "", nil, fakes.WithOS("windows"))
+			})
+
+			it("indexes the entries under Files", func() {
+				addLayer(t, img, tmpDir, file("/cnb/some-file", "some-contents"))
+
+				index, err := imgutil.LayerIndex(img)
+				h.AssertNil(t, err)
+
+				h.AssertEq(t, paths(index.Files()), []string{"/cnb", "/cnb/some-file"})
+				contents, err := index.ReadFile("/cnb/some-file")
+				h.AssertNil(t, err)
+				h.AssertEq(t, string(contents), "some-contents")
+			})
+		})
+
+		when("the image does not support inspection", func() {
+			it("returns an error", func() {
+				_, err := imgutil.LayerIndex(struct{ imgutil.Image }{img})
+				h.AssertError(t, err, "does not support inspection")
+			})
+		})
+	})
+}
@@ -20,7 +20,6 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/pkg/errors"
@@ -34,7 +33,11 @@ type Image struct {
 	inspect          types.ImageInspect
 	layerPaths       []string
 	prevImage        *Image // reused layers will be fetched from prevImage
+	layerSources     []*Image
 	downloadBaseOnce *sync.Once
+	progress         ProgressHandler
+	logger           imgutil.Logger
+	metrics          imgutil.Metrics
 }
 
 type ImageOption func(*options) error
@@ -43,6 +46,10 @@ type options struct {
 	platform          imgutil.Platform
 	baseImageRepoName string
 	prevImageRepoName string
+	layerSourceNames  []string
+	progress          ProgressHandler
+	logger            imgutil.Logger
+	metrics           imgutil.Metrics
 }
 
 //WithPreviousImage loads an existing image as a source for reusable layers.
@@ -55,6 +62,16 @@ func WithPreviousImage(imageName string) ImageOption {
 	}
 }
 
+//WithLayerSources loads existing images whose layers can be reused, in addition to the previous image.
+//Use with ReuseLayer().
+//Images that are not found are ignored.
+func WithLayerSources(imageNames ...string) ImageOption {
+	return func(i *options) error {
+		i.layerSourceNames = append(i.layerSourceNames, imageNames...)
+		return nil
+	}
+}
+
 //FromBaseImage loads an existing image as the config and layers for the new image.
 //Ignored if image is not found.
 func FromBaseImage(imageName string) ImageOption {
@@ -73,9 +90,42 @@ func WithDefaultPlatform(platform imgutil.Platform) ImageOption {
 	}
 }
 
+//WithProgress reports every message from the daemon while loading and saving images to the given handler,
+//along with byte counts while layers are streamed.
+func WithProgress(handler ProgressHandler) ImageOption {
+	return func(i *options) error {
+		i.progress = handler
+		return nil
+	}
+}
+
+//WithLogger reports diagnostic events, such as base image resolution and save retries, to the given logger.
+//A nil logger discards the events.
+func WithLogger(logger imgutil.Logger) ImageOption {
+	return func(i *options) error {
+		if logger == nil {
+			logger = imgutil.NopLogger
+		}
+		i.logger = logger
+		return nil
+	}
+}
+
+//WithMetrics reports measurements, such as bytes streamed and daemon load latency, to the given metrics.
+//Nil metrics discard the measurements.
+func WithMetrics(metrics imgutil.Metrics) ImageOption {
+	return func(i *options) error {
+		if metrics == nil {
+			metrics = imgutil.NopMetrics
+		}
+		i.metrics = metrics
+		return nil
+	}
+}
+
 //NewImage returns a new Image that can be modified and saved to a registry.
 func NewImage(repoName string, dockerClient client.CommonAPIClient, ops ...ImageOption) (*Image, error) {
-	imageOpts := &options{}
+	imageOpts := &options{logger: imgutil.NopLogger, metrics: imgutil.NopMetrics}
 	for _, op := range ops {
 		if err := op(imageOpts); err != nil {
 			return nil, err
@@ -102,6 +152,9 @@ func NewImage(repoName string, dockerClient client.CommonAPIClient, ops ...Image
 		inspect:          inspect,
 		layerPaths:       make([]string, len(inspect.RootFS.Layers)),
 		downloadBaseOnce: &sync.Once{},
+		progress:         serializeProgress(imageOpts.progress),
+		logger:           imageOpts.logger,
+		metrics:          imageOpts.metrics,
 	}
 
 	if imageOpts.prevImageRepoName != "" {
@@ -110,6 +163,12 @@ func NewImage(repoName string, dockerClient client.CommonAPIClient, ops ...Image
 		}
 	}
 
+	for _, sourceName := range imageOpts.layerSourceNames {
+		if err := processLayerSourceOption(image, sourceName, dockerClient); err != nil {
+			return nil, err
+		}
+	}
+
 	if imageOpts.baseImageRepoName != "" {
 		if err := processBaseImageOption(image, imageOpts.baseImageRepoName, platform, dockerClient); err != nil {
 			return nil, err
@@ -134,32 +193,59 @@ func validatePlatformOption(defaultPlatform imgutil.Platform, optionPlatform img
 }
 
 func processPreviousImageOption(image *Image, prevImageRepoName string, platform imgutil.Platform, dockerClient client.CommonAPIClient) error {
-	if _, err := inspectOptionalImage(dockerClient, prevImageRepoName, platform); err != nil {
+	inspect, err := inspectOptionalImage(dockerClient, prevImageRepoName, platform)
+	if err != nil {
 		return err
 	}
+	image.logResolved("previous", prevImageRepoName, inspect)
 
-	prevImage, err := NewImage(prevImageRepoName, dockerClient, FromBaseImage(prevImageRepoName))
+	prevImage, err := NewImage(prevImageRepoName, dockerClient, FromBaseImage(prevImageRepoName), WithProgress(image.progress))
 	if err != nil {
 		return errors.Wrapf(err, "failed to get previous image '%s'", prevImageRepoName)
 	}
+	// the resolution of the previous image is reported above, so the logger only applies to later events
+	prevImage.logger = image.logger
 
 	image.prevImage = prevImage
 
 	return nil
 }
 
+func processLayerSourceOption(image *Image, sourceName string, dockerClient client.CommonAPIClient) error {
+	sourceImage, err := NewImage(sourceName, dockerClient, FromBaseImage(sourceName), WithProgress(image.progress))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get layer source '%s'", sourceName)
+	}
+	sourceImage.logger = image.logger
+
+	if sourceImage.Found() {
+		image.layerSources = append(image.layerSources, sourceImage)
+	}
+
+	return nil
+}
+
 func processBaseImageOption(image *Image, baseImageRepoName string, platform imgutil.Platform, dockerClient client.CommonAPIClient) error {
 	inspect, err := inspectOptionalImage(dockerClient, baseImageRepoName, platform)
 	if err != nil {
 		return err
 	}
 
+	image.logResolved("base", baseImageRepoName, inspect)
 	image.inspect = inspect
 	image.layerPaths = make([]string, len(image.inspect.RootFS.Layers))
 
 	return nil
 }
 
+func (i *Image) logResolved(source, repoName string, inspect types.ImageInspect) {
+	if inspect.ID == "" {
+		i.logger.Debug(imgutil.EventFallback, "source", source, "image", repoName, "reason", "image not found in daemon")
+		return
+	}
+	i.logger.Debug(imgutil.EventBaseResolved, "source", source, "image", repoName, "id", inspect.ID)
+}
+
 func prepareNewWindowsImage(image *Image) error {
 	// only append base layer to empty image
 	if len(image.inspect.RootFS.Layers) > 0 {
@@ -217,10 +303,22 @@ func (i *Image) Env(key string) (string, error) {
 	return "", nil
 }
 
+func (i *Image) EnvVars() ([]string, error) {
+	return append([]string{}, i.inspect.Config.Env...), nil
+}
+
 func (i *Image) Entrypoint() ([]string, error) {
 	return i.inspect.Config.Entrypoint, nil
 }
 
+func (i *Image) Cmd() ([]string, error) {
+	return i.inspect.Config.Cmd, nil
+}
+
+func (i *Image) Layers() ([]string, error) {
+	return append([]string{}, i.inspect.RootFS.Layers...), nil
+}
+
 func (i *Image) OS() (string, error) {
 	return i.inspect.Os, nil
 }
@@ -302,6 +400,18 @@ func (i *Image) SetLabel(key, val string) error {
 	return nil
 }
 
+// Annotations returns imgutil.ErrAnnotationsUnsupported: images in a Docker daemon have no manifest. Use Label
+// instead.
+func (i *Image) Annotations() (map[string]string, error) {
+	return nil, imgutil.ErrAnnotationsUnsupported
+}
+
+// SetAnnotation returns imgutil.ErrAnnotationsUnsupported: images in a Docker daemon have no manifest. Use SetLabel
+// instead.
+func (i *Image) SetAnnotation(key, val string) error {
+	return imgutil.ErrAnnotationsUnsupported
+}
+
 func (i *Image) SetOS(osVal string) error {
 	if osVal != i.inspect.Os {
 		return fmt.Errorf(`invalid os: must match the daemon: "%s"`, i.inspect.Os)
@@ -409,23 +519,37 @@ func (i *Image) AddLayerWithDiffID(path, diffID string) error {
 }
 
 func (i *Image) ReuseLayer(diffID string) error {
-	if i.prevImage == nil {
-		return errors.New("failed to reuse layer because no previous image was provided")
-	}
-	if !i.prevImage.Found() {
-		return fmt.Errorf("failed to reuse layer because previous image '%s' was not found in daemon", i.prevImage.repoName)
+	if len(i.layerSources) == 0 {
+		if i.prevImage == nil {
+			return errors.New("failed to reuse layer because no previous image was provided")
+		}
+		if !i.prevImage.Found() {
+			return fmt.Errorf("failed to reuse layer because previous image '%s' was not found in daemon", i.prevImage.repoName)
+		}
 	}
 
-	if err := i.prevImage.downloadBaseLayersOnce(); err != nil {
-		return err
+	sources := i.layerSources
+	if i.prevImage != nil && i.prevImage.Found() {
+		sources = append([]*Image{i.prevImage}, sources...)
 	}
 
-	for l := range i.prevImage.inspect.RootFS.Layers {
-		if i.prevImage.inspect.RootFS.Layers[l] == diffID {
-			return i.AddLayerWithDiffID(i.prevImage.layerPaths[l], diffID)
+	for _, source := range sources {
+		for l := range source.inspect.RootFS.Layers {
+			if source.inspect.RootFS.Layers[l] != diffID {
+				continue
+			}
+			if err := source.downloadBaseLayersOnce(); err != nil {
+				return err
+			}
+			i.logger.Debug(imgutil.EventLayerReused, "image", i.repoName, "diffID", diffID, "from", source.Name())
+			return i.AddLayerWithDiffID(source.layerPaths[l], diffID)
 		}
 	}
-	return fmt.Errorf("SHA %s was not found in %s", diffID, i.prevImage.Name())
+
+	if len(i.layerSources) == 0 {
+		return fmt.Errorf("SHA %s was not found in %s", diffID, i.prevImage.Name())
+	}
+	return fmt.Errorf("SHA %s was not found in the previous image or layer sources", diffID)
 }
 
 func (i *Image) Save(additionalNames ...string) error {
@@ -433,6 +557,8 @@ func (i *Image) Save(additionalNames ...string) error {
 	// of layers already exists in the daemon in the given order
 	inspect, err := i.doSave()
 	if err != nil {
+		i.logger.Debug(imgutil.EventRetry, "image", i.repoName, "operation", "save", "reason", err.Error())
+
 		// populate all layer paths and try again without the above performance optimization.
 		if err := i.downloadBaseLayersOnce(); err != nil {
 			return err
@@ -477,15 +603,17 @@ func (i *Image) doSave() (types.ImageInspect, error) {
 
 	pr, pw := io.Pipe()
 	defer pw.Close()
+	cw := &countingWriter{Writer: pw}
+	start := time.Now()
 	go func() {
-		res, err := i.docker.ImageLoad(ctx, pr, true)
+		res, err := i.docker.ImageLoad(ctx, pr, i.progress == nil)
 		if err != nil {
 			done <- err
 			return
 		}
 
 		// only return response error after response is drained and closed
-		responseErr := checkResponseError(res.Body)
+		responseErr := checkResponseError(res.Body, i.progress)
 		drainCloseErr := ensureReaderClosed(res.Body)
 		if responseErr != nil {
 			done <- responseErr
@@ -498,7 +626,7 @@ func (i *Image) doSave() (types.ImageInspect, error) {
 		done <- nil
 	}()
 
-	tw := tar.NewWriter(pw)
+	tw := tar.NewWriter(cw)
 	defer tw.Close()
 
 	configFile, err := i.newConfigFile()
@@ -523,7 +651,7 @@ func (i *Image) doSave() (types.ImageInspect, error) {
 			return types.ImageInspect{}, err
 		}
 		defer f.Close()
-		if err := addFileToTar(tw, layerName, f); err != nil {
+		if err := i.addLayerToTar(tw, layerName, f); err != nil {
 			return types.ImageInspect{}, err
 		}
 		f.Close()
@@ -551,6 +679,10 @@ func (i *Image) doSave() (types.ImageInspect, error) {
 	if err != nil {
 		return types.ImageInspect{}, errors.Wrapf(err, "image load '%s'. first error", i.repoName)
 	}
+	i.metrics.Histogram(imgutil.MetricDaemonLoadSeconds, time.Since(start).Seconds())
+	i.metrics.Counter(imgutil.MetricBytesUploaded, float64(cw.count))
+	i.metrics.Histogram(imgutil.MetricLayers, float64(len(i.layerPaths)))
+	i.logger.Debug(imgutil.EventBytesPushed, "image", i.repoName, "bytes", cw.count)
 
 	inspect, _, err := i.docker.ImageInspectWithRaw(context.Background(), id)
 	if err != nil {
@@ -563,6 +695,19 @@ func (i *Image) doSave() (types.ImageInspect, error) {
 	return inspect, nil
 }
 
+func (i *Image) addLayerToTar(tw *tar.Writer, name string, contents *os.File) error {
+	fi, err := contents.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: fi.Size()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, newProgressReader(contents, i.progress, name, progressStatusLoading, fi.Size()))
+	return err
+}
+
 func (i *Image) newConfigFile() ([]byte, error) {
 	cfg, err := v1Config(i.inspect)
 	if err != nil {
@@ -579,8 +724,11 @@ func (i *Image) Delete() error {
 		Force:         true,
 		PruneChildren: true,
 	}
-	_, err := i.docker.ImageRemove(context.Background(), i.inspect.ID, options)
-	return err
+	if _, err := i.docker.ImageRemove(context.Background(), i.inspect.ID, options); err != nil {
+		return err
+	}
+	i.inspect.ID = ""
+	return nil
 }
 
 func (i *Image) ManifestSize() (int64, error) {
@@ -617,7 +765,7 @@ func (i *Image) downloadBaseLayers() error {
 		return errors.Wrap(err, "failed to create temp dir")
 	}
 
-	err = untar(imageReader, tmpDir)
+	err = untar(newProgressReader(imageReader, i.progress, i.inspect.ID, progressStatusSaving, 0), tmpDir)
 	if err != nil {
 		return err
 	}
@@ -678,19 +826,6 @@ func addTextToTar(tw *tar.Writer, name string, contents []byte) error {
 	return err
 }
 
-func addFileToTar(tw *tar.Writer, name string, contents *os.File) error {
-	fi, err := contents.Stat()
-	if err != nil {
-		return err
-	}
-	hdr := &tar.Header{Name: name, Mode: 0644, Size: fi.Size()}
-	if err := tw.WriteHeader(hdr); err != nil {
-		return err
-	}
-	_, err = io.Copy(tw, contents)
-	return err
-}
-
 func untar(r io.Reader, dest string) error {
 	tr := tar.NewReader(r)
 	for {
@@ -855,19 +990,6 @@ func v1Config(inspect types.ImageInspect) (v1.ConfigFile, error) {
 	}, nil
 }
 
-func checkResponseError(r io.Reader) error {
-	decoder := json.NewDecoder(r)
-	var jsonMessage jsonmessage.JSONMessage
-	if err := decoder.Decode(&jsonMessage); err != nil {
-		return errors.Wrapf(err, "parsing daemon response")
-	}
-
-	if jsonMessage.Error != nil {
-		return errors.Wrap(jsonMessage.Error, "embedded daemon response")
-	}
-	return nil
-}
-
 // ensureReaderClosed drains and closes and reader, returning the first error
 func ensureReaderClosed(r io.ReadCloser) error {
 	_, err := io.Copy(ioutil.Discard, r)
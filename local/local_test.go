@@ -9,15 +9,19 @@ import (
 	"math/rand"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/fakes"
+	"github.com/buildpacks/imgutil/imgutiltest"
 	"github.com/buildpacks/imgutil/local"
 	h "github.com/buildpacks/imgutil/testhelpers"
 )
@@ -34,6 +38,22 @@ func TestLocal(t *testing.T) {
 	spec.Run(t, "Image", testImage, spec.Sequential(), spec.Report(report.Terminal{}))
 }
 
+type imageFactory struct {
+	docker client.CommonAPIClient
+}
+
+func (f imageFactory) NewImage(repoName string) (imgutil.Image, error) {
+	return local.NewImage(repoName, f.docker)
+}
+
+func (f imageFactory) RepoName(name string) string {
+	return name
+}
+
+func TestLocalSuite(t *testing.T) {
+	imgutiltest.RunImageSuite(t, imageFactory{docker: fakes.NewDockerClient("linux")})
+}
+
 func newTestImageName() string {
 	return "localhost:" + localTestRegistry.Port + "/pack-image-test-" + h.RandString(10)
 }
@@ -748,6 +768,17 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#SetAnnotation", func() {
+		it("returns an unsupported error", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, img.SetAnnotation("some-key", "some-value"), imgutil.ErrAnnotationsUnsupported)
+			_, err = img.Annotations()
+			h.AssertEq(t, err, imgutil.ErrAnnotationsUnsupported)
+		})
+	})
+
 	when("#RemoveLabel", func() {
 		var (
 			img           imgutil.Image
@@ -1216,6 +1247,24 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#Layers", func() {
+		it("returns the diff ids of the layers from the bottom layer", func() {
+			img, err := local.NewImage(newTestImageName(), dockerClient)
+			h.AssertNil(t, err)
+			existingLayers, err := img.Layers()
+			h.AssertNil(t, err)
+
+			layerPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", daemonOS)
+			h.AssertNil(t, err)
+			defer os.Remove(layerPath)
+			h.AssertNil(t, img.AddLayer(layerPath))
+
+			layers, err := img.Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, layers, append(existingLayers, h.FileDiffID(t, layerPath)))
+		})
+	})
+
 	when("#AddLayer", func() {
 		when("empty image", func() {
 			var repoName = newTestImageName()
@@ -1518,6 +1567,36 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 
 			h.AssertEq(t, prevLayer1SHA, newLayer1SHA)
 		})
+
+		when("layer sources are given", func() {
+			it("reuses a layer from a layer source", func() {
+				img, err := local.NewImage(
+					repoName,
+					dockerClient,
+					local.WithLayerSources("some-missing-image", prevName),
+				)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.ReuseLayer(prevLayer2SHA))
+				h.AssertNil(t, img.Save())
+
+				inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+				h.AssertNil(t, err)
+				h.AssertEq(t, h.StringElementAt(inspect.RootFS.Layers, -1), prevLayer2SHA)
+			})
+
+			it("returns error on nonexistent layer", func() {
+				img, err := local.NewImage(
+					repoName,
+					dockerClient,
+					local.WithLayerSources(prevName),
+				)
+				h.AssertNil(t, err)
+
+				err = img.ReuseLayer("some-bad-sha")
+				h.AssertError(t, err, "SHA some-bad-sha was not found in the previous image or layer sources")
+			})
+		})
 	})
 
 	when("#Save", func() {
@@ -1597,6 +1676,118 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 
+			when("#WithProgress", func() {
+				it("reports daemon messages and layer progress", func() {
+					var (
+						messages   []jsonmessage.JSONMessage
+						inFlight   int32
+						concurrent bool
+					)
+					img, err := local.NewImage(
+						repoName,
+						dockerClient,
+						local.FromBaseImage(runnableBaseImageName),
+						local.WithProgress(func(m jsonmessage.JSONMessage) {
+							if atomic.AddInt32(&inFlight, 1) > 1 {
+								concurrent = true
+							}
+							messages = append(messages, m)
+							atomic.AddInt32(&inFlight, -1)
+						}),
+					)
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.AddLayer(tarPath))
+
+					h.AssertNil(t, img.Save())
+
+					fi, err := os.Stat(tarPath)
+					h.AssertNil(t, err)
+
+					var layerDone, daemonMessage bool
+					for _, m := range messages {
+						if m.Status == "Loading" && m.Progress != nil && m.Progress.Current == fi.Size() {
+							layerDone = true
+						}
+						if m.Stream != "" || (m.Status != "Loading" && m.Status != "Saving") {
+							daemonMessage = true
+						}
+					}
+					h.AssertEq(t, layerDone, true)
+					h.AssertEq(t, daemonMessage, true)
+					h.AssertEq(t, concurrent, false)
+				})
+			})
+
+			when("#WithLogger", func() {
+				it("reports base resolution and bytes pushed", func() {
+					logger := &h.RecordingLogger{}
+					img, err := local.NewImage(
+						repoName,
+						dockerClient,
+						local.FromBaseImage(runnableBaseImageName),
+						local.WithLogger(logger),
+					)
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.AddLayer(tarPath))
+
+					h.AssertNil(t, img.Save())
+
+					resolved := logger.EventsNamed(imgutil.EventBaseResolved)
+					h.AssertEq(t, len(resolved), 1)
+					h.AssertEq(t, resolved[0].Fields["image"], runnableBaseImageName)
+
+					pushed := logger.EventsNamed(imgutil.EventBytesPushed)
+					h.AssertEq(t, len(pushed) > 0, true)
+					h.AssertEq(t, pushed[len(pushed)-1].Fields["bytes"].(int64) > 0, true)
+				})
+
+				it("discards the events when the logger is nil", func() {
+					img, err := local.NewImage(
+						repoName,
+						dockerClient,
+						local.FromBaseImage(runnableBaseImageName),
+						local.WithPreviousImage(runnableBaseImageName),
+						local.WithLogger(nil),
+					)
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.AddLayer(tarPath))
+
+					h.AssertNil(t, img.Save())
+				})
+			})
+
+			when("#WithMetrics", func() {
+				it("reports streamed bytes, layers and daemon load latency", func() {
+					metrics := h.NewRecordingMetrics()
+					img, err := local.NewImage(
+						repoName,
+						dockerClient,
+						local.FromBaseImage(runnableBaseImageName),
+						local.WithMetrics(metrics),
+					)
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.AddLayer(tarPath))
+
+					h.AssertNil(t, img.Save())
+
+					inspect, _, err := dockerClient.ImageInspectWithRaw(context.TODO(), repoName)
+					h.AssertNil(t, err)
+
+					h.AssertEq(t, metrics.Counters[imgutil.MetricBytesUploaded] > 0, true)
+					layers := metrics.Histograms[imgutil.MetricLayers]
+					h.AssertEq(t, layers[len(layers)-1], float64(len(inspect.RootFS.Layers)))
+					h.AssertEq(t, len(metrics.Histograms[imgutil.MetricDaemonLoadSeconds]) > 0, true)
+				})
+
+				it("discards the measurements when the metrics are nil", func() {
+					img, err := local.NewImage(repoName, dockerClient, local.FromBaseImage(runnableBaseImageName), local.WithMetrics(nil))
+					h.AssertNil(t, err)
+					h.AssertNil(t, img.AddLayer(tarPath))
+
+					h.AssertNil(t, img.Save())
+				})
+			})
+
 			when("additional names are provided", func() {
 				var (
 					additionalRepoNames = []string{
@@ -0,0 +1,99 @@
+package local
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/pkg/errors"
+)
+
+// ProgressHandler receives every message reported by the daemon while loading an image, along with
+// progress messages for the bytes streamed to and from the daemon. An image calls its handler from one goroutine at a
+// time, although the daemon response and the layers streamed to the daemon are read concurrently.
+type ProgressHandler func(jsonmessage.JSONMessage)
+
+// serializeProgress returns a handler that passes messages to handler one at a time.
+func serializeProgress(handler ProgressHandler) ProgressHandler {
+	if handler == nil {
+		return nil
+	}
+	var mu sync.Mutex
+	return func(m jsonmessage.JSONMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		handler(m)
+	}
+}
+
+const (
+	progressStatusLoading = "Loading"
+	progressStatusSaving  = "Saving"
+)
+
+// progressReader reports the number of bytes read through it to handler.
+type progressReader struct {
+	io.Reader
+	handler ProgressHandler
+	id      string
+	status  string
+	total   int64
+	current int64
+}
+
+func newProgressReader(r io.Reader, handler ProgressHandler, id, status string, total int64) io.Reader {
+	if handler == nil {
+		return r
+	}
+	return &progressReader{Reader: r, handler: handler, id: id, status: status, total: total}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.Reader.Read(b)
+	if n > 0 {
+		p.current += int64(n)
+		p.handler(jsonmessage.JSONMessage{
+			ID:       p.id,
+			Status:   p.status,
+			Progress: &jsonmessage.JSONProgress{Current: p.current, Total: p.total},
+		})
+	}
+	return n, err
+}
+
+// checkResponseError decodes every message in a daemon response, passing each one to handler, and returns the
+// first embedded error. A response without messages is an error.
+func checkResponseError(r io.Reader, handler ProgressHandler) error {
+	var firstErr error
+	decoder := json.NewDecoder(r)
+	for decoded := 0; ; decoded++ {
+		var jsonMessage jsonmessage.JSONMessage
+		if err := decoder.Decode(&jsonMessage); err != nil {
+			if err == io.EOF && decoded > 0 {
+				return firstErr
+			}
+			return errors.Wrapf(err, "parsing daemon response")
+		}
+
+		if handler != nil {
+			handler(jsonMessage)
+		}
+
+		if jsonMessage.Error != nil && firstErr == nil {
+			firstErr = errors.Wrap(jsonMessage.Error, "embedded daemon response")
+		}
+	}
+}
+
+// countingWriter counts the number of bytes written through it.
+type countingWriter struct {
+	io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.Writer.Write(b)
+	c.count += int64(n)
+	return n, err
+}
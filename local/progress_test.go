@@ -0,0 +1,97 @@
+package local_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/fakes"
+	"github.com/buildpacks/imgutil/local"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestProgress(t *testing.T) {
+	spec.Run(t, "Progress", testProgress, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+// emptyResponseClient is a daemon that loads images without reporting anything.
+type emptyResponseClient struct {
+	*fakes.DockerClient
+}
+
+func (c emptyResponseClient) ImageLoad(ctx context.Context, input io.Reader, quiet bool) (types.ImageLoadResponse, error) {
+	res, err := c.DockerClient.ImageLoad(ctx, input, quiet)
+	if err != nil {
+		return res, err
+	}
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(strings.NewReader(""))
+	return res, nil
+}
+
+func testProgress(t *testing.T, when spec.G, it spec.S) {
+	var tarPath string
+
+	it.Before(func() {
+		var err error
+		tarPath, err = h.CreateSingleFileLayerTar("/some-file.txt", "some-content", "linux")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.Remove(tarPath))
+	})
+
+	when("#WithProgress", func() {
+		it("reports daemon messages and layer progress one at a time", func() {
+			var (
+				messages   []jsonmessage.JSONMessage
+				inFlight   int32
+				concurrent bool
+			)
+			img, err := local.NewImage("some-image", fakes.NewDockerClient("linux"), local.WithProgress(func(m jsonmessage.JSONMessage) {
+				if atomic.AddInt32(&inFlight, 1) > 1 {
+					concurrent = true
+				}
+				messages = append(messages, m)
+				atomic.AddInt32(&inFlight, -1)
+			}))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.AddLayer(tarPath))
+
+			h.AssertNil(t, img.Save())
+
+			var layerProgress, loaded bool
+			for _, m := range messages {
+				if m.Status == "Loading" && m.Progress != nil {
+					layerProgress = true
+				}
+				if strings.HasPrefix(m.Stream, "Loaded image ID") {
+					loaded = true
+				}
+			}
+			h.AssertEq(t, layerProgress, true)
+			h.AssertEq(t, loaded, true)
+			h.AssertEq(t, concurrent, false)
+		})
+	})
+
+	when("the daemon response is empty", func() {
+		it("fails to save", func() {
+			img, err := local.NewImage("some-image", emptyResponseClient{fakes.NewDockerClient("linux")})
+			h.AssertNil(t, err)
+
+			err = img.Save()
+			h.AssertError(t, err, "parsing daemon response")
+		})
+	})
+}
@@ -0,0 +1,28 @@
+package imgutil
+
+// Logger receives structured diagnostic events from Image implementations.
+// keysAndValues holds alternating keys and values that describe the event.
+type Logger interface {
+	Debug(event string, keysAndValues ...interface{})
+}
+
+// Events reported to a Logger.
+const (
+	// EventBaseResolved is reported when a base or previous image is found.
+	EventBaseResolved = "base resolved"
+	// EventFallback is reported when a base or previous image cannot be used and an empty image is used instead.
+	EventFallback = "fallback taken"
+	// EventLayerReused is reported when a layer is reused from a previous image.
+	EventLayerReused = "layer reused"
+	// EventBytesPushed is reported when an image has been written to its store.
+	EventBytesPushed = "bytes pushed"
+	// EventRetry is reported when an operation failed and is being attempted again.
+	EventRetry = "retry"
+)
+
+// NopLogger discards all events.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
@@ -0,0 +1,32 @@
+package imgutil
+
+// Metrics receives measurements from Image implementations, e.g. to be forwarded to a Prometheus collector.
+type Metrics interface {
+	// Counter adds value to the counter with the given name.
+	Counter(name string, value float64)
+	// Histogram records value as an observation of the histogram with the given name.
+	Histogram(name string, value float64)
+}
+
+// Measurements reported to Metrics.
+const (
+	// MetricBytesUploaded counts the bytes sent to a registry or daemon when saving an image.
+	MetricBytesUploaded = "imgutil_uploaded_bytes_total"
+	// MetricBytesReused counts the bytes of blobs that already existed in the registry and were not uploaded.
+	MetricBytesReused = "imgutil_reused_bytes_total"
+	// MetricLayers observes the number of layers of each saved image.
+	MetricLayers = "imgutil_image_layers"
+	// MetricManifestPutSeconds observes the latency of manifest uploads to a registry.
+	MetricManifestPutSeconds = "imgutil_manifest_put_duration_seconds"
+	// MetricDaemonLoadSeconds observes the latency of loading an image into a daemon.
+	MetricDaemonLoadSeconds = "imgutil_daemon_load_duration_seconds"
+)
+
+// NopMetrics discards all measurements.
+var NopMetrics Metrics = nopMetrics{}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Counter(string, float64) {}
+
+func (nopMetrics) Histogram(string, float64) {}
@@ -0,0 +1,133 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+)
+
+// Annotations returns the annotations of the manifest of the image, which include the annotations of the base image,
+// along with the annotations set on the image.
+func (i *Image) Annotations() (map[string]string, error) {
+	manifest, err := i.image.Manifest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get manifest for image '%s'", i.repoName)
+	}
+
+	annotations := map[string]string{}
+	for k, v := range manifest.Annotations {
+		annotations[k] = v
+	}
+	for k, v := range i.annotations {
+		annotations[k] = v
+	}
+	return annotations, nil
+}
+
+// SetAnnotation sets an annotation that is written to the manifest of the image on Save. Annotations survive Rebase.
+// Images saved with Docker media types have the annotations in their schema 2 manifest, which registries store but
+// Docker ignores: use WithMediaTypes(imgutil.OCITypes) to save annotated images as OCI images.
+func (i *Image) SetAnnotation(key, val string) error {
+	i.annotations[key] = val
+	return nil
+}
+
+// annotate returns image with annotations added to its manifest.
+func annotate(image v1.Image, annotations map[string]string) (v1.Image, error) {
+	return editManifest(image, func(manifest *v1.Manifest) error {
+		if manifest.Annotations == nil {
+			manifest.Annotations = map[string]string{}
+		}
+		for k, v := range annotations {
+			manifest.Annotations[k] = v
+		}
+		return nil
+	})
+}
+
+// manifestImage is a v1.Image with an edited manifest, e.g. with annotations or other media types. Its config and
+// layers are those of the original image, so that they are mounted and uploaded as before.
+type manifestImage struct {
+	v1.Image
+	manifest *v1.Manifest
+	raw      []byte
+}
+
+// editManifest returns image with the manifest changed by edit.
+func editManifest(image v1.Image, edit func(manifest *v1.Manifest) error) (v1.Image, error) {
+	manifest, err := image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	manifest = manifest.DeepCopy()
+	if err := edit(manifest); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestImage{Image: image, manifest: manifest, raw: raw}, nil
+}
+
+func (m *manifestImage) MediaType() (types.MediaType, error) {
+	if m.manifest.MediaType != "" {
+		return m.manifest.MediaType, nil
+	}
+	return m.Image.MediaType()
+}
+
+// Layers returns the layers of the original image, with the media types of the edited manifest. Layers that can be
+// mounted from another repository stay mountable.
+func (m *manifestImage) Layers() ([]v1.Layer, error) {
+	original, err := m.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(original) != len(m.manifest.Layers) {
+		return nil, fmt.Errorf("the manifest has %d layers instead of %d", len(m.manifest.Layers), len(original))
+	}
+
+	layers := make([]v1.Layer, len(original))
+	for i, l := range original {
+		layers[i] = l
+		mediaType, err := l.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if mediaType == m.manifest.Layers[i].MediaType {
+			continue
+		}
+		if ml, ok := l.(*remote.MountableLayer); ok {
+			layers[i] = &remote.MountableLayer{
+				Layer:     &mediaTypeLayer{Layer: ml.Layer, mediaType: m.manifest.Layers[i].MediaType},
+				Reference: ml.Reference,
+			}
+		} else {
+			layers[i] = &mediaTypeLayer{Layer: l, mediaType: m.manifest.Layers[i].MediaType}
+		}
+	}
+	return layers, nil
+}
+
+func (m *manifestImage) Manifest() (*v1.Manifest, error) {
+	return m.manifest.DeepCopy(), nil
+}
+
+func (m *manifestImage) RawManifest() ([]byte, error) {
+	return m.raw, nil
+}
+
+func (m *manifestImage) Digest() (v1.Hash, error) {
+	digest, _, err := v1.SHA256(bytes.NewReader(m.raw))
+	return digest, err
+}
+
+func (m *manifestImage) Size() (int64, error) {
+	return int64(len(m.raw)), nil
+}
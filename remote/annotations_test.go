@@ -0,0 +1,103 @@
+package remote_test
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/remote"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestAnnotations(t *testing.T) {
+	spec.Run(t, "Annotations", testAnnotations, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testAnnotations(t *testing.T, when spec.G, it spec.S) {
+	var registry *h.InMemoryRegistry
+
+	it.Before(func() {
+		registry = h.NewInMemoryRegistry()
+		registry.Start(t)
+	})
+
+	it.After(func() {
+		registry.Stop(t)
+	})
+
+	manifestAnnotations := func(repoName string) map[string]string {
+		t.Helper()
+		ref, err := name.ParseReference(repoName, name.WeakValidation)
+		h.AssertNil(t, err)
+		image, err := ggcrremote.Image(ref)
+		h.AssertNil(t, err)
+		manifest, err := image.Manifest()
+		h.AssertNil(t, err)
+		return manifest.Annotations
+	}
+
+	it("implements imgutil.AnnotatableImage", func() {
+		var _ imgutil.AnnotatableImage = &remote.Image{}
+	})
+
+	when("#SetAnnotation", func() {
+		it("writes the annotations to the manifest on Save", func() {
+			repoName := registry.RepoName("some-image")
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetAnnotation("org.opencontainers.image.source", "https://example.com/some-repo"))
+			h.AssertNil(t, img.SetAnnotation("org.opencontainers.image.revision", "some-revision"))
+			h.AssertNil(t, img.Save())
+
+			h.AssertEq(t, manifestAnnotations(repoName), map[string]string{
+				"org.opencontainers.image.source":   "https://example.com/some-repo",
+				"org.opencontainers.image.revision": "some-revision",
+			})
+		})
+
+		it("identifies the image by the digest of the annotated manifest", func() {
+			repoName := registry.RepoName("some-image")
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetAnnotation("some-key", "some-value"))
+			h.AssertNil(t, img.Save())
+
+			identifier, err := img.Identifier()
+			h.AssertNil(t, err)
+			ref, err := name.ParseReference(repoName, name.WeakValidation)
+			h.AssertNil(t, err)
+			desc, err := ggcrremote.Get(ref)
+			h.AssertNil(t, err)
+			h.AssertEq(t, identifier.String(), ref.Context().Name()+"@"+desc.Digest.String())
+		})
+	})
+
+	when("#Annotations", func() {
+		it("returns the annotations of the base image along with the annotations set on the image", func() {
+			baseName := registry.RepoName("some-base")
+			base, err := remote.NewImage(baseName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, base.SetAnnotation("base-key", "base-value"))
+			h.AssertNil(t, base.SetAnnotation("some-key", "base-value"))
+			h.AssertNil(t, base.Save())
+
+			repoName := registry.RepoName("some-image")
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseName))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetAnnotation("some-key", "some-value"))
+
+			annotations, err := img.Annotations()
+			h.AssertNil(t, err)
+			h.AssertEq(t, annotations, map[string]string{"base-key": "base-value", "some-key": "some-value"})
+
+			h.AssertNil(t, img.Save())
+			h.AssertEq(t, manifestAnnotations(repoName), annotations)
+		})
+	})
+}
@@ -0,0 +1,357 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/internal/blob"
+)
+
+// Artifacts are stored as OCI image manifests whose `subject` refers to the image. Registries supporting the OCI
+// referrers API index them by subject, for other registries an image index listing them is kept in the
+// "<algorithm>-<hex>" tag of the image digest.
+
+const emptyConfigMediaType types.MediaType = "application/vnd.oci.empty.v1+json"
+
+var emptyConfig = []byte("{}")
+
+type ociDescriptor struct {
+	MediaType    types.MediaType   `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       v1.Hash           `json:"digest"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int64             `json:"schemaVersion"`
+	MediaType     types.MediaType   `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Subject       *ociDescriptor    `json:"subject,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int64           `json:"schemaVersion"`
+	MediaType     types.MediaType `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// AttachArtifact stores content as an OCI artifact referring to the image by digest, in the repository of the image.
+// The image should be saved before attaching artifacts to it.
+func (i *Image) AttachArtifact(artifact imgutil.Artifact, content []byte) (imgutil.Artifact, error) {
+	if artifact.ArtifactType == "" {
+		return imgutil.Artifact{}, errors.New("artifact type must be provided")
+	}
+	if artifact.MediaType == "" {
+		artifact.MediaType = artifact.ArtifactType
+	}
+
+	repo, subject, err := i.subject()
+	if err != nil {
+		return imgutil.Artifact{}, err
+	}
+
+	contentLayer, err := blob.NewLayer(content, types.MediaType(artifact.MediaType))
+	if err != nil {
+		return imgutil.Artifact{}, err
+	}
+	configLayer, err := blob.NewLayer(emptyConfig, emptyConfigMediaType)
+	if err != nil {
+		return imgutil.Artifact{}, err
+	}
+
+	auth, err := i.keychain.Resolve(repo.Registry)
+	if err != nil {
+		return imgutil.Artifact{}, err
+	}
+	for _, l := range []*blob.Layer{configLayer, contentLayer} {
+		if err := remote.WriteLayer(repo, l, remote.WithAuth(auth), remote.WithTransport(i.transport)); err != nil {
+			return imgutil.Artifact{}, errors.Wrap(err, "writing artifact blob")
+		}
+	}
+
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		ArtifactType:  artifact.ArtifactType,
+		Config:        blobDescriptor(configLayer),
+		Layers:        []ociDescriptor{blobDescriptor(contentLayer)},
+		Subject:       &subject,
+		Annotations:   artifact.Annotations,
+	}
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		return imgutil.Artifact{}, err
+	}
+	digest, size, err := v1.SHA256(bytes.NewReader(raw))
+	if err != nil {
+		return imgutil.Artifact{}, err
+	}
+
+	client, err := i.registryClient(repo, transport.PushScope)
+	if err != nil {
+		return imgutil.Artifact{}, err
+	}
+	if err := putManifest(client, repo, digest.String(), types.OCIManifestSchema1, raw); err != nil {
+		return imgutil.Artifact{}, errors.Wrap(err, "writing artifact manifest")
+	}
+
+	if _, supported, err := referrersFromAPI(client, repo, subject.Digest); err != nil {
+		return imgutil.Artifact{}, err
+	} else if !supported {
+		desc := ociDescriptor{
+			MediaType:    types.OCIManifestSchema1,
+			ArtifactType: artifact.ArtifactType,
+			Digest:       digest,
+			Size:         size,
+			Annotations:  artifact.Annotations,
+		}
+		if err := i.addFallbackReferrer(client, repo, subject.Digest, desc); err != nil {
+			return imgutil.Artifact{}, err
+		}
+	}
+
+	artifact.Digest = digest.String()
+	return artifact, nil
+}
+
+// Artifacts lists the artifacts referring to the image, with the media types of their content.
+func (i *Image) Artifacts() ([]imgutil.Artifact, error) {
+	repo, subject, err := i.subject()
+	if err != nil {
+		return nil, err
+	}
+	client, err := i.registryClient(repo, transport.PullScope)
+	if err != nil {
+		return nil, err
+	}
+
+	index, supported, err := referrersFromAPI(client, repo, subject.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if !supported {
+		if index, err = i.fallbackReferrers(repo, subject.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	artifacts := []imgutil.Artifact{}
+	for _, desc := range index.Manifests {
+		// referrers only describe the artifact manifests, whose blob has the media type of the content
+		manifest, err := i.artifactManifest(repo, desc.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, imgutil.Artifact{
+			ArtifactType: desc.ArtifactType,
+			MediaType:    string(manifest.Layers[0].MediaType),
+			Annotations:  desc.Annotations,
+			Digest:       desc.Digest.String(),
+		})
+	}
+	return artifacts, nil
+}
+
+// ArtifactContent returns a reader of the content of an artifact stored by AttachArtifact.
+func (i *Image) ArtifactContent(artifact imgutil.Artifact) (io.ReadCloser, error) {
+	repo, _, err := i.subject()
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := i.artifactManifest(repo, artifact.Digest)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := i.keychain.Resolve(repo.Registry)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := remote.Layer(repo.Digest(manifest.Layers[0].Digest.String()), remote.WithAuth(auth), remote.WithTransport(i.transport))
+	if err != nil {
+		return nil, err
+	}
+	// artifact content is stored as is, so the blob is read without decompressing it
+	return layer.Compressed()
+}
+
+// artifactManifest returns the manifest of the artifact with digest in repo, which has a single blob.
+func (i *Image) artifactManifest(repo name.Repository, digest string) (ociManifest, error) {
+	auth, err := i.keychain.Resolve(repo.Registry)
+	if err != nil {
+		return ociManifest{}, err
+	}
+	desc, err := remote.Get(repo.Digest(digest), remote.WithAuth(auth), remote.WithTransport(i.transport))
+	if err != nil {
+		return ociManifest{}, errors.Wrapf(err, "reading artifact '%s'", digest)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(desc.Manifest, &manifest); err != nil {
+		return ociManifest{}, errors.Wrapf(err, "parsing artifact '%s'", digest)
+	}
+	if len(manifest.Layers) != 1 {
+		return ociManifest{}, fmt.Errorf("artifact '%s' has %d blobs, expected 1", digest, len(manifest.Layers))
+	}
+	return manifest, nil
+}
+
+// subject returns the repository of the image and a descriptor of the manifest it was last saved with, or, when it
+// was not saved, of the manifest its name refers to in the registry. Changes made since the image was saved are
+// ignored.
+func (i *Image) subject() (name.Repository, ociDescriptor, error) {
+	ref, auth, err := i.referenceForRepoName(i.repoName)
+	if err != nil {
+		return name.Repository{}, ociDescriptor{}, err
+	}
+	if i.savedManifest != nil {
+		return ref.Context(), *i.savedManifest, nil
+	}
+
+	desc, err := remote.Head(ref, remote.WithAuth(auth), remote.WithTransport(i.transport))
+	if err != nil {
+		return name.Repository{}, ociDescriptor{}, errors.Wrapf(err, "getting digest for image '%s'", i.repoName)
+	}
+	return ref.Context(), ociDescriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}, nil
+}
+
+// manifestDescriptor returns a descriptor of the manifest of image.
+func manifestDescriptor(image v1.Image) (ociDescriptor, error) {
+	mediaType, err := image.MediaType()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	size, err := image.Size()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: size}, nil
+}
+
+func (i *Image) registryClient(repo name.Repository, scope string) (*http.Client, error) {
+	auth, err := i.keychain.Resolve(repo.Registry)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := transport.New(repo.Registry, auth, i.transport, []string{repo.Scope(scope)})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: tr}, nil
+}
+
+func (i *Image) fallbackReferrers(repo name.Repository, subject v1.Hash) (ociIndex, error) {
+	index := ociIndex{SchemaVersion: 2, MediaType: types.OCIImageIndex, Manifests: []ociDescriptor{}}
+
+	auth, err := i.keychain.Resolve(repo.Registry)
+	if err != nil {
+		return ociIndex{}, err
+	}
+	desc, err := remote.Get(fallbackTag(repo, subject), remote.WithAuth(auth), remote.WithTransport(i.transport))
+	if err != nil {
+		if transportErr, ok := err.(*transport.Error); ok && transportErr.StatusCode == http.StatusNotFound {
+			return index, nil
+		}
+		return ociIndex{}, errors.Wrap(err, "reading referrers")
+	}
+	if err := json.Unmarshal(desc.Manifest, &index); err != nil {
+		return ociIndex{}, errors.Wrap(err, "parsing referrers")
+	}
+	return index, nil
+}
+
+func (i *Image) addFallbackReferrer(client *http.Client, repo name.Repository, subject v1.Hash, desc ociDescriptor) error {
+	index, err := i.fallbackReferrers(repo, subject)
+	if err != nil {
+		return err
+	}
+	index.Manifests = append(index.Manifests, desc)
+
+	raw, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err := putManifest(client, repo, fallbackTag(repo, subject).TagStr(), types.OCIImageIndex, raw); err != nil {
+		return errors.Wrap(err, "writing referrers")
+	}
+	return nil
+}
+
+func fallbackTag(repo name.Repository, subject v1.Hash) name.Tag {
+	return repo.Tag(fmt.Sprintf("%s-%s", subject.Algorithm, subject.Hex))
+}
+
+// referrersFromAPI lists the referrers of subject with the OCI referrers API, reporting whether the registry
+// supports it.
+func referrersFromAPI(client *http.Client, repo name.Repository, subject v1.Hash) (ociIndex, bool, error) {
+	resp, err := client.Get(registryURL(repo, "referrers/"+subject.String()))
+	if err != nil {
+		return ociIndex{}, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !hasMediaType(resp.Header.Get("Content-Type"), types.OCIImageIndex) {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return ociIndex{}, false, err
+	}
+
+	var index ociIndex
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return ociIndex{}, false, errors.Wrap(err, "parsing referrers")
+	}
+	return index, true, nil
+}
+
+// hasMediaType reports whether the Content-Type header value contentType is mediaType, ignoring parameters such as
+// charset.
+func hasMediaType(contentType string, mediaType types.MediaType) bool {
+	parsed, _, err := mime.ParseMediaType(contentType)
+	return err == nil && parsed == string(mediaType)
+}
+
+func putManifest(client *http.Client, repo name.Repository, reference string, mediaType types.MediaType, raw []byte) error {
+	req, err := http.NewRequest(http.MethodPut, registryURL(repo, "manifests/"+reference), bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(mediaType))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return transport.CheckError(resp, http.StatusOK, http.StatusCreated, http.StatusAccepted)
+}
+
+func registryURL(repo name.Repository, path string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s", repo.Registry.Scheme(), repo.RegistryStr(), repo.RepositoryStr(), path)
+}
+
+// blobDescriptor returns the descriptor of a blob held in memory.
+func blobDescriptor(l *blob.Layer) ociDescriptor {
+	mediaType, _ := l.MediaType()
+	digest, _ := l.Digest()
+	size, _ := l.Size()
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: size}
+}
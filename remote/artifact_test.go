@@ -0,0 +1,215 @@
+package remote_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/remote"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestArtifact(t *testing.T) {
+	spec.Run(t, "Artifact", testArtifact, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testArtifact(t *testing.T, when spec.G, it spec.S) {
+	var (
+		registry *h.InMemoryRegistry
+		repoName string
+		img      *remote.Image
+		sbom     = []byte(`{"spdxVersion":"SPDX-2.2"}`)
+	)
+
+	it.Before(func() {
+		registry = h.NewInMemoryRegistry()
+		registry.Start(t)
+		repoName = registry.RepoName("some-image")
+
+		var err error
+		img, err = remote.NewImage(repoName, authn.DefaultKeychain)
+		h.AssertNil(t, err)
+		h.AssertNil(t, img.SetLabel("some-key", "some-value"))
+		h.AssertNil(t, img.Save())
+	})
+
+	it.After(func() {
+		registry.Stop(t)
+	})
+
+	when("#AttachArtifact", func() {
+		it("stores the artifact with the image as its subject", func() {
+			artifact, err := img.AttachArtifact(imgutil.Artifact{
+				ArtifactType: imgutil.ArtifactTypeSPDX,
+				Annotations:  map[string]string{"some-annotation": "some-value"},
+			}, sbom)
+			h.AssertNil(t, err)
+			h.AssertEq(t, strings.HasPrefix(artifact.Digest, "sha256:"), true)
+
+			ref, err := name.NewDigest(repoName + "@" + artifact.Digest)
+			h.AssertNil(t, err)
+			desc, err := ggcrremote.Get(ref)
+			h.AssertNil(t, err)
+
+			var manifest struct {
+				ArtifactType string `json:"artifactType"`
+				Subject      struct {
+					Digest string `json:"digest"`
+				} `json:"subject"`
+				Annotations map[string]string `json:"annotations"`
+			}
+			h.AssertNil(t, json.Unmarshal(desc.Manifest, &manifest))
+
+			identifier, err := img.Identifier()
+			h.AssertNil(t, err)
+			h.AssertEq(t, manifest.ArtifactType, imgutil.ArtifactTypeSPDX)
+			h.AssertEq(t, manifest.Subject.Digest, identifier.(remote.DigestIdentifier).Digest.DigestStr())
+			h.AssertEq(t, manifest.Annotations["some-annotation"], "some-value")
+		})
+
+		it("refers to the saved manifest when the image changed after Save", func() {
+			ref, err := name.ParseReference(repoName, name.WeakValidation)
+			h.AssertNil(t, err)
+			saved, err := ggcrremote.Head(ref)
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.SetLabel("some-key", "other-value"))
+			artifact, err := img.AttachArtifact(imgutil.Artifact{ArtifactType: imgutil.ArtifactTypeSPDX}, sbom)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, artifactSubject(t, repoName, artifact), saved.Digest.String())
+		})
+
+		it("refers to the manifest in the registry when the image was not saved", func() {
+			ref, err := name.ParseReference(repoName, name.WeakValidation)
+			h.AssertNil(t, err)
+			saved, err := ggcrremote.Head(ref)
+			h.AssertNil(t, err)
+
+			existing, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			artifact, err := existing.AttachArtifact(imgutil.Artifact{ArtifactType: imgutil.ArtifactTypeSPDX}, sbom)
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, artifactSubject(t, repoName, artifact), saved.Digest.String())
+		})
+
+		it("requires an artifact type", func() {
+			_, err := img.AttachArtifact(imgutil.Artifact{}, sbom)
+			h.AssertError(t, err, "artifact type must be provided")
+		})
+	})
+
+	when("#Artifacts", func() {
+		it("returns an empty list when no artifacts are attached", func() {
+			artifacts, err := img.Artifacts()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(artifacts), 0)
+		})
+
+		it("lists the attached artifacts", func() {
+			spdx, err := img.AttachArtifact(imgutil.Artifact{ArtifactType: imgutil.ArtifactTypeSPDX}, sbom)
+			h.AssertNil(t, err)
+			attestation, err := img.AttachArtifact(imgutil.Artifact{
+				ArtifactType: imgutil.ArtifactTypeInToto,
+				MediaType:    "application/vnd.dsse.envelope.v1+json",
+				Annotations:  map[string]string{"some-annotation": "some-value"},
+			}, []byte(`{"payloadType":"application/vnd.in-toto+json"}`))
+			h.AssertNil(t, err)
+
+			artifacts, err := imgutil.Artifacts(img)
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(artifacts), 2)
+			h.AssertEq(t, artifacts[0].Digest, spdx.Digest)
+			h.AssertEq(t, artifacts[0].ArtifactType, imgutil.ArtifactTypeSPDX)
+			h.AssertEq(t, artifacts[0].MediaType, imgutil.ArtifactTypeSPDX)
+			h.AssertEq(t, artifacts[1].Digest, attestation.Digest)
+			h.AssertEq(t, artifacts[1].MediaType, "application/vnd.dsse.envelope.v1+json")
+			h.AssertEq(t, artifacts[1].Annotations["some-annotation"], "some-value")
+		})
+
+		when("the registry supports the referrers API", func() {
+			var spdx imgutil.Artifact
+
+			it.Before(func() {
+				var err error
+				spdx, err = img.AttachArtifact(imgutil.Artifact{ArtifactType: imgutil.ArtifactTypeSPDX}, sbom)
+				h.AssertNil(t, err)
+			})
+
+			serveReferrers := func(contentType string) *bool {
+				referrersRequested := false
+				registry.InjectFault(h.Fault{
+					Method: http.MethodGet,
+					Path:   "/referrers/",
+					Handler: func(w http.ResponseWriter, r *http.Request) {
+						referrersRequested = true
+						w.Header().Set("Content-Type", contentType)
+						_, _ = w.Write([]byte(`{"schemaVersion":2,"manifests":[{"mediaType":"application/vnd.oci.image.manifest.v1+json","artifactType":"application/spdx+json","digest":"` + spdx.Digest + `","size":1}]}`))
+					},
+				})
+				return &referrersRequested
+			}
+
+			it("uses the referrers API", func() {
+				referrersRequested := serveReferrers("application/vnd.oci.image.index.v1+json")
+
+				artifacts, err := img.Artifacts()
+				h.AssertNil(t, err)
+				h.AssertEq(t, *referrersRequested, true)
+				h.AssertEq(t, len(artifacts), 1)
+				h.AssertEq(t, artifacts[0].Digest, spdx.Digest)
+				h.AssertEq(t, artifacts[0].MediaType, imgutil.ArtifactTypeSPDX)
+			})
+
+			it("accepts media type parameters in the Content-Type", func() {
+				serveReferrers("application/vnd.oci.image.index.v1+json; charset=utf-8")
+
+				artifacts, err := img.Artifacts()
+				h.AssertNil(t, err)
+				h.AssertEq(t, len(artifacts), 1)
+				h.AssertEq(t, artifacts[0].Digest, spdx.Digest)
+			})
+		})
+	})
+
+	when("#ArtifactContent", func() {
+		it("returns the content of the artifact", func() {
+			artifact, err := img.AttachArtifact(imgutil.Artifact{ArtifactType: imgutil.ArtifactTypeSPDX}, sbom)
+			h.AssertNil(t, err)
+
+			rc, err := imgutil.ArtifactContent(img, artifact)
+			h.AssertNil(t, err)
+			defer rc.Close()
+			contents, err := ioutil.ReadAll(rc)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), string(sbom))
+		})
+	})
+}
+
+// artifactSubject returns the digest of the subject of the artifact manifest stored in the repository of repoName.
+func artifactSubject(t *testing.T, repoName string, artifact imgutil.Artifact) string {
+	t.Helper()
+	ref, err := name.NewDigest(repoName + "@" + artifact.Digest)
+	h.AssertNil(t, err)
+	desc, err := ggcrremote.Get(ref)
+	h.AssertNil(t, err)
+
+	var manifest struct {
+		Subject struct {
+			Digest string `json:"digest"`
+		} `json:"subject"`
+	}
+	h.AssertNil(t, json.Unmarshal(desc.Manifest, &manifest))
+	return manifest.Subject.Digest
+}
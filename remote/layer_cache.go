@@ -0,0 +1,232 @@
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+)
+
+// LayerDigestCache maps the diff IDs of layers to the digests of their compressed blobs.
+type LayerDigestCache interface {
+	// Digest returns the digest of the compressed blob of the layer with diffID, if known.
+	Digest(diffID string) (string, bool)
+	// SetDigest records the digest of the compressed blob of the layer with diffID.
+	SetDigest(diffID, digest string)
+}
+
+// NewLayerDigestCache returns a LayerDigestCache held in memory, which can be shared by images.
+func NewLayerDigestCache() LayerDigestCache {
+	return &memoryLayerDigestCache{digests: map[string]string{}}
+}
+
+type memoryLayerDigestCache struct {
+	mu      sync.Mutex
+	digests map[string]string
+}
+
+func (c *memoryLayerDigestCache) Digest(diffID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	digest, ok := c.digests[diffID]
+	return digest, ok
+}
+
+func (c *memoryLayerDigestCache) SetDigest(diffID, digest string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.digests[diffID] = digest
+}
+
+// AddLayerWithDiffIDAndDigest adds the layer at path, whose compressed blob is expected to have digest.
+// When the repository already has that blob, the layer is neither compressed nor uploaded.
+func (i *Image) AddLayerWithDiffIDAndDigest(path, diffID, digest string) error {
+	added, err := i.addExistingLayer(path, diffID, digest)
+	if err != nil || added {
+		return err
+	}
+	return i.AddLayer(path)
+}
+
+// addLayerWithKnownDiffID adds the uncompressed layer at path, reusing its blob when its digest is cached and the
+// repository has the blob. Otherwise the layer is compressed on Save, without hashing the file again.
+func (i *Image) addLayerWithKnownDiffID(path, diffID string) error {
+	if added, err := i.addCachedLayer(path, diffID); err != nil || added {
+		return err
+	}
+	diffIDHash, err := v1.NewHash(diffID)
+	if err != nil {
+		return errors.Wrapf(err, "parse diff id '%s'", diffID)
+	}
+	layer, err := partial.UncompressedToLayer(&fileLayer{path: path, diffID: diffIDHash, mediaType: i.layerMediaType()})
+	if err != nil {
+		return err
+	}
+	if i.image, err = mutate.AppendLayers(i.image, layer); err != nil {
+		return errors.Wrap(err, "add layer")
+	}
+	return nil
+}
+
+// addCachedLayer adds the layer at path when the digest of its blob is cached and the repository has the blob.
+func (i *Image) addCachedLayer(path, diffID string) (bool, error) {
+	if i.digestCache == nil {
+		return false, nil
+	}
+	digest, ok := i.digestCache.Digest(diffID)
+	if !ok {
+		return false, nil
+	}
+	return i.addExistingLayer(path, diffID, digest)
+}
+
+func (i *Image) addExistingLayer(path, diffID, digest string) (bool, error) {
+	diffIDHash, err := v1.NewHash(diffID)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse diff id '%s'", diffID)
+	}
+	digestHash, err := v1.NewHash(digest)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse digest '%s'", digest)
+	}
+
+	size, found, err := i.blobSize(digestHash)
+	if err != nil || !found {
+		reason := fmt.Sprintf("blob '%s' not found", digest)
+		if err != nil {
+			reason = err.Error()
+		}
+		i.logger.Debug(imgutil.EventFallback, "source", "existing blob", "image", i.repoName, "reason", reason)
+		return false, nil
+	}
+
+	layer := &existingLayer{path: path, diffID: diffIDHash, digest: digestHash, size: size, mediaType: i.layerMediaType()}
+	if i.image, err = mutate.AppendLayers(i.image, layer); err != nil {
+		return false, err
+	}
+	i.logger.Debug(imgutil.EventLayerReused, "image", i.repoName, "diffID", diffID, "digest", digest)
+	return true, nil
+}
+
+// blobSize returns the size of the blob with digest in the repository of the image. The registry client is kept
+// until the next Save, so that the layers added in between share its credentials.
+func (i *Image) blobSize(digest v1.Hash) (int64, bool, error) {
+	ref, _, err := i.referenceForRepoName(i.repoName)
+	if err != nil {
+		return 0, false, err
+	}
+	if i.blobClient == nil {
+		if i.blobClient, err = i.registryClient(ref.Context(), transport.PullScope); err != nil {
+			return 0, false, err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodHead, registryURL(ref.Context(), "blobs/"+digest.String()), nil)
+	if err != nil {
+		return 0, false, err
+	}
+	resp, err := i.blobClient.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, resp.ContentLength >= 0, nil
+	case http.StatusNotFound:
+		return 0, false, nil
+	default:
+		return 0, false, transport.CheckError(resp, http.StatusOK)
+	}
+}
+
+// recordLayerDigests adds the digests of the layers of the saved image to the digest cache.
+func (i *Image) recordLayerDigests() error {
+	if i.digestCache == nil {
+		return nil
+	}
+	layers, err := i.image.Layers()
+	if err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return err
+		}
+		digest, err := layer.Digest()
+		if err != nil {
+			return err
+		}
+		i.digestCache.SetDigest(diffID.String(), digest.String())
+	}
+	return nil
+}
+
+// fileLayer is an uncompressed layer tar whose diff id is known.
+type fileLayer struct {
+	path      string
+	diffID    v1.Hash
+	mediaType types.MediaType
+}
+
+func (l *fileLayer) DiffID() (v1.Hash, error)            { return l.diffID, nil }
+func (l *fileLayer) MediaType() (types.MediaType, error) { return l.mediaType, nil }
+func (l *fileLayer) Uncompressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+// existingLayer is a layer whose compressed blob already exists in the registry. The file at path is only compressed
+// if the blob has to be uploaded to another repository.
+type existingLayer struct {
+	path      string
+	diffID    v1.Hash
+	digest    v1.Hash
+	size      int64
+	mediaType types.MediaType
+
+	once       sync.Once
+	compressed v1.Layer
+	err        error
+}
+
+func (l *existingLayer) Digest() (v1.Hash, error)            { return l.digest, nil }
+func (l *existingLayer) DiffID() (v1.Hash, error)            { return l.diffID, nil }
+func (l *existingLayer) Size() (int64, error)                { return l.size, nil }
+func (l *existingLayer) MediaType() (types.MediaType, error) { return l.mediaType, nil }
+func (l *existingLayer) Uncompressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+func (l *existingLayer) Compressed() (io.ReadCloser, error) {
+	l.once.Do(func() {
+		l.compressed, l.err = tarball.LayerFromFile(l.path)
+		if l.err != nil {
+			return
+		}
+		digest, err := l.compressed.Digest()
+		if err != nil {
+			l.err = err
+			return
+		}
+		if digest != l.digest {
+			l.err = fmt.Errorf("compressed layer '%s' has digest '%s', expected '%s'", l.path, digest, l.digest)
+		}
+	})
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.compressed.Compressed()
+}
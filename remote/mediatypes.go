@@ -0,0 +1,82 @@
+package remote
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+
+	"github.com/buildpacks/imgutil"
+)
+
+// equivalent media types of image manifests, configs and layers, by the requested media types
+var (
+	ociMediaTypes = map[types.MediaType]types.MediaType{
+		types.DockerManifestSchema2:   types.OCIManifestSchema1,
+		types.DockerConfigJSON:        types.OCIConfigJSON,
+		types.DockerLayer:             types.OCILayer,
+		types.DockerForeignLayer:      types.OCIRestrictedLayer,
+		types.DockerUncompressedLayer: types.OCIUncompressedLayer,
+	}
+	dockerMediaTypes = map[types.MediaType]types.MediaType{
+		types.OCIManifestSchema1:   types.DockerManifestSchema2,
+		types.OCIConfigJSON:        types.DockerConfigJSON,
+		types.OCILayer:             types.DockerLayer,
+		types.OCIRestrictedLayer:   types.DockerForeignLayer,
+		types.OCIUncompressedLayer: types.DockerUncompressedLayer,
+	}
+)
+
+// convertMediaTypes returns image with the requested media types for its manifest, config and layers. The config and
+// layer blobs are unchanged: only the descriptors in the manifest refer to them with other media types.
+func convertMediaTypes(image v1.Image, requested imgutil.MediaTypes) (v1.Image, error) {
+	var equivalents map[types.MediaType]types.MediaType
+	manifestType := types.OCIManifestSchema1
+	switch requested {
+	case imgutil.DefaultTypes:
+		return image, nil
+	case imgutil.OCITypes:
+		equivalents = ociMediaTypes
+	case imgutil.DockerTypes:
+		equivalents = dockerMediaTypes
+		manifestType = types.DockerManifestSchema2
+	default:
+		return nil, fmt.Errorf("unknown media types %d", requested)
+	}
+
+	convert := func(mediaType types.MediaType) (types.MediaType, error) {
+		if converted, ok := equivalents[mediaType]; ok {
+			return converted, nil
+		}
+		for _, converted := range equivalents {
+			if mediaType == converted {
+				return mediaType, nil
+			}
+		}
+		return "", fmt.Errorf("media type '%s' has no equivalent in the requested media types", mediaType)
+	}
+
+	return editManifest(image, func(manifest *v1.Manifest) error {
+		manifest.MediaType = manifestType
+		var err error
+		if manifest.Config.MediaType, err = convert(manifest.Config.MediaType); err != nil {
+			return err
+		}
+		for i := range manifest.Layers {
+			if manifest.Layers[i].MediaType, err = convert(manifest.Layers[i].MediaType); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// mediaTypeLayer is a layer with another media type, e.g. the OCI media type of a Docker layer. Its blobs are unchanged.
+type mediaTypeLayer struct {
+	v1.Layer
+	mediaType types.MediaType
+}
+
+func (l *mediaTypeLayer) MediaType() (types.MediaType, error) {
+	return l.mediaType, nil
+}
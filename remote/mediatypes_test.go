@@ -0,0 +1,180 @@
+package remote_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/remote"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestMediaTypes(t *testing.T) {
+	spec.Run(t, "MediaTypes", testMediaTypes, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testMediaTypes(t *testing.T, when spec.G, it spec.S) {
+	var (
+		registry  *h.InMemoryRegistry
+		layerPath string
+	)
+
+	it.Before(func() {
+		registry = h.NewInMemoryRegistry()
+		registry.Start(t)
+
+		var err error
+		layerPath, err = h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		registry.Stop(t)
+		h.AssertNil(t, os.Remove(layerPath))
+	})
+
+	savedManifest := func(repoName string) (types.MediaType, *v1.Manifest) {
+		t.Helper()
+		ref, err := name.ParseReference(repoName, name.WeakValidation)
+		h.AssertNil(t, err)
+		desc, err := ggcrremote.Get(ref)
+		h.AssertNil(t, err)
+		manifest, err := v1.ParseManifest(strings.NewReader(string(desc.Manifest)))
+		h.AssertNil(t, err)
+		return desc.MediaType, manifest
+	}
+
+	assertMediaTypes := func(repoName string, manifestType, configType, layerType types.MediaType) {
+		t.Helper()
+		mediaType, manifest := savedManifest(repoName)
+		h.AssertEq(t, mediaType, manifestType)
+		h.AssertEq(t, manifest.MediaType, manifestType)
+		h.AssertEq(t, manifest.Config.MediaType, configType)
+		for _, l := range manifest.Layers {
+			h.AssertEq(t, l.MediaType, layerType)
+		}
+	}
+
+	manifestSize := func(repoName string) int64 {
+		t.Helper()
+		ref, err := name.ParseReference(repoName, name.WeakValidation)
+		h.AssertNil(t, err)
+		desc, err := ggcrremote.Get(ref)
+		h.AssertNil(t, err)
+		return desc.Size
+	}
+
+	saveImage := func(repoName string, ops ...remote.ImageOption) *remote.Image {
+		t.Helper()
+		img, err := remote.NewImage(repoName, authn.DefaultKeychain, ops...)
+		h.AssertNil(t, err)
+		h.AssertNil(t, img.AddLayer(layerPath))
+		h.AssertNil(t, img.Save())
+		return img
+	}
+
+	when("#WithMediaTypes", func() {
+		it("saves new images with Docker media types by default", func() {
+			repoName := registry.RepoName("some-image")
+			saveImage(repoName)
+
+			assertMediaTypes(repoName, types.DockerManifestSchema2, types.DockerConfigJSON, types.DockerLayer)
+		})
+
+		it("saves images with OCI media types", func() {
+			repoName := registry.RepoName("some-image")
+			saveImage(repoName, remote.WithMediaTypes(imgutil.OCITypes))
+
+			assertMediaTypes(repoName, types.OCIManifestSchema1, types.OCIConfigJSON, types.OCILayer)
+		})
+
+		it("converts the media types of the base image", func() {
+			baseName := registry.RepoName("some-base")
+			saveImage(baseName, remote.WithMediaTypes(imgutil.OCITypes))
+
+			repoName := registry.RepoName("some-image")
+			img := saveImage(repoName, remote.FromBaseImage(baseName), remote.WithMediaTypes(imgutil.DockerTypes))
+
+			assertMediaTypes(repoName, types.DockerManifestSchema2, types.DockerConfigJSON, types.DockerLayer)
+			_, manifest := savedManifest(repoName)
+			h.AssertEq(t, len(manifest.Layers), 2)
+
+			identifier, err := img.Identifier()
+			h.AssertNil(t, err)
+			ref, err := name.ParseReference(repoName, name.WeakValidation)
+			h.AssertNil(t, err)
+			desc, err := ggcrremote.Get(ref)
+			h.AssertNil(t, err)
+			h.AssertEq(t, identifier.String(), ref.Context().Name()+"@"+desc.Digest.String())
+		})
+
+		it("keeps the media types of the base image with imgutil.DefaultTypes", func() {
+			baseName := registry.RepoName("some-base")
+			saveImage(baseName, remote.WithMediaTypes(imgutil.OCITypes))
+
+			repoName := registry.RepoName("some-image")
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseName), remote.WithMediaTypes(imgutil.DefaultTypes))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("some-key", "some-value"))
+			h.AssertNil(t, img.Save())
+
+			assertMediaTypes(repoName, types.OCIManifestSchema1, types.OCIConfigJSON, types.OCILayer)
+		})
+
+		it("adds layers with the media types of the base image", func() {
+			baseName := registry.RepoName("some-base")
+			saveImage(baseName, remote.WithMediaTypes(imgutil.OCITypes))
+
+			repoName := registry.RepoName("some-image")
+			saveImage(repoName, remote.FromBaseImage(baseName))
+
+			assertMediaTypes(repoName, types.OCIManifestSchema1, types.OCIConfigJSON, types.OCILayer)
+			_, manifest := savedManifest(repoName)
+			h.AssertEq(t, len(manifest.Layers), 2)
+		})
+
+		it("saves the image again after it is changed", func() {
+			repoName := registry.RepoName("some-image")
+			img := saveImage(repoName, remote.WithMediaTypes(imgutil.OCITypes))
+
+			h.AssertNil(t, img.SetLabel("some-key", "some-value"))
+			h.AssertNil(t, img.AddLayer(layerPath))
+			h.AssertNil(t, img.Save())
+
+			assertMediaTypes(repoName, types.OCIManifestSchema1, types.OCIConfigJSON, types.OCILayer)
+			_, manifest := savedManifest(repoName)
+			h.AssertEq(t, len(manifest.Layers), 2)
+
+			saved, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			label, err := saved.Label("some-key")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+
+			size, err := img.ManifestSize()
+			h.AssertNil(t, err)
+			h.AssertEq(t, size, manifestSize(repoName))
+		})
+
+		it("keeps annotations", func() {
+			repoName := registry.RepoName("some-image")
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithMediaTypes(imgutil.OCITypes))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetAnnotation("some-key", "some-value"))
+			h.AssertNil(t, img.Save())
+
+			_, manifest := savedManifest(repoName)
+			h.AssertEq(t, manifest.MediaType, types.OCIManifestSchema1)
+			h.AssertEq(t, manifest.Annotations["some-key"], "some-value")
+		})
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -24,16 +25,38 @@ import (
 )
 
 type Image struct {
-	keychain   authn.Keychain
-	repoName   string
-	image      v1.Image
-	prevLayers []v1.Layer
+	keychain           authn.Keychain
+	repoName           string
+	image              v1.Image
+	prevLayers         []v1.Layer
+	sourceLayers       []v1.Layer
+	digestCache        LayerDigestCache
+	logger             imgutil.Logger
+	metrics            imgutil.Metrics
+	transport          http.RoundTripper
+	insecureRegistries map[string]bool
+	mirrors            map[string]string
+	annotations        map[string]string
+	mediaTypes         imgutil.MediaTypes
+	// savedManifest describes the manifest last saved under the name of the image
+	savedManifest *ociDescriptor
+	// blobClient checks the blobs of the repository of the image for the layers added before the next Save
+	blobClient *http.Client
 }
 
 type options struct {
-	platform          imgutil.Platform
-	baseImageRepoName string
-	prevImageRepoName string
+	platform           imgutil.Platform
+	baseImageRepoName  string
+	prevImageRepoName  string
+	layerSourceNames   []string
+	digestCache        LayerDigestCache
+	logger             imgutil.Logger
+	metrics            imgutil.Metrics
+	transport          http.RoundTripper
+	caBundles          [][]byte
+	insecureRegistries map[string]bool
+	mirrors            map[string]string
+	mediaTypes         imgutil.MediaTypes
 }
 
 type ImageOption func(*options) error
@@ -48,6 +71,25 @@ func WithPreviousImage(imageName string) ImageOption {
 	}
 }
 
+//WithLayerSources loads existing images whose layers can be reused, in addition to the previous image.
+//Use with ReuseLayer(). Reused layers are mounted from the repository of their source when it is on the same registry.
+//Images that are not found are ignored.
+func WithLayerSources(imageNames ...string) ImageOption {
+	return func(opts *options) error {
+		opts.layerSourceNames = append(opts.layerSourceNames, imageNames...)
+		return nil
+	}
+}
+
+//WithLayerDigestCache looks up the digests of the compressed blobs of added layers in cache, and records the digests
+//of the layers of saved images. Added layers whose blob already exists in the repository are neither compressed nor uploaded.
+func WithLayerDigestCache(cache LayerDigestCache) ImageOption {
+	return func(opts *options) error {
+		opts.digestCache = cache
+		return nil
+	}
+}
+
 //FromBaseImage loads an existing image as the config and layers for the new image.
 //Ignored if image is not found.
 func FromBaseImage(imageName string) ImageOption {
@@ -67,15 +109,100 @@ func WithDefaultPlatform(platform imgutil.Platform) ImageOption {
 	}
 }
 
+//WithLogger reports diagnostic events, such as base image resolution and bytes pushed, to the given logger.
+//A nil logger discards the events.
+func WithLogger(logger imgutil.Logger) ImageOption {
+	return func(opts *options) error {
+		if logger == nil {
+			logger = imgutil.NopLogger
+		}
+		opts.logger = logger
+		return nil
+	}
+}
+
+//WithMetrics reports measurements, such as bytes uploaded and manifest upload latency, to the given metrics.
+//Nil metrics discard the measurements.
+func WithMetrics(metrics imgutil.Metrics) ImageOption {
+	return func(opts *options) error {
+		if metrics == nil {
+			metrics = imgutil.NopMetrics
+		}
+		opts.metrics = metrics
+		return nil
+	}
+}
+
+//WithTransport sets the http.RoundTripper used to communicate with registries.
+//Defaults to http.DefaultTransport.
+//The transport must be an *http.Transport when combined with WithInsecureRegistry or WithRegistryCA,
+//which change its TLS configuration.
+func WithTransport(transport http.RoundTripper) ImageOption {
+	return func(opts *options) error {
+		opts.transport = transport
+		return nil
+	}
+}
+
+//WithInsecureRegistry allows communicating with the given registry host over plain HTTP,
+//or over TLS without verifying its certificate.
+//NewImage returns an error when the transport set with WithTransport is not an *http.Transport.
+func WithInsecureRegistry(host string) ImageOption {
+	return func(opts *options) error {
+		opts.insecureRegistries[host] = true
+		return nil
+	}
+}
+
+//WithRegistryCA trusts the PEM-encoded certificates in caBundle, in addition to the system certificates,
+//when verifying registries.
+//NewImage returns an error when the transport set with WithTransport is not an *http.Transport.
+func WithRegistryCA(caBundle []byte) ImageOption {
+	return func(opts *options) error {
+		opts.caBundles = append(opts.caBundles, caBundle)
+		return nil
+	}
+}
+
+//WithRegistryMirror reads base and previous images hosted on registry from mirror instead.
+//Images are read from registry when they cannot be read from the mirror, and are always saved to registry.
+func WithRegistryMirror(registry, mirror string) ImageOption {
+	return func(opts *options) error {
+		opts.mirrors[registry] = mirror
+		return nil
+	}
+}
+
+//WithMediaTypes saves the image with the OCI or Docker media types for its manifest, config and layers,
+//converting the media types of the base image and of added layers as needed.
+//Defaults to imgutil.DefaultTypes, which keeps the media types of the base image.
+func WithMediaTypes(requested imgutil.MediaTypes) ImageOption {
+	return func(opts *options) error {
+		opts.mediaTypes = requested
+		return nil
+	}
+}
+
 //NewImage returns a new Image that can be modified and saved to a Docker daemon.
 func NewImage(repoName string, keychain authn.Keychain, ops ...ImageOption) (*Image, error) {
-	imageOpts := &options{}
+	imageOpts := &options{
+		logger:             imgutil.NopLogger,
+		metrics:            imgutil.NopMetrics,
+		transport:          http.DefaultTransport,
+		insecureRegistries: map[string]bool{},
+		mirrors:            map[string]string{},
+	}
 	for _, op := range ops {
 		if err := op(imageOpts); err != nil {
 			return nil, err
 		}
 	}
 
+	transport, err := newRegistryTransport(imageOpts.transport, imageOpts.caBundles, imageOpts.insecureRegistries)
+	if err != nil {
+		return nil, err
+	}
+
 	platform := defaultPlatform()
 	if (imageOpts.platform != imgutil.Platform{}) {
 		platform = imageOpts.platform
@@ -87,9 +214,17 @@ func NewImage(repoName string, keychain authn.Keychain, ops ...ImageOption) (*Im
 	}
 
 	ri := &Image{
-		keychain: keychain,
-		repoName: repoName,
-		image:    image,
+		keychain:           keychain,
+		repoName:           repoName,
+		image:              image,
+		logger:             imageOpts.logger,
+		metrics:            imageOpts.metrics,
+		transport:          transport,
+		insecureRegistries: imageOpts.insecureRegistries,
+		mirrors:            imageOpts.mirrors,
+		digestCache:        imageOpts.digestCache,
+		annotations:        map[string]string{},
+		mediaTypes:         imageOpts.mediaTypes,
 	}
 
 	if imageOpts.prevImageRepoName != "" {
@@ -98,6 +233,12 @@ func NewImage(repoName string, keychain authn.Keychain, ops ...ImageOption) (*Im
 		}
 	}
 
+	for _, sourceName := range imageOpts.layerSourceNames {
+		if err := processLayerSourceOption(ri, sourceName, platform); err != nil {
+			return nil, err
+		}
+	}
+
 	if imageOpts.baseImageRepoName != "" {
 		if err := processBaseImageOption(ri, imageOpts.baseImageRepoName, platform); err != nil {
 			return nil, err
@@ -118,7 +259,7 @@ func NewImage(repoName string, keychain authn.Keychain, ops ...ImageOption) (*Im
 }
 
 func processPreviousImageOption(ri *Image, prevImageRepoName string, platform imgutil.Platform) error {
-	prevImage, err := newV1Image(ri.keychain, prevImageRepoName, platform)
+	prevImage, err := ri.newV1Image(prevImageRepoName, platform, "previous")
 	if err != nil {
 		return err
 	}
@@ -133,8 +274,24 @@ func processPreviousImageOption(ri *Image, prevImageRepoName string, platform im
 	return nil
 }
 
+func processLayerSourceOption(ri *Image, sourceName string, platform imgutil.Platform) error {
+	sourceImage, err := ri.newV1Image(sourceName, platform, "layer source")
+	if err != nil {
+		return err
+	}
+
+	sourceLayers, err := sourceImage.Layers()
+	if err != nil {
+		return errors.Wrapf(err, "failed to get layers for layer source with repo name '%s'", sourceName)
+	}
+
+	ri.sourceLayers = append(ri.sourceLayers, sourceLayers...)
+
+	return nil
+}
+
 func processBaseImageOption(ri *Image, baseImageRepoName string, platform imgutil.Platform) error {
-	baseImage, err := newV1Image(ri.keychain, baseImageRepoName, platform)
+	baseImage, err := ri.newV1Image(baseImageRepoName, platform, "base")
 	if err != nil {
 		return err
 	}
@@ -174,8 +331,8 @@ func prepareNewWindowsImage(ri *Image) error {
 	return nil
 }
 
-func newV1Image(keychain authn.Keychain, repoName string, platform imgutil.Platform) (v1.Image, error) {
-	ref, auth, err := referenceForRepoName(keychain, repoName)
+func (i *Image) newV1Image(repoName string, platform imgutil.Platform, source string) (v1.Image, error) {
+	ref, auth, err := i.referenceForRepoName(repoName)
 	if err != nil {
 		return nil, err
 	}
@@ -186,23 +343,62 @@ func newV1Image(keychain authn.Keychain, repoName string, platform imgutil.Platf
 		OSVersion:    platform.OSVersion,
 	}
 
-	image, err := remote.Image(ref, remote.WithAuth(auth), remote.WithTransport(http.DefaultTransport), remote.WithPlatform(v1Platform))
+	if image, ok := i.mirrorV1Image(ref, v1Platform, source); ok {
+		return image, nil
+	}
+
+	image, err := remote.Image(ref, remote.WithAuth(auth), remote.WithTransport(i.transport), remote.WithPlatform(v1Platform))
 	if err != nil {
 		if transportErr, ok := err.(*transport.Error); ok && len(transportErr.Errors) > 0 {
 			switch transportErr.StatusCode {
 			case http.StatusNotFound, http.StatusUnauthorized:
+				i.logger.Debug(imgutil.EventFallback, "source", source, "image", repoName, "reason", err.Error())
 				return emptyImage(platform)
 			}
 		}
 		if strings.Contains(err.Error(), "no child with platform") {
+			i.logger.Debug(imgutil.EventFallback, "source", source, "image", repoName, "reason", err.Error())
 			return emptyImage(platform)
 		}
 		return nil, fmt.Errorf("connect to repo store '%s': %s", repoName, err.Error())
 	}
 
+	if digest, err := image.Digest(); err == nil {
+		i.logger.Debug(imgutil.EventBaseResolved, "source", source, "image", repoName, "digest", digest.String())
+	}
+
 	return image, nil
 }
 
+// mirrorV1Image reads ref from the mirror configured for its registry, if any.
+func (i *Image) mirrorV1Image(ref name.Reference, platform v1.Platform, source string) (v1.Image, bool) {
+	mirror, ok := i.mirrors[ref.Context().RegistryStr()]
+	if !ok {
+		return nil, false
+	}
+
+	mirrorRepoName := mirror + "/" + ref.Context().RepositoryStr() + referenceSuffix(ref)
+	mirrorRef, auth, err := i.referenceForRepoName(mirrorRepoName)
+	if err == nil {
+		var image v1.Image
+		image, err = remote.Image(mirrorRef, remote.WithAuth(auth), remote.WithTransport(i.transport), remote.WithPlatform(platform))
+		if err == nil {
+			i.logger.Debug(imgutil.EventBaseResolved, "source", source, "image", ref.Name(), "mirror", mirrorRepoName)
+			return image, true
+		}
+	}
+
+	i.logger.Debug(imgutil.EventFallback, "source", source, "image", ref.Name(), "mirror", mirrorRepoName, "reason", err.Error())
+	return nil, false
+}
+
+func referenceSuffix(ref name.Reference) string {
+	if _, ok := ref.(name.Digest); ok {
+		return "@" + ref.Identifier()
+	}
+	return ":" + ref.Identifier()
+}
+
 func emptyImage(platform imgutil.Platform) (v1.Image, error) {
 	cfg := &v1.ConfigFile{
 		Architecture: platform.Architecture,
@@ -224,13 +420,23 @@ func defaultPlatform() imgutil.Platform {
 	}
 }
 
-func referenceForRepoName(keychain authn.Keychain, ref string) (name.Reference, authn.Authenticator, error) {
+func (i *Image) referenceForRepoName(ref string) (name.Reference, authn.Authenticator, error) {
+	return referenceForRepoName(i.keychain, ref, i.insecureRegistries)
+}
+
+func referenceForRepoName(keychain authn.Keychain, ref string, insecureRegistries map[string]bool) (name.Reference, authn.Authenticator, error) {
 	var auth authn.Authenticator
 	r, err := name.ParseReference(ref, name.WeakValidation)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	if insecureRegistries[r.Context().RegistryStr()] {
+		if r, err = name.ParseReference(ref, name.WeakValidation, name.Insecure); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	auth, err = keychain.Resolve(r.Context().Registry)
 	if err != nil {
 		return nil, nil, err
@@ -252,7 +458,11 @@ func (i *Image) Labels() (map[string]string, error) {
 	if err != nil || cfg == nil {
 		return nil, fmt.Errorf("failed to get config file for image '%s'", i.repoName)
 	}
-	return cfg.Config.Labels, nil
+	copiedLabels := make(map[string]string)
+	for k, v := range cfg.Config.Labels {
+		copiedLabels[k] = v
+	}
+	return copiedLabels, nil
 }
 
 func (i *Image) Env(key string) (string, error) {
@@ -269,6 +479,14 @@ func (i *Image) Env(key string) (string, error) {
 	return "", nil
 }
 
+func (i *Image) EnvVars() ([]string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil {
+		return nil, fmt.Errorf("failed to get config file for image '%s'", i.repoName)
+	}
+	return append([]string{}, cfg.Config.Env...), nil
+}
+
 func (i *Image) Entrypoint() ([]string, error) {
 	cfg, err := i.image.ConfigFile()
 	if err != nil || cfg == nil {
@@ -277,6 +495,30 @@ func (i *Image) Entrypoint() ([]string, error) {
 	return cfg.Config.Entrypoint, nil
 }
 
+func (i *Image) Cmd() ([]string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil {
+		return nil, fmt.Errorf("failed to get config file for image '%s'", i.repoName)
+	}
+	return cfg.Config.Cmd, nil
+}
+
+func (i *Image) Layers() ([]string, error) {
+	layers, err := i.image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	diffIDs := make([]string, len(layers))
+	for idx, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return nil, err
+		}
+		diffIDs[idx] = diffID.String()
+	}
+	return diffIDs, nil
+}
+
 func (i *Image) OS() (string, error) {
 	cfg, err := i.image.ConfigFile()
 	if err != nil || cfg == nil || cfg.OS == "" {
@@ -303,6 +545,7 @@ func (i *Image) Architecture() (string, error) {
 
 func (i *Image) Rename(name string) {
 	i.repoName = name
+	i.blobClient = nil
 }
 
 func (i *Image) Name() string {
@@ -310,11 +553,11 @@ func (i *Image) Name() string {
 }
 
 func (i *Image) Found() bool {
-	ref, auth, err := referenceForRepoName(i.keychain, i.repoName)
+	ref, auth, err := i.referenceForRepoName(i.repoName)
 	if err != nil {
 		return false
 	}
-	_, err = remote.Head(ref, remote.WithAuth(auth), remote.WithTransport(http.DefaultTransport))
+	_, err = remote.Head(ref, remote.WithAuth(auth), remote.WithTransport(i.transport))
 	return err == nil
 }
 
@@ -324,7 +567,11 @@ func (i *Image) Identifier() (imgutil.Identifier, error) {
 		return nil, fmt.Errorf("failed to parse reference for image '%s': %s", i.repoName, err)
 	}
 
-	hash, err := i.image.Digest()
+	image, err := i.savedImage()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := image.Digest()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get digest for image '%s': %s", i.repoName, err)
 	}
@@ -529,10 +776,21 @@ func (i *Image) GetLayer(sha string) (io.ReadCloser, error) {
 }
 
 func (i *Image) AddLayer(path string) error {
+	if i.digestCache != nil {
+		diffID, err := fileDiffID(path)
+		if err != nil {
+			return err
+		}
+		return i.addLayerWithKnownDiffID(path, diffID)
+	}
+
 	layer, err := tarball.LayerFromFile(path)
 	if err != nil {
 		return err
 	}
+	if mediaType := i.layerMediaType(); mediaType != types.DockerLayer {
+		layer = &mediaTypeLayer{Layer: layer, mediaType: mediaType}
+	}
 	i.image, err = mutate.AppendLayers(i.image, layer)
 	if err != nil {
 		return errors.Wrap(err, "add layer")
@@ -540,19 +798,54 @@ func (i *Image) AddLayer(path string) error {
 	return nil
 }
 
+// layerMediaType returns the media type of the layers added to the image: OCI layers for OCI images, and Docker
+// layers otherwise.
+func (i *Image) layerMediaType() types.MediaType {
+	if mediaType, err := i.image.MediaType(); err == nil && mediaType == types.OCIManifestSchema1 {
+		return types.OCILayer
+	}
+	return types.DockerLayer
+}
+
 func (i *Image) AddLayerWithDiffID(path, diffID string) error {
-	// this is equivalent to AddLayer in the remote case
+	// this is equivalent to AddLayer in the remote case, unless the digest of the layer is cached
 	// it exists to provide optimize performance for local images
+	if i.digestCache != nil {
+		return i.addLayerWithKnownDiffID(path, diffID)
+	}
 	return i.AddLayer(path)
 }
 
+func fileDiffID(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "open layer '%s'", path)
+	}
+	defer f.Close()
+
+	hash, _, err := v1.SHA256(f)
+	if err != nil {
+		return "", errors.Wrapf(err, "hash layer '%s'", path)
+	}
+	return hash.String(), nil
+}
+
 func (i *Image) ReuseLayer(sha string) error {
 	layer, err := findLayerWithSha(i.prevLayers, sha)
 	if err != nil {
-		return err
+		if len(i.sourceLayers) == 0 {
+			return err
+		}
+		if layer, err = findLayerWithSha(i.sourceLayers, sha); err != nil {
+			return fmt.Errorf(`neither the previous image nor the layer sources had layer with diff id '%s'`, sha)
+		}
 	}
 	i.image, err = mutate.AppendLayers(i.image, layer)
-	return err
+	if err != nil {
+		return err
+	}
+	i.logger.Debug(imgutil.EventLayerReused, "image", i.repoName, "diffID", sha)
+	return nil
 }
 
 func findLayerWithSha(layers []v1.Layer, diffID string) (v1.Layer, error) {
@@ -602,9 +895,14 @@ func (i *Image) Save(additionalNames ...string) error {
 		return errors.Wrap(err, "zeroing history")
 	}
 
+	image, err := i.savedImage()
+	if err != nil {
+		return err
+	}
+
 	var diagnostics []imgutil.SaveDiagnostic
 	for _, n := range allNames {
-		if err := i.doSave(n); err != nil {
+		if err := i.doSave(image, n); err != nil {
 			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
 		}
 	}
@@ -615,12 +913,71 @@ func (i *Image) Save(additionalNames ...string) error {
 	return nil
 }
 
-func (i *Image) doSave(imageName string) error {
-	ref, auth, err := referenceForRepoName(i.keychain, imageName)
+// savedImage returns the image as it is written by Save, with the requested media types and the annotations. The
+// image itself is left unchanged, so that it can be modified and saved again.
+func (i *Image) savedImage() (v1.Image, error) {
+	image, err := convertMediaTypes(i.image, i.mediaTypes)
+	if err != nil {
+		return nil, errors.Wrap(err, "converting media types")
+	}
+	if len(i.annotations) > 0 {
+		if image, err = annotate(image, i.annotations); err != nil {
+			return nil, errors.Wrap(err, "adding annotations")
+		}
+	}
+	return image, nil
+}
+
+func (i *Image) doSave(image v1.Image, imageName string) error {
+	ref, auth, err := i.referenceForRepoName(imageName)
+	if err != nil {
+		return err
+	}
+	layers, err := image.Layers()
 	if err != nil {
 		return err
 	}
-	return remote.Write(ref, i.image, remote.WithAuth(auth))
+	tr := newInstrumentedTransport(i.transport, i.metrics, mountableBlobSizes(layers))
+	if err := remote.Write(ref, image, remote.WithAuth(auth), remote.WithTransport(tr)); err != nil {
+		return err
+	}
+	if imageName == i.repoName {
+		saved, err := manifestDescriptor(image)
+		if err != nil {
+			return err
+		}
+		i.savedManifest = &saved
+	}
+
+	if err := i.recordLayerDigests(); err != nil {
+		return err
+	}
+	i.blobClient = nil
+	i.metrics.Counter(imgutil.MetricBytesUploaded, float64(tr.Uploaded()))
+	i.metrics.Counter(imgutil.MetricBytesReused, float64(tr.Reused()))
+	i.metrics.Histogram(imgutil.MetricLayers, float64(len(layers)))
+	i.logger.Debug(imgutil.EventBytesPushed, "image", imageName, "bytes", tr.Uploaded(), "reused", tr.Reused())
+	return nil
+}
+
+// mountableBlobSizes returns the sizes of the layers that can be mounted from another repository, by digest.
+func mountableBlobSizes(layers []v1.Layer) map[string]int64 {
+	sizes := map[string]int64{}
+	for _, l := range layers {
+		if _, ok := l.(*remote.MountableLayer); !ok {
+			continue
+		}
+		digest, err := l.Digest()
+		if err != nil {
+			continue
+		}
+		size, err := l.Size()
+		if err != nil {
+			continue
+		}
+		sizes[digest.String()] = size
+	}
+	return sizes
 }
 
 func (i *Image) Delete() error {
@@ -628,15 +985,19 @@ func (i *Image) Delete() error {
 	if err != nil {
 		return err
 	}
-	ref, auth, err := referenceForRepoName(i.keychain, id.String())
+	ref, auth, err := i.referenceForRepoName(id.String())
 	if err != nil {
 		return err
 	}
-	return remote.Delete(ref, remote.WithAuth(auth))
+	return remote.Delete(ref, remote.WithAuth(auth), remote.WithTransport(i.transport))
 }
 
 func (i *Image) ManifestSize() (int64, error) {
-	return i.image.Size()
+	image, err := i.savedImage()
+	if err != nil {
+		return 0, err
+	}
+	return image.Size()
 }
 
 type subImage struct {
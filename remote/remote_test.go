@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,11 +16,12 @@ import (
 	"github.com/sclevine/spec/report"
 
 	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/imgutiltest"
 	"github.com/buildpacks/imgutil/remote"
 	h "github.com/buildpacks/imgutil/testhelpers"
 )
 
-var dockerRegistry *h.DockerRegistry
+var dockerRegistry *h.InMemoryRegistry
 
 func newTestImageName(providedPrefix ...string) string {
 	prefix := "pack-image-test"
@@ -35,7 +39,7 @@ func TestRemote(t *testing.T) {
 	h.AssertNil(t, err)
 	defer os.RemoveAll(dockerConfigDir)
 
-	dockerRegistry = h.NewDockerRegistryWithAuth(dockerConfigDir)
+	dockerRegistry = h.NewInMemoryRegistryWithAuth(dockerConfigDir)
 	dockerRegistry.Start(t)
 	defer dockerRegistry.Stop(t)
 
@@ -45,6 +49,38 @@ func TestRemote(t *testing.T) {
 	spec.Run(t, "Image", testImage, spec.Sequential(), spec.Report(report.Terminal{}))
 }
 
+type imageFactory struct {
+	registry *h.InMemoryRegistry
+}
+
+func (f imageFactory) NewImage(repoName string) (imgutil.Image, error) {
+	return remote.NewImage(repoName, authn.DefaultKeychain)
+}
+
+func (f imageFactory) RepoName(name string) string {
+	return f.registry.RepoName(name)
+}
+
+func TestRemoteSuite(t *testing.T) {
+	registry := h.NewInMemoryRegistry()
+	registry.Start(t)
+	defer registry.Stop(t)
+
+	imgutiltest.RunImageSuite(t, imageFactory{registry: registry})
+}
+
+// pingCounter counts the requests checking the registry API version, which start every authentication.
+type pingCounter struct {
+	pings int64
+}
+
+func (c *pingCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Path == "/v2/" {
+		atomic.AddInt64(&c.pings, 1)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
 func testImage(t *testing.T, when spec.G, it spec.S) {
 	var repoName string
 
@@ -486,6 +522,103 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 		})
+
+		when("#WithLogger", func() {
+			it("reports the fallback when the base image does not exist", func() {
+				logger := &h.RecordingLogger{}
+				_, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.FromBaseImage(newTestImageName()),
+					remote.WithLogger(logger),
+				)
+				h.AssertNil(t, err)
+
+				events := logger.EventsNamed(imgutil.EventFallback)
+				h.AssertEq(t, len(events), 1)
+				h.AssertEq(t, events[0].Fields["source"], "base")
+			})
+
+			it("reports the bytes pushed on save", func() {
+				logger := &h.RecordingLogger{}
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithLogger(logger))
+				h.AssertNil(t, err)
+
+				tarPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(tarPath)
+				h.AssertNil(t, img.AddLayer(tarPath))
+
+				h.AssertNil(t, img.Save())
+
+				events := logger.EventsNamed(imgutil.EventBytesPushed)
+				h.AssertEq(t, len(events), 1)
+				h.AssertEq(t, events[0].Fields["image"], repoName)
+				h.AssertEq(t, events[0].Fields["bytes"].(int64) > 0, true)
+			})
+			it("discards the events when the logger is nil", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(newTestImageName()), remote.WithLogger(nil))
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.Save())
+			})
+		})
+
+		when("#WithMetrics", func() {
+			it("reports uploaded and reused bytes, layers and manifest latency", func() {
+				tarPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(tarPath)
+
+				existing, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, existing.AddLayer(tarPath))
+				h.AssertNil(t, existing.Save())
+
+				metrics := h.NewRecordingMetrics()
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithMetrics(metrics))
+				h.AssertNil(t, err)
+				h.AssertNil(t, img.AddLayer(tarPath))
+				h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+
+				h.AssertNil(t, img.Save())
+
+				h.AssertEq(t, metrics.Counters[imgutil.MetricBytesUploaded] > 0, true)
+				h.AssertEq(t, metrics.Counters[imgutil.MetricBytesReused] > 0, true)
+				h.AssertEq(t, metrics.Histograms[imgutil.MetricLayers], []float64{1})
+				h.AssertEq(t, len(metrics.Histograms[imgutil.MetricManifestPutSeconds]), 1)
+			})
+
+			it("reports layers mounted from another repository as reused", func() {
+				tarPath, err := h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(tarPath)
+
+				baseName := newTestImageName()
+				base, err := remote.NewImage(baseName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+				h.AssertNil(t, base.AddLayer(tarPath))
+				h.AssertNil(t, base.Save())
+
+				metrics := h.NewRecordingMetrics()
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.FromBaseImage(baseName), remote.WithMetrics(metrics))
+				h.AssertNil(t, err)
+
+				// the blobs are stored once for all repositories: make them look missing so that they are mounted
+				dockerRegistry.InjectFault(h.Fault{Method: http.MethodHead, Path: "/blobs/", StatusCode: http.StatusNotFound})
+				defer dockerRegistry.ClearFaults()
+				h.AssertNil(t, img.Save())
+
+				h.AssertEq(t, metrics.Counters[imgutil.MetricBytesReused] > 0, true)
+			})
+
+			it("discards the measurements when the metrics are nil", func() {
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithMetrics(nil))
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.Save())
+			})
+		})
 	})
 
 	when("#Entrypoint", func() {
@@ -1129,6 +1262,27 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#Layers", func() {
+		it("returns the diff ids of the layers from the bottom layer", func() {
+			baseLayerPath, err := h.CreateSingleFileLayerTar("/base.txt", "base", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(baseLayerPath)
+
+			topLayerPath, err := h.CreateSingleFileLayerTar("/top-layer.txt", "top-layer", "linux")
+			h.AssertNil(t, err)
+			defer os.Remove(topLayerPath)
+
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.AddLayer(baseLayerPath))
+			h.AssertNil(t, img.AddLayer(topLayerPath))
+
+			layers, err := img.Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, layers, []string{h.FileDiffID(t, baseLayerPath), h.FileDiffID(t, topLayerPath)})
+		})
+	})
+
 	when("#AddLayer", func() {
 		it("appends a layer", func() {
 			existingImage, err := remote.NewImage(
@@ -1213,6 +1367,93 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("#WithLayerDigestCache", func() {
+		var (
+			cache     remote.LayerDigestCache
+			layerPath string
+			diffID    string
+		)
+
+		it.Before(func() {
+			cache = remote.NewLayerDigestCache()
+
+			var err error
+			layerPath, err = h.CreateSingleFileLayerTar("/some-layer.txt", "some-layer", "linux")
+			h.AssertNil(t, err)
+			diffID = h.FileDiffID(t, layerPath)
+
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithLayerDigestCache(cache))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.AddLayer(layerPath))
+			h.AssertNil(t, img.Save())
+		})
+
+		it.After(func() {
+			os.Remove(layerPath)
+		})
+
+		it("records the digests of saved layers", func() {
+			digest, ok := cache.Digest(diffID)
+			h.AssertEq(t, ok, true)
+			h.AssertEq(t, strings.HasPrefix(digest, "sha256:"), true)
+			h.AssertNotEq(t, digest, diffID)
+		})
+
+		it("does not read layers whose blob exists in the repository", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithLayerDigestCache(cache))
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, img.AddLayerWithDiffID("/some/missing/layer.tar", diffID))
+			h.AssertNil(t, img.Save())
+
+			h.AssertEq(t, h.FetchManifestLayers(t, repoName), []string{diffID})
+		})
+
+		when("#AddLayerWithDiffIDAndDigest", func() {
+			it("does not read the layer when its blob exists in the repository", func() {
+				digest, _ := cache.Digest(diffID)
+
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.AddLayerWithDiffIDAndDigest("/some/missing/layer.tar", diffID, digest))
+				h.AssertNil(t, img.Save())
+
+				h.AssertEq(t, h.FetchManifestLayers(t, repoName), []string{diffID})
+			})
+
+			it("uploads the layer when its blob does not exist", func() {
+				img, err := remote.NewImage(newTestImageName(), authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				otherLayerPath, err := h.CreateSingleFileLayerTar("/other-layer.txt", "other-layer", "linux")
+				h.AssertNil(t, err)
+				defer os.Remove(otherLayerPath)
+				otherDiffID := h.FileDiffID(t, otherLayerPath)
+
+				h.AssertNil(t, img.AddLayerWithDiffIDAndDigest(otherLayerPath, otherDiffID, "sha256:"+strings.Repeat("0", 64)))
+				h.AssertNil(t, img.Save())
+
+				h.AssertEq(t, h.FetchManifestLayers(t, img.Name()), []string{otherDiffID})
+			})
+
+			it("authenticates once for the layers added before Save", func() {
+				pings := &pingCounter{}
+				img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithTransport(pings))
+				h.AssertNil(t, err)
+
+				missingDigest := "sha256:" + strings.Repeat("0", 64)
+				h.AssertNil(t, img.AddLayerWithDiffIDAndDigest(layerPath, diffID, missingDigest))
+				authenticated := atomic.LoadInt64(&pings.pings)
+				h.AssertNil(t, img.AddLayerWithDiffIDAndDigest(layerPath, diffID, missingDigest))
+				h.AssertNil(t, img.AddLayerWithDiffIDAndDigest(layerPath, diffID, missingDigest))
+
+				h.AssertEq(t, authenticated > 0, true)
+				h.AssertEq(t, atomic.LoadInt64(&pings.pings), authenticated)
+			})
+		})
+	})
+
 	when("#ReuseLayer", func() {
 		when("previous image", func() {
 			var (
@@ -1290,6 +1531,55 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				h.AssertError(t, err, "previous image did not have layer with diff id 'some-bad-sha'")
 			})
 		})
+
+		when("layer sources", func() {
+			var (
+				sourceName      string
+				sourceLayerPath string
+			)
+
+			it.Before(func() {
+				sourceName = newTestImageName("layer-source")
+				source, err := remote.NewImage(sourceName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				sourceLayerPath, err = h.CreateSingleFileLayerTar("/source.txt", "source-layer", "linux")
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, source.AddLayer(sourceLayerPath))
+				h.AssertNil(t, source.Save())
+			})
+
+			it.After(func() {
+				h.AssertNil(t, os.Remove(sourceLayerPath))
+			})
+
+			it("reuses a layer from any layer source", func() {
+				img, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithLayerSources(newTestImageName("missing-source"), sourceName),
+				)
+				h.AssertNil(t, err)
+
+				h.AssertNil(t, img.ReuseLayer(h.FileDiffID(t, sourceLayerPath)))
+				h.AssertNil(t, img.Save())
+
+				h.AssertEq(t, h.FetchManifestLayers(t, repoName), []string{h.FileDiffID(t, sourceLayerPath)})
+			})
+
+			it("returns error on nonexistent layer", func() {
+				img, err := remote.NewImage(
+					repoName,
+					authn.DefaultKeychain,
+					remote.WithLayerSources(sourceName),
+				)
+				h.AssertNil(t, err)
+
+				err = img.ReuseLayer("some-bad-sha")
+				h.AssertError(t, err, "neither the previous image nor the layer sources had layer with diff id 'some-bad-sha'")
+			})
+		})
 	})
 
 	when("#Save", func() {
@@ -1392,6 +1682,44 @@ func testImage(t *testing.T, when spec.G, it spec.S) {
 				})
 			})
 		})
+
+		when("the registry fails", func() {
+			it.After(func() {
+				dockerRegistry.ClearFaults()
+			})
+
+			it("returns an error when writing the manifest fails", func() {
+				image, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				dockerRegistry.InjectFault(h.Fault{Method: "PUT", Path: "/manifests/", StatusCode: 500})
+
+				err = image.Save()
+				_, ok := err.(imgutil.SaveError)
+				h.AssertEq(t, ok, true)
+				h.AssertError(t, err, "500")
+			})
+
+			it("returns an error when the credentials are rejected", func() {
+				image, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				dockerRegistry.InjectFault(h.Fault{Unauthorized: true})
+
+				h.AssertError(t, image.Save(), "401 Unauthorized")
+			})
+
+			it("waits for slow responses", func() {
+				image, err := remote.NewImage(repoName, authn.DefaultKeychain)
+				h.AssertNil(t, err)
+
+				dockerRegistry.InjectFault(h.Fault{Method: "PUT", Path: "/manifests/", Delay: 100 * time.Millisecond, Times: 1})
+
+				start := time.Now()
+				h.AssertNil(t, image.Save())
+				h.AssertEq(t, time.Since(start) >= 100*time.Millisecond, true)
+			})
+		})
 	})
 
 	when("#Found", func() {
@@ -0,0 +1,136 @@
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+)
+
+// instrumentedTransport counts the bytes uploaded through it and the bytes of blobs that already exist in the
+// registry or are mounted from another repository, and reports the latency of manifest uploads.
+type instrumentedTransport struct {
+	inner    http.RoundTripper
+	metrics  imgutil.Metrics
+	uploaded int64
+	reused   int64
+	// blobSizes holds the sizes of the blobs that can be mounted, by digest, as mount responses do not report them
+	blobSizes map[string]int64
+}
+
+func newInstrumentedTransport(inner http.RoundTripper, metrics imgutil.Metrics, blobSizes map[string]int64) *instrumentedTransport {
+	return &instrumentedTransport{inner: inner, metrics: metrics, blobSizes: blobSizes}
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && (req.Method == http.MethodPut || req.Method == http.MethodPatch || req.Method == http.MethodPost) {
+		req = req.Clone(req.Context())
+		req.Body = &countingReadCloser{ReadCloser: req.Body, count: &t.uploaded}
+	}
+
+	start := time.Now()
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	switch {
+	case req.Method == http.MethodPut && strings.Contains(req.URL.Path, "/manifests/"):
+		t.metrics.Histogram(imgutil.MetricManifestPutSeconds, time.Since(start).Seconds())
+	case req.Method == http.MethodHead && strings.Contains(req.URL.Path, "/blobs/") && resp.StatusCode == http.StatusOK:
+		if resp.ContentLength > 0 {
+			atomic.AddInt64(&t.reused, resp.ContentLength)
+		}
+	case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/blobs/uploads/") && resp.StatusCode == http.StatusCreated:
+		if mount := req.URL.Query().Get("mount"); mount != "" {
+			atomic.AddInt64(&t.reused, t.blobSizes[mount])
+		}
+	}
+	return resp, nil
+}
+
+// Uploaded returns the number of bytes uploaded so far.
+func (t *instrumentedTransport) Uploaded() int64 {
+	return atomic.LoadInt64(&t.uploaded)
+}
+
+// Reused returns the number of bytes of blobs found to already exist in the registry, or mounted, so far.
+func (t *instrumentedTransport) Reused() int64 {
+	return atomic.LoadInt64(&t.reused)
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	count *int64
+}
+
+func (c *countingReadCloser) Read(b []byte) (int, error) {
+	n, err := c.ReadCloser.Read(b)
+	atomic.AddInt64(c.count, int64(n))
+	return n, err
+}
+
+// newRegistryTransport configures base to trust the given PEM-encoded CA bundles, and to skip TLS verification
+// for the given insecure registries.
+func newRegistryTransport(base http.RoundTripper, caBundles [][]byte, insecureRegistries map[string]bool) (http.RoundTripper, error) {
+	if len(caBundles) == 0 && len(insecureRegistries) == 0 {
+		return base, nil
+	}
+
+	httpTransport, ok := base.(*http.Transport)
+	if !ok {
+		return nil, errors.New("custom CA bundles and insecure registries require the transport to be an *http.Transport")
+	}
+
+	secure := httpTransport.Clone()
+	if secure.TLSClientConfig == nil {
+		secure.TLSClientConfig = &tls.Config{}
+	}
+
+	if len(caBundles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, caBundle := range caBundles {
+			if !pool.AppendCertsFromPEM(caBundle) {
+				return nil, errors.New("failed to parse CA bundle: no PEM-encoded certificates found")
+			}
+		}
+		secure.TLSClientConfig.RootCAs = pool
+	}
+
+	if len(insecureRegistries) == 0 {
+		return secure, nil
+	}
+
+	insecure := secure.Clone()
+	insecure.TLSClientConfig.InsecureSkipVerify = true // #nosec G402 -- explicitly requested for these registries
+
+	return &registryRouter{
+		secure:             secure,
+		insecure:           insecure,
+		insecureRegistries: insecureRegistries,
+	}, nil
+}
+
+// registryRouter sends requests for insecure registries through a transport that does not verify certificates.
+type registryRouter struct {
+	secure             http.RoundTripper
+	insecure           http.RoundTripper
+	insecureRegistries map[string]bool
+}
+
+func (r *registryRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if r.insecureRegistries[req.URL.Host] {
+		return r.insecure.RoundTrip(req)
+	}
+	return r.secure.RoundTrip(req)
+}
@@ -0,0 +1,204 @@
+package remote_test
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil/remote"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestTransport(t *testing.T) {
+	spec.Run(t, "Transport", testTransport, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+type countingRoundTripper struct {
+	requests int64
+}
+
+func (c *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&c.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// dialer connects to addr regardless of the address requested.
+type dialer struct {
+	addr string
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, network, d.addr)
+}
+
+func newRegistryHandler() http.Handler {
+	return registry.New(registry.Logger(log.New(ioutil.Discard, "", 0)))
+}
+
+func testTransport(t *testing.T, when spec.G, it spec.S) {
+	var layerPath string
+
+	it.Before(func() {
+		var err error
+		layerPath, err = h.CreateSingleFileLayerTar("/new-layer.txt", "new-layer", "linux")
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.Remove(layerPath))
+	})
+
+	saveImage := func(repoName string, ops ...remote.ImageOption) {
+		img, err := remote.NewImage(repoName, authn.DefaultKeychain, ops...)
+		h.AssertNil(t, err)
+		h.AssertNil(t, img.AddLayer(layerPath))
+		h.AssertNil(t, img.Save())
+	}
+
+	when("#WithTransport", func() {
+		it("uses the transport for all registry requests", func() {
+			registry := h.NewInMemoryRegistry()
+			registry.Start(t)
+			defer registry.Stop(t)
+			repoName := registry.RepoName("some-image")
+
+			rt := &countingRoundTripper{}
+			saveImage(repoName, remote.WithTransport(rt))
+			saved := atomic.LoadInt64(&rt.requests)
+			h.AssertEq(t, saved > 0, true)
+
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithTransport(rt), remote.FromBaseImage(repoName))
+			h.AssertNil(t, err)
+			h.AssertEq(t, img.Found(), true)
+			h.AssertEq(t, atomic.LoadInt64(&rt.requests) > saved, true)
+		})
+	})
+
+	when("registry uses a self-signed certificate", func() {
+		var (
+			server   *httptest.Server
+			repoName string
+		)
+
+		it.Before(func() {
+			server = httptest.NewTLSServer(newRegistryHandler())
+			repoName = "example.com:" + server.URL[strings.LastIndex(server.URL, ":")+1:] + "/some-image"
+		})
+
+		it.After(func() {
+			server.Close()
+		})
+
+		dialServer := func() *http.Transport {
+			tr := http.DefaultTransport.(*http.Transport).Clone()
+			tr.DialContext = (&dialer{addr: server.Listener.Addr().String()}).DialContext
+			return tr
+		}
+
+		it("fails by default", func() {
+			img, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithTransport(dialServer()))
+			h.AssertNil(t, err)
+			h.AssertError(t, img.Save(), "certificate")
+		})
+
+		when("#WithInsecureRegistry", func() {
+			it("does not verify the certificate", func() {
+				host := strings.Split(repoName, "/")[0]
+				saveImage(repoName, remote.WithTransport(dialServer()), remote.WithInsecureRegistry(host))
+			})
+		})
+
+		when("#WithRegistryCA", func() {
+			it("trusts the CA bundle", func() {
+				caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+				saveImage(repoName, remote.WithTransport(dialServer()), remote.WithRegistryCA(caBundle))
+			})
+
+			it("requires the transport to be an *http.Transport", func() {
+				caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+				_, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithTransport(&countingRoundTripper{}), remote.WithRegistryCA(caBundle))
+				h.AssertError(t, err, "require the transport to be an *http.Transport")
+			})
+
+			it("returns an error for an invalid bundle", func() {
+				_, err := remote.NewImage(repoName, authn.DefaultKeychain, remote.WithRegistryCA([]byte("not a certificate")))
+				h.AssertError(t, err, "no PEM-encoded certificates found")
+			})
+		})
+	})
+
+	when("#WithRegistryMirror", func() {
+		var (
+			origin, mirror           *h.InMemoryRegistry
+			originHost, mirrorHost   string
+			originName, mirroredName string
+		)
+
+		it.Before(func() {
+			origin = h.NewInMemoryRegistry()
+			origin.Start(t)
+			mirror = h.NewInMemoryRegistry()
+			mirror.Start(t)
+			originName = origin.RepoName("some-image:latest")
+			mirroredName = mirror.RepoName("some-image:latest")
+			originHost = strings.Split(originName, "/")[0]
+			mirrorHost = strings.Split(mirroredName, "/")[0]
+		})
+
+		it.After(func() {
+			origin.Stop(t)
+			mirror.Stop(t)
+		})
+
+		it("reads base images from the mirror", func() {
+			mirrorImage, err := remote.NewImage(mirroredName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, mirrorImage.SetLabel("from", "mirror"))
+			h.AssertNil(t, mirrorImage.Save())
+
+			img, err := remote.NewImage(
+				originHost+"/other-image",
+				authn.DefaultKeychain,
+				remote.FromBaseImage(originName),
+				remote.WithRegistryMirror(originHost, mirrorHost),
+			)
+			h.AssertNil(t, err)
+
+			label, err := img.Label("from")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "mirror")
+		})
+
+		it("falls back to the registry when the mirror does not have the image", func() {
+			originImage, err := remote.NewImage(originName, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, originImage.SetLabel("from", "origin"))
+			h.AssertNil(t, originImage.Save())
+
+			img, err := remote.NewImage(
+				originHost+"/other-image",
+				authn.DefaultKeychain,
+				remote.FromBaseImage(originName),
+				remote.WithRegistryMirror(originHost, mirrorHost),
+			)
+			h.AssertNil(t, err)
+
+			label, err := img.Label("from")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "origin")
+		})
+	})
+}
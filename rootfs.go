@@ -0,0 +1,206 @@
+package imgutil
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type RootFSOption func(*rootFSOptions)
+
+type rootFSOptions struct {
+	layerPaths []string
+	layersDir  string
+}
+
+// WithLayerPaths adds the files under each of paths, e.g. "/usr" or "/app", as a separate layer, so that changing the
+// files under one path leaves the other layers unchanged. The layers are added in the order of paths, above a layer
+// with the files outside of paths. When paths are nested, files belong to the first path that contains them.
+func WithLayerPaths(paths ...string) RootFSOption {
+	return func(opts *rootFSOptions) {
+		for _, p := range paths {
+			opts.layerPaths = append(opts.layerPaths, path.Clean("/"+p))
+		}
+	}
+}
+
+// WithLayersDir writes the layer tars to dir instead of a new temporary directory.
+func WithLayersDir(dir string) RootFSOption {
+	return func(opts *rootFSOptions) {
+		opts.layersDir = dir
+	}
+}
+
+// FromRootFS adds the filesystem in tarOrDir, a directory or a tar, optionally gzipped, to img and sets the platform of
+// img. img can be an image of any backend, and is usually created without a base image. It returns the paths of the
+// layer tars, which must exist until img is saved. When it fails, the layer tars are removed unless they were written to
+// the directory of WithLayersDir.
+func FromRootFS(img Image, tarOrDir string, platform Platform, ops ...RootFSOption) ([]string, error) {
+	opts := &rootFSOptions{}
+	for _, op := range ops {
+		op(opts)
+	}
+
+	if err := setPlatform(img, platform); err != nil {
+		return nil, err
+	}
+	osType, err := img.OS()
+	if err != nil {
+		return nil, err
+	}
+
+	layersDir := opts.layersDir
+	if layersDir == "" {
+		if layersDir, err = ioutil.TempDir("", "imgutil-rootfs"); err != nil {
+			return nil, err
+		}
+	}
+	layers, err := splitRootFS(tarOrDir, layersDir, osType, opts.layerPaths)
+	if err != nil {
+		if opts.layersDir == "" {
+			os.RemoveAll(layersDir)
+		}
+		return nil, err
+	}
+	layerPaths, err := addLayerTars(img, layers)
+	if err != nil && opts.layersDir == "" {
+		os.RemoveAll(layersDir)
+	}
+	return layerPaths, err
+}
+
+func splitRootFS(tarOrDir, layersDir, osType string, layerPaths []string) ([]layerTar, error) {
+	splitter := newFilesystemSplitter(layersDir, osType)
+	write := func(header *tar.Header, contents io.Reader) error {
+		if !filesystemEntry(header) {
+			return nil
+		}
+		return splitter.write(layerKey{group: pathRuleIndex(layerPaths, header.Name)}, header, contents)
+	}
+
+	info, err := os.Stat(tarOrDir)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		err = walkDir(tarOrDir, write)
+	} else {
+		err = walkTar(tarOrDir, write)
+	}
+	layers, closeErr := splitter.close()
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading root filesystem '%s'", tarOrDir)
+	}
+	return layers, closeErr
+}
+
+func setPlatform(img Image, platform Platform) error {
+	if platform.OS != "" {
+		if err := img.SetOS(platform.OS); err != nil {
+			return err
+		}
+	}
+	if platform.OSVersion != "" {
+		if err := img.SetOSVersion(platform.OSVersion); err != nil {
+			return err
+		}
+	}
+	if platform.Architecture != "" {
+		if err := img.SetArchitecture(platform.Architecture); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pathRuleIndex returns the index of the layer of filePath: 0 for files outside of layerPaths, or 1 + the index of the
+// first path containing filePath.
+func pathRuleIndex(layerPaths []string, filePath string) int {
+	for i, p := range layerPaths {
+		if p == "/" || filePath == p || strings.HasPrefix(filePath, p+"/") {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// walkDir calls write for every file in dir, in lexical order.
+func walkDir(dir string, write func(*tar.Header, io.Reader) error) error {
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = relPath
+
+		if !info.Mode().IsRegular() {
+			return write(header, nil)
+		}
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return write(header, f)
+	})
+}
+
+// walkTar calls write for every entry of the tar at tarPath, which can be gzipped.
+func walkTar(tarPath string, write func(*tar.Header, io.Reader) error) error {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := write(header, tr); err != nil {
+			return err
+		}
+	}
+}
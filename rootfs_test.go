@@ -0,0 +1,199 @@
+package imgutil_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/fakes"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestFromRootFS(t *testing.T) {
+	spec.Run(t, "FromRootFS", testFromRootFS, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+// failingImage fails to add layers, and records the paths of the layers it was given.
+type failingImage struct {
+	*fakes.Image
+	layerPaths []string
+}
+
+func (i *failingImage) AddLayerWithDiffID(path, diffID string) error {
+	i.layerPaths = append(i.layerPaths, path)
+	return errors.New("some-error")
+}
+
+func testFromRootFS(t *testing.T, when spec.G, it spec.S) {
+	var (
+		rootFS    string
+		layersDir string
+	)
+
+	it.Before(func() {
+		var err error
+		rootFS, err = ioutil.TempDir("", "rootfs")
+		h.AssertNil(t, err)
+		layersDir, err = ioutil.TempDir("", "rootfs-layers")
+		h.AssertNil(t, err)
+
+		writeFile := func(name, contents string) {
+			p := filepath.Join(rootFS, filepath.FromSlash(name))
+			h.AssertNil(t, os.MkdirAll(filepath.Dir(p), 0755))
+			h.AssertNil(t, ioutil.WriteFile(p, []byte(contents), 0644))
+		}
+		writeFile("etc/os-release", "some-os")
+		writeFile("usr/lib/libssl.so", "libssl")
+		writeFile("app/main.js", "app")
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.RemoveAll(rootFS))
+		h.AssertNil(t, os.RemoveAll(layersDir))
+	})
+
+	it("adds the filesystem of a directory and sets the platform", func() {
+		img := fakes.NewImage("some-image", "", nil)
+
+		layerPaths, err := imgutil.FromRootFS(img, rootFS, imgutil.Platform{OS: "linux", Architecture: "arm64"}, imgutil.WithLayersDir(layersDir))
+		h.AssertNil(t, err)
+		h.AssertEq(t, len(layerPaths), 1)
+
+		architecture, err := img.Architecture()
+		h.AssertNil(t, err)
+		h.AssertEq(t, architecture, "arm64")
+
+		index, err := imgutil.LayerIndex(img)
+		h.AssertNil(t, err)
+		contents, err := index.ReadFile("/usr/lib/libssl.so")
+		h.AssertNil(t, err)
+		h.AssertEq(t, string(contents), "libssl")
+		dir, ok := index.Find("/usr/lib")
+		h.AssertEq(t, ok, true)
+		h.AssertEq(t, dir.Header.Typeflag, byte(tar.TypeDir))
+	})
+
+	it("splits the filesystem by layer paths", func() {
+		img := fakes.NewImage("some-image", "", nil)
+
+		layerPaths, err := imgutil.FromRootFS(img, rootFS, imgutil.Platform{OS: "linux"},
+			imgutil.WithLayerPaths("/usr", "/app"), imgutil.WithLayersDir(layersDir))
+		h.AssertNil(t, err)
+		h.AssertEq(t, len(layerPaths), 3)
+
+		layers, err := img.Layers()
+		h.AssertNil(t, err)
+		h.AssertEq(t, regularFiles(t, img), map[string]string{
+			"/etc/os-release":    layers[0],
+			"/usr/lib/libssl.so": layers[1],
+			"/app/main.js":       layers[2],
+		})
+		for i, layerPath := range layerPaths {
+			h.AssertEq(t, filepath.Base(layerPath), fmt.Sprintf("layer-%d.tar", i))
+		}
+	})
+
+	it("keeps the diff ids of unchanged layers", func() {
+		firstDir, err := ioutil.TempDir(layersDir, "first")
+		h.AssertNil(t, err)
+		secondDir, err := ioutil.TempDir(layersDir, "second")
+		h.AssertNil(t, err)
+
+		first := fakes.NewImage("some-image", "", nil)
+		_, err = imgutil.FromRootFS(first, rootFS, imgutil.Platform{OS: "linux"},
+			imgutil.WithLayerPaths("/usr", "/app"), imgutil.WithLayersDir(firstDir))
+		h.AssertNil(t, err)
+
+		h.AssertNil(t, ioutil.WriteFile(filepath.Join(rootFS, "app", "main.js"), []byte("new-app"), 0644))
+		second := fakes.NewImage("some-image", "", nil)
+		_, err = imgutil.FromRootFS(second, rootFS, imgutil.Platform{OS: "linux"},
+			imgutil.WithLayerPaths("/usr", "/app"), imgutil.WithLayersDir(secondDir))
+		h.AssertNil(t, err)
+
+		firstLayers, err := first.Layers()
+		h.AssertNil(t, err)
+		secondLayers, err := second.Layers()
+		h.AssertNil(t, err)
+		h.AssertEq(t, secondLayers[:2], firstLayers[:2])
+		h.AssertNotEq(t, secondLayers[2], firstLayers[2])
+	})
+
+	it("adds the filesystem of a gzipped tar", func() {
+		tarPath := filepath.Join(layersDir, "rootfs.tar.gz")
+		f, err := os.Create(tarPath)
+		h.AssertNil(t, err)
+		gw := gzip.NewWriter(f)
+		tw := tar.NewWriter(gw)
+		h.AssertNil(t, tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755}))
+		h.AssertNil(t, tw.WriteHeader(&tar.Header{Name: "./bin/some-exe", Typeflag: tar.TypeReg, Mode: 0755, Size: 8}))
+		_, err = tw.Write([]byte("some-exe"))
+		h.AssertNil(t, err)
+		h.AssertNil(t, tw.WriteHeader(&tar.Header{Name: "./app/some-exe", Typeflag: tar.TypeLink, Linkname: "./bin/some-exe"}))
+		h.AssertNil(t, tw.Close())
+		h.AssertNil(t, gw.Close())
+		h.AssertNil(t, f.Close())
+
+		img := fakes.NewImage("some-image", "", nil)
+		_, err = imgutil.FromRootFS(img, tarPath, imgutil.Platform{OS: "linux"},
+			imgutil.WithLayerPaths("/app"), imgutil.WithLayersDir(layersDir))
+		h.AssertNil(t, err)
+
+		// hardlinks are added to the layer of their target
+		layers, err := img.Layers()
+		h.AssertNil(t, err)
+		h.AssertEq(t, len(layers), 1)
+
+		index, err := imgutil.LayerIndex(img)
+		h.AssertNil(t, err)
+		contents, err := index.ReadFile("/app/some-exe")
+		h.AssertNil(t, err)
+		h.AssertEq(t, string(contents), "some-exe")
+
+		// parent directories missing from the tar are added
+		dir, ok := index.Find("/app")
+		h.AssertEq(t, ok, true)
+		h.AssertEq(t, dir.Header.Typeflag, byte(tar.TypeDir))
+		h.AssertEq(t, dir.Header.Mode, int64(0755))
+	})
+
+	it("removes the layer tars when adding them fails", func() {
+		img := &failingImage{Image: fakes.NewImage("some-image", "", nil)}
+
+		_, err := imgutil.FromRootFS(img, rootFS, imgutil.Platform{OS: "linux"})
+		h.AssertError(t, err, "some-error")
+
+		h.AssertEq(t, len(img.layerPaths), 1)
+		_, err = os.Stat(filepath.Dir(img.layerPaths[0]))
+		h.AssertEq(t, os.IsNotExist(err), true)
+	})
+
+	when("the platform is windows", func() {
+		it("writes Windows layers", func() {
+			img := fakes.NewImage("some-image", "", nil)
+
+			layerPaths, err := imgutil.FromRootFS(img, rootFS, imgutil.Platform{OS: "windows"}, imgutil.WithLayersDir(layersDir))
+			h.AssertNil(t, err)
+
+			f, err := os.Open(layerPaths[0])
+			h.AssertNil(t, err)
+			defer f.Close()
+			header, err := tar.NewReader(f).Next()
+			h.AssertNil(t, err)
+			h.AssertEq(t, header.Name, "Files")
+
+			index, err := imgutil.LayerIndex(img)
+			h.AssertNil(t, err)
+			_, ok := index.Find("/app/main.js")
+			h.AssertEq(t, ok, true)
+		})
+	})
+}
@@ -0,0 +1,278 @@
+// Package sign signs images saved to a registry and verifies their signatures.
+//
+// Signatures are stored next to the signed image as an OCI artifact tagged "sha256-<hex>.sig", in the format
+// used by cosign, so that they can be verified with either this package or cosign.
+package sign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/internal/blob"
+	imgutilremote "github.com/buildpacks/imgutil/remote"
+)
+
+const (
+	// SignatureMediaType is the media type of signature payload layers.
+	SignatureMediaType types.MediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SignatureAnnotation is the layer annotation holding the base64-encoded signature of the payload.
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	signatureType = "cosign container image signature"
+)
+
+type options struct {
+	transport http.RoundTripper
+}
+
+type Option func(*options)
+
+//WithTransport sets the http.RoundTripper used to communicate with the registry.
+//Defaults to http.DefaultTransport.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(opts *options) {
+		opts.transport = transport
+	}
+}
+
+// payload is the simple signing payload signed for an image.
+type payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// SignatureTag returns the tag of the signature artifact for the image with the given digest.
+func SignatureTag(digest name.Digest) (name.Tag, error) {
+	hash, err := v1.NewHash(digest.DigestStr())
+	if err != nil {
+		return name.Tag{}, errors.Wrapf(err, "parsing digest '%s'", digest)
+	}
+	return digest.Context().Tag(fmt.Sprintf("%s-%s.sig", hash.Algorithm, hash.Hex)), nil
+}
+
+// Sign signs the image identified by identifier, which must be the identifier of a saved remote image, with key.
+// The signature is added to the signature artifact of the image, which is created if it does not exist.
+// It returns the tag of the signature artifact.
+func Sign(identifier imgutil.Identifier, key crypto.Signer, keychain authn.Keychain, ops ...Option) (name.Tag, error) {
+	opts := newOptions(ops)
+
+	digest, err := digestFor(identifier)
+	if err != nil {
+		return name.Tag{}, err
+	}
+	tag, err := SignatureTag(digest)
+	if err != nil {
+		return name.Tag{}, err
+	}
+
+	var p payload
+	p.Critical.Identity.DockerReference = digest.Context().Name()
+	p.Critical.Image.DockerManifestDigest = digest.DigestStr()
+	p.Critical.Type = signatureType
+	payloadBytes, err := json.Marshal(p)
+	if err != nil {
+		return name.Tag{}, err
+	}
+
+	signature, err := signPayload(key, payloadBytes)
+	if err != nil {
+		return name.Tag{}, errors.Wrapf(err, "signing '%s'", digest)
+	}
+
+	layer, err := blob.NewLayer(payloadBytes, SignatureMediaType)
+	if err != nil {
+		return name.Tag{}, err
+	}
+
+	sigImage, err := signatureImage(tag, keychain, opts)
+	if err != nil {
+		return name.Tag{}, err
+	}
+	sigImage, err = mutate.Append(sigImage, mutate.Addendum{
+		Layer:       layer,
+		Annotations: map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString(signature)},
+	})
+	if err != nil {
+		return name.Tag{}, errors.Wrap(err, "adding signature")
+	}
+
+	if err := remote.Write(tag, sigImage, remote.WithAuthFromKeychain(keychain), remote.WithTransport(opts.transport)); err != nil {
+		return name.Tag{}, errors.Wrapf(err, "writing signature '%s'", tag)
+	}
+	return tag, nil
+}
+
+// Verify returns nil if the image identified by identifier has at least one signature that is valid for key.
+func Verify(identifier imgutil.Identifier, key crypto.PublicKey, keychain authn.Keychain, ops ...Option) error {
+	opts := newOptions(ops)
+
+	digest, err := digestFor(identifier)
+	if err != nil {
+		return err
+	}
+	tag, err := SignatureTag(digest)
+	if err != nil {
+		return err
+	}
+
+	sigImage, err := remote.Image(tag, remote.WithAuthFromKeychain(keychain), remote.WithTransport(opts.transport))
+	if err != nil {
+		if isNotFound(err) {
+			return fmt.Errorf("no signatures found for '%s'", digest)
+		}
+		return errors.Wrapf(err, "reading signatures '%s'", tag)
+	}
+
+	manifest, err := sigImage.Manifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range manifest.Layers {
+		if desc.MediaType != SignatureMediaType {
+			continue
+		}
+		if err := verifyLayer(sigImage, desc, digest, key); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no valid signature found for '%s'", digest)
+}
+
+func verifyLayer(sigImage v1.Image, desc v1.Descriptor, digest name.Digest, key crypto.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(desc.Annotations[SignatureAnnotation])
+	if err != nil {
+		return err
+	}
+
+	layer, err := sigImage.LayerByDigest(desc.Digest)
+	if err != nil {
+		return err
+	}
+	// payloads are stored uncompressed, so the blob is read as is
+	rc, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	payloadBytes, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+
+	if err := verifySignature(key, payloadBytes, signature); err != nil {
+		return err
+	}
+
+	var p payload
+	if err := json.Unmarshal(payloadBytes, &p); err != nil {
+		return err
+	}
+	if p.Critical.Image.DockerManifestDigest != digest.DigestStr() {
+		return fmt.Errorf("signature is for '%s'", p.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
+
+func signPayload(key crypto.Signer, payloadBytes []byte) ([]byte, error) {
+	switch key.Public().(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(payloadBytes)
+		return key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	case ed25519.PublicKey:
+		return key.Sign(rand.Reader, payloadBytes, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported key type %T: must be ECDSA or ed25519", key)
+	}
+}
+
+func verifySignature(key crypto.PublicKey, payloadBytes, signature []byte) error {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		var sig struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+			return errors.Wrap(err, "parsing ECDSA signature")
+		}
+		hash := sha256.Sum256(payloadBytes)
+		if !ecdsa.Verify(k, hash[:], sig.R, sig.S) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payloadBytes, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T: must be ECDSA or ed25519", key)
+	}
+}
+
+func signatureImage(tag name.Tag, keychain authn.Keychain, opts *options) (v1.Image, error) {
+	img, err := remote.Image(tag, remote.WithAuthFromKeychain(keychain), remote.WithTransport(opts.transport))
+	if err == nil {
+		return img, nil
+	}
+	if isNotFound(err) {
+		return mutate.MediaType(empty.Image, types.OCIManifestSchema1), nil
+	}
+	return nil, errors.Wrapf(err, "reading signatures '%s'", tag)
+}
+
+func digestFor(identifier imgutil.Identifier) (name.Digest, error) {
+	if digestIdentifier, ok := identifier.(imgutilremote.DigestIdentifier); ok {
+		return digestIdentifier.Digest, nil
+	}
+	digest, err := name.NewDigest(identifier.String(), name.WeakValidation)
+	if err != nil {
+		return name.Digest{}, errors.Wrapf(err, "identifier '%s' must reference an image in a registry by digest", identifier)
+	}
+	return digest, nil
+}
+
+func isNotFound(err error) bool {
+	if transportErr, ok := err.(*transport.Error); ok {
+		return transportErr.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+func newOptions(ops []Option) *options {
+	opts := &options{transport: http.DefaultTransport}
+	for _, op := range ops {
+		op(opts)
+	}
+	return opts
+}
+
@@ -0,0 +1,222 @@
+package sign_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/registry"
+	ggcrremote "github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/local"
+	"github.com/buildpacks/imgutil/remote"
+	"github.com/buildpacks/imgutil/sign"
+	h "github.com/buildpacks/imgutil/testhelpers"
+)
+
+func TestSign(t *testing.T) {
+	spec.Run(t, "Sign", testSign, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testSign(t *testing.T, when spec.G, it spec.S) {
+	var (
+		server     *httptest.Server
+		keyDir     string
+		identifier imgutil.Identifier
+	)
+
+	it.Before(func() {
+		var err error
+		server = httptest.NewServer(registry.New(registry.Logger(log.New(ioutil.Discard, "", 0))))
+
+		keyDir, err = ioutil.TempDir("", "sign-keys")
+		h.AssertNil(t, err)
+
+		img, err := remote.NewImage(strings.TrimPrefix(server.URL, "http://")+"/some-image", authn.DefaultKeychain)
+		h.AssertNil(t, err)
+		h.AssertNil(t, img.SetLabel("some-label", "some-value"))
+		h.AssertNil(t, img.Save())
+
+		identifier, err = img.Identifier()
+		h.AssertNil(t, err)
+	})
+
+	it.After(func() {
+		server.Close()
+		h.AssertNil(t, os.RemoveAll(keyDir))
+	})
+
+	writeKeys := func(private crypto.Signer, privateType string, privateDER []byte) (string, string) {
+		publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+		h.AssertNil(t, err)
+
+		privatePath := filepath.Join(keyDir, h.RandString(10)+".key")
+		publicPath := filepath.Join(keyDir, h.RandString(10)+".pub")
+		h.AssertNil(t, ioutil.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: privateType, Bytes: privateDER}), 0600))
+		h.AssertNil(t, ioutil.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0600))
+		return privatePath, publicPath
+	}
+
+	ecdsaKeys := func() (string, string) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		h.AssertNil(t, err)
+		der, err := x509.MarshalECPrivateKey(key)
+		h.AssertNil(t, err)
+		return writeKeys(key, "EC PRIVATE KEY", der)
+	}
+
+	ed25519Keys := func() (string, string) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		h.AssertNil(t, err)
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		h.AssertNil(t, err)
+		return writeKeys(key, "PRIVATE KEY", der)
+	}
+
+	signAndVerify := func(privatePath, publicPath string) {
+		privateKey, err := sign.LoadPrivateKey(privatePath)
+		h.AssertNil(t, err)
+		publicKey, err := sign.LoadPublicKey(publicPath)
+		h.AssertNil(t, err)
+
+		_, err = sign.Sign(identifier, privateKey, authn.DefaultKeychain)
+		h.AssertNil(t, err)
+
+		h.AssertNil(t, sign.Verify(identifier, publicKey, authn.DefaultKeychain))
+	}
+
+	when("#Sign", func() {
+		it("stores the signature next to the image", func() {
+			privatePath, _ := ecdsaKeys()
+			privateKey, err := sign.LoadPrivateKey(privatePath)
+			h.AssertNil(t, err)
+
+			tag, err := sign.Sign(identifier, privateKey, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			digest := identifier.(remote.DigestIdentifier).Digest
+			h.AssertEq(t, tag.Context().Name(), digest.Context().Name())
+			h.AssertEq(t, tag.TagStr(), strings.Replace(digest.DigestStr(), ":", "-", 1)+".sig")
+
+			sigImage, err := ggcrremote.Image(tag)
+			h.AssertNil(t, err)
+			manifest, err := sigImage.Manifest()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(manifest.Layers), 1)
+			h.AssertEq(t, manifest.Layers[0].MediaType, sign.SignatureMediaType)
+			h.AssertNotEq(t, manifest.Layers[0].Annotations[sign.SignatureAnnotation], "")
+		})
+
+		it("appends to existing signatures", func() {
+			firstPrivatePath, firstPublicPath := ecdsaKeys()
+			secondPrivatePath, secondPublicPath := ed25519Keys()
+			signAndVerify(firstPrivatePath, firstPublicPath)
+			signAndVerify(secondPrivatePath, secondPublicPath)
+
+			firstPublicKey, err := sign.LoadPublicKey(firstPublicPath)
+			h.AssertNil(t, err)
+			h.AssertNil(t, sign.Verify(identifier, firstPublicKey, authn.DefaultKeychain))
+
+			tag, err := sign.SignatureTag(identifier.(remote.DigestIdentifier).Digest)
+			h.AssertNil(t, err)
+			sigImage, err := ggcrremote.Image(tag)
+			h.AssertNil(t, err)
+			layers, err := sigImage.Layers()
+			h.AssertNil(t, err)
+			h.AssertEq(t, len(layers), 2)
+		})
+
+		it("returns an error for local identifiers", func() {
+			privatePath, _ := ecdsaKeys()
+			privateKey, err := sign.LoadPrivateKey(privatePath)
+			h.AssertNil(t, err)
+
+			_, err = sign.Sign(local.IDIdentifier{ImageID: "some-image-id"}, privateKey, authn.DefaultKeychain)
+			h.AssertError(t, err, "must reference an image in a registry by digest")
+		})
+	})
+
+	when("#Verify", func() {
+		it("verifies ECDSA signatures", func() {
+			signAndVerify(ecdsaKeys())
+		})
+
+		it("verifies ed25519 signatures", func() {
+			signAndVerify(ed25519Keys())
+		})
+
+		it("fails for an unsigned image", func() {
+			_, publicPath := ecdsaKeys()
+			publicKey, err := sign.LoadPublicKey(publicPath)
+			h.AssertNil(t, err)
+
+			h.AssertError(t, sign.Verify(identifier, publicKey, authn.DefaultKeychain), "no signatures found")
+		})
+
+		it("fails for a signature made with another key", func() {
+			privatePath, _ := ecdsaKeys()
+			_, otherPublicPath := ecdsaKeys()
+
+			privateKey, err := sign.LoadPrivateKey(privatePath)
+			h.AssertNil(t, err)
+			_, err = sign.Sign(identifier, privateKey, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			otherPublicKey, err := sign.LoadPublicKey(otherPublicPath)
+			h.AssertNil(t, err)
+			h.AssertError(t, sign.Verify(identifier, otherPublicKey, authn.DefaultKeychain), "no valid signature found")
+		})
+
+		it("fails for a signature of another image", func() {
+			privatePath, publicPath := ecdsaKeys()
+			privateKey, err := sign.LoadPrivateKey(privatePath)
+			h.AssertNil(t, err)
+			publicKey, err := sign.LoadPublicKey(publicPath)
+			h.AssertNil(t, err)
+
+			tag, err := sign.Sign(identifier, privateKey, authn.DefaultKeychain)
+			h.AssertNil(t, err)
+
+			other, err := remote.NewImage(tag.Context().Name(), authn.DefaultKeychain)
+			h.AssertNil(t, err)
+			h.AssertNil(t, other.SetLabel("other", "image"))
+			h.AssertNil(t, other.Save())
+			otherID, err := other.Identifier()
+			h.AssertNil(t, err)
+
+			// copy the signature of the first image to the second image's signature tag
+			otherTag, err := sign.SignatureTag(otherID.(remote.DigestIdentifier).Digest)
+			h.AssertNil(t, err)
+			sigImage, err := ggcrremote.Image(tag)
+			h.AssertNil(t, err)
+			h.AssertNil(t, ggcrremote.Write(otherTag, sigImage))
+
+			h.AssertError(t, sign.Verify(otherID, publicKey, authn.DefaultKeychain), "no valid signature found")
+		})
+	})
+
+	when("#LoadPrivateKey", func() {
+		it("rejects unsupported key types", func() {
+			path := filepath.Join(keyDir, "rsa.key")
+			h.AssertNil(t, ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("key")}), 0600))
+
+			_, err := sign.LoadPrivateKey(path)
+			h.AssertError(t, err, "unsupported PEM block type 'RSA PRIVATE KEY'")
+		})
+	})
+}
@@ -0,0 +1,267 @@
+package imgutil
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/imgutil/layer"
+)
+
+// layerSplitter distributes the entries of a filesystem or of a layer to several layer tars. Each layer has the parent
+// directories of its entries, with the headers they have in the source, so that stacking the layers recreates the
+// source.
+type layerSplitter struct {
+	dir       string
+	newWriter func(io.Writer) layerTarWriter
+	layerName func(key layerKey) string
+	// synthesizeParents writes the parent directories missing from the source as directories with mode 0755
+	synthesizeParents bool
+	layers            map[layerKey]*splitLayer
+	// dirs holds the headers of the directories seen so far, by path
+	dirs map[string]tar.Header
+	// fileLayers holds the layer of each file written so far, by path
+	fileLayers map[string]layerKey
+}
+
+type layerTarWriter interface {
+	WriteHeader(header *tar.Header) error
+	Write(content []byte) (int, error)
+	Close() error
+}
+
+// layerKey orders the layers of a layerSplitter by group, e.g. a path rule, and then by chunk within the group.
+type layerKey struct {
+	group int
+	chunk int
+}
+
+func (k layerKey) before(other layerKey) bool {
+	if k.group != other.group {
+		return k.group < other.group
+	}
+	return k.chunk < other.chunk
+}
+
+type splitLayer struct {
+	path    string
+	file    *os.File
+	hasher  hash.Hash
+	writer  layerTarWriter
+	written map[string]bool
+	size    int64
+}
+
+// layerTar is a layer tar written by a layerSplitter.
+type layerTar struct {
+	path   string
+	diffID string
+}
+
+// newFilesystemSplitter returns a splitter of filesystem entries with absolute paths, written as layers for os. Its
+// layers are not chunked, and are named by group.
+func newFilesystemSplitter(dir, os string) *layerSplitter {
+	s := newLayerSplitter(dir, func(w io.Writer) layerTarWriter {
+		return layer.NewWriter(w, os)
+	})
+	s.layerName = func(key layerKey) string {
+		return fmt.Sprintf("layer-%d.tar", key.group)
+	}
+	s.synthesizeParents = true
+	return s
+}
+
+// newLayerTarSplitter returns a splitter of layer tar entries, which are written as they are.
+func newLayerTarSplitter(dir string) *layerSplitter {
+	return newLayerSplitter(dir, func(w io.Writer) layerTarWriter {
+		return tar.NewWriter(w)
+	})
+}
+
+func newLayerSplitter(dir string, newWriter func(io.Writer) layerTarWriter) *layerSplitter {
+	return &layerSplitter{
+		dir:       dir,
+		newWriter: newWriter,
+		layerName: func(key layerKey) string {
+			return fmt.Sprintf("layer-%d-%d.tar", key.group, key.chunk)
+		},
+		layers:     map[layerKey]*splitLayer{},
+		dirs:       map[string]tar.Header{},
+		fileLayers: map[string]layerKey{},
+	}
+}
+
+// filesystemEntry normalizes header, read from a tar or a directory, to an absolute, posix path and a normalized
+// modification time. It returns false for the root directory.
+func filesystemEntry(header *tar.Header) bool {
+	header.Name = entryPath(filepath.ToSlash(header.Name))
+	if header.Name == "/" {
+		return false
+	}
+	if header.Typeflag == tar.TypeLink {
+		header.Linkname = entryPath(header.Linkname)
+	}
+	header.ModTime = NormalizedDateTime
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	return true
+}
+
+// entryPath returns the absolute, posix path of the tar entry name.
+func entryPath(name string) string {
+	return path.Clean("/" + strings.TrimPrefix(name, "./"))
+}
+
+// linkLayer returns the layer of the target of a hardlink, which must have been written before.
+func (s *layerSplitter) linkLayer(header *tar.Header) (layerKey, error) {
+	key, ok := s.fileLayers[entryPath(header.Linkname)]
+	if !ok {
+		return layerKey{}, fmt.Errorf("hardlink '%s' to '%s' precedes its target", header.Name, header.Linkname)
+	}
+	return key, nil
+}
+
+// write adds the entry with header and contents to the layer with key. Hardlinks are added to the layer of their
+// target instead.
+func (s *layerSplitter) write(key layerKey, header *tar.Header, contents io.Reader) error {
+	if header.Typeflag == tar.TypeLink {
+		var err error
+		if key, err = s.linkLayer(header); err != nil {
+			return err
+		}
+	}
+
+	l, err := s.layer(key)
+	if err != nil {
+		return err
+	}
+	p := entryPath(header.Name)
+	if err := s.writeParents(l, p); err != nil {
+		return err
+	}
+
+	if header.Typeflag == tar.TypeDir {
+		s.dirs[p] = *header
+		if l.written[p] {
+			return nil
+		}
+		l.written[p] = true
+	} else {
+		s.fileLayers[p] = key
+	}
+
+	entry := *header
+	if err := l.writer.WriteHeader(&entry); err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+		n, err := io.Copy(l.writer, contents)
+		if err != nil {
+			return err
+		}
+		l.size += n
+	}
+	return nil
+}
+
+// size returns the size of the file contents written to the layer with key so far.
+func (s *layerSplitter) size(key layerKey) int64 {
+	if l, ok := s.layers[key]; ok {
+		return l.size
+	}
+	return 0
+}
+
+// writeParents writes the parent directories of filePath that are not written to l yet. Parents missing from the
+// source are only written when synthesizeParents is set.
+func (s *layerSplitter) writeParents(l *splitLayer, filePath string) error {
+	var parents []string
+	for dir := path.Dir(filePath); dir != "/" && !l.written[dir]; dir = path.Dir(dir) {
+		parents = append(parents, dir)
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		header, ok := s.dirs[parents[i]]
+		if !ok {
+			if !s.synthesizeParents {
+				continue
+			}
+			header = tar.Header{Name: parents[i], Typeflag: tar.TypeDir, Mode: 0755, ModTime: NormalizedDateTime}
+		}
+		if err := l.writer.WriteHeader(&header); err != nil {
+			return err
+		}
+		l.written[parents[i]] = true
+	}
+	return nil
+}
+
+// layer returns the layer with key, creating its tar when it was not used yet.
+func (s *layerSplitter) layer(key layerKey) (*splitLayer, error) {
+	if l, ok := s.layers[key]; ok {
+		return l, nil
+	}
+
+	layerPath := filepath.Join(s.dir, s.layerName(key))
+	f, err := os.Create(layerPath)
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	l := &splitLayer{
+		path:    layerPath,
+		file:    f,
+		hasher:  hasher,
+		writer:  s.newWriter(io.MultiWriter(f, hasher)),
+		written: map[string]bool{},
+	}
+	s.layers[key] = l
+	return l, nil
+}
+
+// close finishes the layer tars and returns them ordered by key.
+func (s *layerSplitter) close() ([]layerTar, error) {
+	var keys []layerKey
+	for key := range s.layers {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].before(keys[j])
+	})
+
+	var layers []layerTar
+	var closeErr error
+	for _, key := range keys {
+		l := s.layers[key]
+		if err := l.writer.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+		if err := l.file.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+		layers = append(layers, layerTar{path: l.path, diffID: "sha256:" + hex.EncodeToString(l.hasher.Sum(nil))})
+	}
+	return layers, closeErr
+}
+
+// addLayerTars adds layers to img, in order, and returns their paths.
+func addLayerTars(img Image, layers []layerTar) ([]string, error) {
+	var paths []string
+	for _, l := range layers {
+		if err := img.AddLayerWithDiffID(l.path, l.diffID); err != nil {
+			return nil, errors.Wrapf(err, "adding layer '%s'", l.path)
+		}
+		paths = append(paths, l.path)
+	}
+	return paths, nil
+}
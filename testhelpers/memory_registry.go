@@ -0,0 +1,234 @@
+package testhelpers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+)
+
+// InMemoryRegistry is a registry served in-process, offering the same API as DockerRegistry without requiring a
+// Docker daemon. Faults can be injected to test how clients handle failing or slow registries.
+type InMemoryRegistry struct {
+	Port            string
+	DockerDirectory string
+	username        string
+	password        string
+	server          *httptest.Server
+
+	mu     sync.Mutex
+	faults []*Fault
+}
+
+// Fault changes the response of the registry to the requests it matches.
+type Fault struct {
+	// Method limits the fault to requests with this HTTP method. Empty matches every method.
+	Method string
+	// Path limits the fault to requests whose path contains this value, e.g. "/manifests/". Empty matches every path.
+	Path string
+	// Delay is waited before responding.
+	Delay time.Duration
+	// StatusCode is returned instead of serving the request. Zero serves the request after Delay.
+	StatusCode int
+	// Unauthorized rejects the request as if its credentials were invalid.
+	Unauthorized bool
+	// Handler serves the request after Delay instead of the registry, e.g. to emulate APIs the registry lacks.
+	Handler http.HandlerFunc
+	// Times is the number of requests the fault applies to. Zero applies it to every matching request.
+	Times int
+}
+
+func NewInMemoryRegistry() *InMemoryRegistry {
+	return &InMemoryRegistry{}
+}
+
+func NewInMemoryRegistryWithAuth(dockerConfigDir string) *InMemoryRegistry {
+	return &InMemoryRegistry{
+		username:        RandString(10),
+		password:        RandString(10),
+		DockerDirectory: dockerConfigDir,
+	}
+}
+
+func (r *InMemoryRegistry) Start(t *testing.T) {
+	t.Log("run in-memory registry")
+	t.Helper()
+
+	handler := registry.New(registry.Logger(log.New(ioutil.Discard, "", 0)))
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.serve(handler, w, req)
+	}))
+
+	_, port, err := net.SplitHostPort(r.server.Listener.Addr().String())
+	AssertNil(t, err)
+	r.Port = port
+
+	if r.username != "" {
+		writeDockerConfig(t, r.DockerDirectory, r.Port, r.encodedAuth())
+	}
+}
+
+func (r *InMemoryRegistry) Stop(t *testing.T) {
+	t.Log("stop in-memory registry")
+	t.Helper()
+	if r.server != nil {
+		r.server.Close()
+	}
+}
+
+func (r *InMemoryRegistry) RepoName(name string) string {
+	return "localhost:" + r.Port + "/" + name
+}
+
+func (r *InMemoryRegistry) EncodedLabeledAuth() string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(`{"username":"%s","password":"%s"}`, r.username, r.password)))
+}
+
+func (r *InMemoryRegistry) encodedAuth() string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", r.username, r.password)))
+}
+
+// InjectFault applies fault to the following matching requests. Faults are matched in the order they were injected.
+func (r *InMemoryRegistry) InjectFault(fault Fault) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.faults = append(r.faults, &fault)
+}
+
+// ClearFaults removes every injected fault.
+func (r *InMemoryRegistry) ClearFaults() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.faults = nil
+}
+
+func (r *InMemoryRegistry) serve(handler http.Handler, w http.ResponseWriter, req *http.Request) {
+	if fault, ok := r.nextFault(req); ok {
+		time.Sleep(fault.Delay)
+		if fault.Unauthorized {
+			r.unauthorized(w)
+			return
+		}
+		if fault.Handler != nil {
+			fault.Handler(w, req)
+			return
+		}
+		if fault.StatusCode != 0 {
+			http.Error(w, http.StatusText(fault.StatusCode), fault.StatusCode)
+			return
+		}
+	}
+
+	if r.username != "" {
+		username, password, ok := req.BasicAuth()
+		if !ok || username != r.username || password != r.password {
+			r.unauthorized(w)
+			return
+		}
+	}
+
+	if req.Method == http.MethodDelete && strings.Contains(req.URL.Path, "/manifests/") {
+		r.deleteManifest(handler, w, req)
+		return
+	}
+	if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/blobs/uploads/") && req.URL.Query().Get("mount") != "" {
+		r.mountBlob(handler, w, req)
+		return
+	}
+
+	handler.ServeHTTP(w, req)
+}
+
+// deleteManifest deletes a manifest like registry:2 does, removing the tags that refer to it as well.
+func (r *InMemoryRegistry) deleteManifest(handler http.Handler, w http.ResponseWriter, req *http.Request) {
+	manifestPath := req.URL.Path
+	repoPath := manifestPath[:strings.LastIndex(manifestPath, "/manifests/")]
+
+	head := serveRecorded(handler, http.MethodHead, manifestPath)
+	if head.Code != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`))
+		return
+	}
+	digest := head.Header().Get("Docker-Content-Digest")
+
+	var tags struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.Unmarshal(serveRecorded(handler, http.MethodGet, repoPath+"/tags/list").Body.Bytes(), &tags); err == nil {
+		for _, tag := range tags.Tags {
+			tagPath := repoPath + "/manifests/" + tag
+			if serveRecorded(handler, http.MethodHead, tagPath).Header().Get("Docker-Content-Digest") == digest {
+				serveRecorded(handler, http.MethodDelete, tagPath)
+			}
+		}
+	}
+
+	handler.ServeHTTP(w, req)
+}
+
+// mountBlob mounts a blob from another repository like registry:2 does, answering 201 Created when the blob exists
+// and starting a regular upload otherwise.
+func (r *InMemoryRegistry) mountBlob(handler http.Handler, w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	digest, from := query.Get("mount"), query.Get("from")
+	if from == "" || serveRecorded(handler, http.MethodHead, "/v2/"+from+"/blobs/"+digest).Code != http.StatusOK {
+		query.Del("mount")
+		query.Del("from")
+		req.URL.RawQuery = query.Encode()
+		handler.ServeHTTP(w, req)
+		return
+	}
+
+	repoPath := strings.TrimSuffix(req.URL.Path, "/uploads/")
+	w.Header().Set("Location", repoPath+"/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func serveRecorded(handler http.Handler, method, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder
+}
+
+func (r *InMemoryRegistry) nextFault(req *http.Request) (Fault, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for idx, fault := range r.faults {
+		if fault.Method != "" && fault.Method != req.Method {
+			continue
+		}
+		if fault.Path != "" && !strings.Contains(req.URL.Path, fault.Path) {
+			continue
+		}
+
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				r.faults = append(r.faults[:idx], r.faults[idx+1:]...)
+			}
+		}
+		return *fault, true
+	}
+	return Fault{}, false
+}
+
+func (r *InMemoryRegistry) unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Registry Realm"`)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_, _ = w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`))
+}
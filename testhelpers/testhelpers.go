@@ -362,3 +362,64 @@ func StringElementAt(elements []string, offset int) string {
 	}
 	return elements[offset]
 }
+
+// LoggedEvent is an event received by a RecordingLogger.
+type LoggedEvent struct {
+	Event  string
+	Fields map[string]interface{}
+}
+
+// RecordingLogger is an imgutil.Logger that records every event it receives.
+type RecordingLogger struct {
+	mutex  sync.Mutex
+	Events []LoggedEvent
+}
+
+func (l *RecordingLogger) Debug(event string, keysAndValues ...interface{}) {
+	fields := map[string]interface{}{}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.Events = append(l.Events, LoggedEvent{Event: event, Fields: fields})
+}
+
+// EventsNamed returns the recorded events with the given name.
+func (l *RecordingLogger) EventsNamed(event string) []LoggedEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var events []LoggedEvent
+	for _, e := range l.Events {
+		if e.Event == event {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// RecordingMetrics is an imgutil.Metrics that records every measurement it receives.
+type RecordingMetrics struct {
+	mutex      sync.Mutex
+	Counters   map[string]float64
+	Histograms map[string][]float64
+}
+
+func NewRecordingMetrics() *RecordingMetrics {
+	return &RecordingMetrics{
+		Counters:   map[string]float64{},
+		Histograms: map[string][]float64{},
+	}
+}
+
+func (m *RecordingMetrics) Counter(name string, value float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Counters[name] += value
+}
+
+func (m *RecordingMetrics) Histogram(name string, value float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Histograms[name] = append(m.Histograms[name], value)
+}